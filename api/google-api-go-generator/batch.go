@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+)
+
+// defaultBatchSize is the maximum number of calls in a batch request.
+const defaultBatchSize = 1000
+
+// batchSizes holds the maximum number of calls in a batch request to the APIs
+// whose limit is lower than defaultBatchSize.
+var batchSizes = map[string]int{
+	"drive":   100,
+	"gmail":   100,
+	"storage": 100,
+}
+
+// reserveBatchType assigns the name of the API's Batch type, if the API has a
+// batch endpoint. It must be called after the schema structs have been written.
+func (a *API) reserveBatchType() {
+	if a.doc.BatchPath == "" || a.doc.RootURL == "" {
+		return
+	}
+	for _, m := range a.doc.Methods {
+		if initialCap(m.Name) == "NewBatch" {
+			log.Printf("%s: method %s clashes with NewBatch; not generating batch support", a.doc.ID, m.Name)
+			return
+		}
+	}
+	a.batchType = a.GetName("Batch")
+}
+
+// writeBatchType writes the Batch type and the NewBatch method of the service.
+func (a *API) writeBatchType() {
+	if a.batchType == "" {
+		return
+	}
+	pn := a.pn
+	size, ok := batchSizes[a.Name]
+	if !ok {
+		size = defaultBatchSize
+	}
+	t := a.batchType
+
+	pn("\n// %s is a batch of calls to the service that are sent together, in as", t)
+	pn("// few HTTP requests as possible. Add calls to it with their Batch methods,")
+	pn("// and send them with Do.")
+	pn("type %s struct {", t)
+	pn(" b *gensupport.Batch")
+	pn("}")
+
+	pn("\n// NewBatch returns an empty batch of calls to the service. At most %d calls", size)
+	pn("// are sent in each HTTP request.")
+	pn("func (s *%s) NewBatch() *%s {", a.ServiceType(), t)
+	pn(" return &%s{b: &gensupport.Batch{", t)
+	pn("  Client: s.client,")
+	pn("  URL: gensupport.BatchURL(s.BasePath, %q, %q),", a.doc.ServicePath, a.doc.BatchPath)
+	pn("  UserAgent: s.userAgent(),")
+	pn("  MaxSize: %d,", size)
+	pn(" }}")
+	pn("}")
+
+	pn("\n// Len returns the number of calls in the batch that have not been sent.")
+	pn("func (b *%s) Len() int { return b.b.Len() }", t)
+
+	pn("\n// Do sends the calls in the batch, and then calls the function passed to the")
+	pn("// Batch method of each call with its result, in the order the calls were")
+	pn("// added. If an HTTP request fails as a whole, Do returns the error, which is")
+	pn("// also passed to the function of each call in the request. The batch is")
+	pn("// empty afterwards.")
+	pn("func (b *%s) Do(ctx context.Context) error { return b.b.Do(ctx) }", t)
+}
+
+// writeCallBatch writes the Batch method of a call, which adds the call to a
+// batch.
+func (a *API) writeCallBatch(meth *Method, callName, retType string) {
+	if a.batchType == "" || meth.supportsMediaUpload() || meth.IsRawResponse() {
+		return
+	}
+	for _, opt := range meth.OptParams() {
+		if initialCap(opt.p.Name) == "Batch" {
+			return
+		}
+	}
+	p, pn := a.p, a.pn
+	p("\n%s", asComment("", fmt.Sprintf("Batch adds the %q call to b, to be sent when b.Do is called. "+
+		"After the call is sent, f is called with its result, as Do would have returned it.", meth.m.ID)))
+	if retType == "" {
+		pn("func (c *%s) Batch(b *%s, f func(error), opts ...googleapi.CallOption) {", callName, a.batchType)
+	} else {
+		pn("func (c *%s) Batch(b *%s, f func(%s, error), opts ...googleapi.CallOption) {", callName, a.batchType, retType)
+	}
+	pn(" b.b.Add(func(ctx context.Context) func() {")
+	pn("  c.ctx_ = ctx")
+	if retType == "" {
+		pn("  err := c.Do(opts...)")
+		pn("  return func() { f(err) }")
+	} else {
+		pn("  ret, err := c.Do(opts...)")
+		pn("  return func() { f(ret, err) }")
+	}
+	pn(" })")
+	pn("}")
+}
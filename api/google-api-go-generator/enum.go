@@ -0,0 +1,118 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/google-api-go-generator/internal/disco"
+)
+
+// enumType is a named string type generated for the values of an enum field
+// or parameter. The fields and parameters themselves stay plain strings, and
+// the values are untyped constants, so existing code keeps compiling.
+type enumType struct {
+	preferred string // preferred Go name of the type
+	doc       string // doc comment format, taking the Go name of the type
+	field     Field
+}
+
+// addEnumType records an enum type to be written by writeEnumTypes.
+func (a *API) addEnumType(preferred, doc string, field Field) {
+	if _, ok := field.Enum(); !ok {
+		return
+	}
+	a.enums = append(a.enums, &enumType{preferred: preferred, doc: doc, field: field})
+}
+
+// writeEnumTypes writes the enum types. They are named after everything else
+// in the package, so that adding them never renames existing identifiers.
+func (a *API) writeEnumTypes() {
+	if len(a.enums) == 0 {
+		return
+	}
+	// Reserve the package-level names that aren't assigned by GetName.
+	for _, scope := range a.doc.Auth.OAuth2Scopes {
+		a.GetName(scopeIdentifier(scope))
+	}
+	a.reserveResourceNames(a.doc.Resources)
+
+	for _, e := range a.enums {
+		e.write(a)
+	}
+}
+
+func (a *API) reserveResourceNames(rs []*disco.Resource) {
+	for _, r := range rs {
+		a.GetName(resourceGoType(r))
+		a.GetName("New" + resourceGoType(r))
+		a.reserveResourceNames(r.Resources)
+	}
+}
+
+func (e *enumType) write(a *API) {
+	p, pn := a.p, a.pn
+	enum, _ := e.field.Enum()
+	desc := e.field.EnumDescriptions()
+	typeName := a.GetName(e.preferred)
+
+	p("\n%s", asComment("", fmt.Sprintf(e.doc, typeName)))
+	pn("type %s string", typeName)
+
+	p("\n%s", asComment("", fmt.Sprintf("Possible values of %s. The constants are untyped, so they can also be used as plain strings.", typeName)))
+	pn("const (")
+	seen := make(map[string]bool)
+	var consts []string
+	for i, v := range enum {
+		if seen[v] {
+			continue
+		}
+		seen[v] = true
+		name := a.GetName(typeName + enumValueGoName(v))
+		if i < len(desc) && desc[i] != "" {
+			if len(consts) > 0 {
+				p("\n")
+			}
+			p("%s", asComment("\t", fmt.Sprintf("%s: %s", name, desc[i])))
+		}
+		pn("\t%s = %q", name, v)
+		consts = append(consts, name)
+	}
+	pn(")")
+
+	p("\n%s", asComment("", fmt.Sprintf("Valid reports whether v is one of the possible values of %s.", typeName)))
+	pn("func (v %s) Valid() bool {", typeName)
+	pn(" switch v {")
+	pn(" case %s:", strings.Join(consts, ",\n"))
+	pn("  return true")
+	pn(" }")
+	pn(" return false")
+	pn("}")
+}
+
+// enumValueGoName returns the Go name for the enum value v, to be appended to
+// the name of its type. For example, "STATE_UNSPECIFIED" becomes
+// "StateUnspecified" and "fooBar" becomes "FooBar".
+func enumValueGoName(v string) string {
+	words := strings.FieldsFunc(v, func(c rune) bool {
+		return !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9')
+	})
+	var buf bytes.Buffer
+	for _, w := range words {
+		if strings.ToUpper(w) == w {
+			w = strings.ToLower(w)
+		}
+		buf.WriteString(strings.ToUpper(w[:1]) + w[1:])
+	}
+	if buf.Len() == 0 {
+		if v == "" {
+			return "Empty"
+		}
+		return "Value"
+	}
+	return buf.String()
+}
@@ -0,0 +1,317 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"strings"
+
+	"google.golang.org/api/google-api-go-generator/internal/disco"
+)
+
+// fakeMethod is a method of the API as seen by the fake server.
+type fakeMethod struct {
+	field   string // name of the Server field that implements the method
+	meth    *Method
+	retType string // qualified Go result type, or "" if the method has no result
+}
+
+// fakeCollection describes a standard collection, whose resources the fake
+// server keeps in memory.
+type fakeCollection struct {
+	varName    string
+	idParam    string
+	idField    string
+	itemsField string
+	resource   *Schema
+	list       *Schema
+	getPath    string
+	listPath   string
+}
+
+// GenerateFake generates the code of the fake server package for the API. It
+// must be called after GenerateCode.
+func (a *API) GenerateFake() ([]byte, error) {
+	pkg := a.Package()
+	fpkg := pkg + "fake"
+
+	var buf bytes.Buffer
+	p := func(format string, args ...interface{}) {
+		if _, err := fmt.Fprintf(&buf, format, args...); err != nil {
+			panic(err)
+		}
+	}
+	pn := func(format string, args ...interface{}) {
+		p(format+"\n", args...)
+	}
+
+	pn(`// Copyright %s Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Code generated file. DO NOT EDIT.
+`, *copyrightYear)
+	pn("// Package %s provides a fake server for the %s, for testing code that", fpkg, a.doc.Title)
+	pn("// uses package %s.", pkg)
+	pn("//")
+	pn("// Usage example:")
+	pn("//")
+	pn("//   fake := %s.NewServer()", fpkg)
+	pn("//   ts := httptest.NewServer(fake)")
+	pn("//   defer ts.Close()")
+	pn("//   %sService, err := %s.NewService(ctx, %s.ClientOptions(ts.URL)...)", pkg, pkg, fpkg)
+	pn("package %s // import %q", fpkg, a.Target()+"/"+fpkg)
+	pn("\nimport (")
+	pn(" \"net/http\"")
+	pn("")
+	pn(" fakeserver %q", *fakeserverPkg)
+	pn(" option %q", *optionPkg)
+	pn(" %s %q", pkg, a.Target())
+	pn(")")
+
+	prefix := a.doc.ServicePath
+	if a.doc.RootURL == "" {
+		prefix = strings.TrimPrefix(a.doc.BasePath, "/")
+	}
+	pn("\n// ClientOptions returns the options that make a %s.%s send its requests", pkg, a.ServiceType())
+	pn("// to the fake server at url, without authentication.")
+	pn("func ClientOptions(url string) []option.ClientOption {")
+	pn(" return []option.ClientOption{")
+	pn("  option.WithEndpoint(url + %q),", "/"+prefix)
+	pn("  option.WithoutAuthentication(),")
+	pn(" }")
+	pn("}")
+
+	pn("\n// Request is a request received by the fake server. It is passed to the")
+	pn("// function fields of Server.")
+	pn("type Request = fakeserver.Request")
+
+	var methods []*fakeMethod
+	np := new(namePool)
+	np.Get("router")
+	addMethods := func(r *disco.Resource, ms []*Method) {
+		for _, m := range ms {
+			name := initialCap(m.m.Name)
+			if r != nil {
+				name = initialCap(r.FullName) + name
+			}
+			methods = append(methods, &fakeMethod{
+				field:   np.Get(name),
+				meth:    m,
+				retType: qualifyType(responseType(a, m.m), pkg),
+			})
+		}
+	}
+	addMethods(nil, a.APIMethods())
+	var collections []*fakeCollection
+	colls := make(map[*disco.Resource]*fakeCollection)
+	var visit func(rs []*disco.Resource)
+	visit = func(rs []*disco.Resource) {
+		for _, r := range rs {
+			addMethods(r, a.resourceMethods(r))
+			if c := a.fakeCollection(r); c != nil {
+				collections = append(collections, c)
+				colls[r] = c
+			}
+			visit(r.Resources)
+		}
+	}
+	visit(a.doc.Resources)
+	usesPkg := false
+	for _, m := range methods {
+		usesPkg = usesPkg || strings.Contains(m.retType, pkg+".")
+	}
+	if !usesPkg {
+		pn("\nvar _ = %s.NewService", pkg)
+	}
+
+	pn("\n// Server is a fake server for the %s. It implements http.Handler.", a.doc.Title)
+	pn("// Create one with NewServer.")
+	pn("//")
+	pn("// Each function field implements a method of the API, and can be replaced.")
+	pn("// A method whose field is nil fails with 501 Not Implemented.")
+	pn("type Server struct {")
+	for i, m := range methods {
+		if i > 0 {
+			pn("")
+		}
+		pn(" // %s implements the %q method.", m.field, m.meth.m.ID)
+		if m.retType == "" {
+			pn(" %s func(r *Request) error", m.field)
+		} else {
+			pn(" %s func(r *Request) (%s, error)", m.field, m.retType)
+		}
+	}
+	if len(methods) > 0 {
+		pn("")
+	}
+	pn(" router fakeserver.Router")
+	pn("}")
+
+	pn("\n// NewServer returns a new Server. The methods of standard collections, which")
+	pn("// get, list, insert, patch, update and delete resources, keep the resources")
+	pn("// in memory. The fields for the other methods are nil.")
+	pn("func NewServer() *Server {")
+	pn(" s := &Server{}")
+	if a.needsDataWrapper() {
+		pn(" s.router.DataWrapper = true")
+	}
+	for _, c := range collections {
+		pn(" %s := &fakeserver.Collection{IDParam: %q, IDField: %q, ItemsField: %q}", c.varName, c.idParam, c.idField, c.itemsField)
+	}
+	for _, m := range methods {
+		if c := colls[m.meth.r]; c != nil {
+			a.writeFakeDefault(pn, c, m, pkg)
+		}
+	}
+	for _, m := range methods {
+		path := m.meth.m.Path
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + prefix + path
+		}
+		pn(" s.router.Handle(%q, %q, func(r *fakeserver.Request) (interface{}, error) {", m.meth.m.HTTPMethod, path)
+		pn("  if s.%s == nil { return nil, fakeserver.ErrNotImplemented }", m.field)
+		if m.retType == "" {
+			pn("  return nil, s.%s(r)", m.field)
+		} else {
+			pn("  return s.%s(r)", m.field)
+		}
+		pn(" })")
+	}
+	pn(" return s")
+	pn("}")
+
+	pn("\n// ServeHTTP implements http.Handler.")
+	pn("func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {")
+	pn(" s.router.ServeHTTP(w, r)")
+	pn("}")
+
+	clean, err := format.Source(buf.Bytes())
+	if err != nil {
+		return buf.Bytes(), err
+	}
+	return clean, nil
+}
+
+// fakeCollection returns the standard collection of the resource r, or nil if
+// r is not one. A standard collection has a get method whose path ends with
+// the ID of a resource, and a list method at the path without the ID, which
+// returns the resources in an array field.
+func (a *API) fakeCollection(r *disco.Resource) *fakeCollection {
+	var get, list *disco.Method
+	for _, m := range r.Methods {
+		switch m.Name {
+		case "get":
+			get = m
+		case "list":
+			list = m
+		}
+	}
+	if get == nil || list == nil || get.Response == nil || list.Response == nil {
+		return nil
+	}
+	c := &fakeCollection{
+		varName:  lowerFirst(initialCap(r.FullName)) + "Store",
+		getPath:  get.Path,
+		listPath: list.Path,
+		resource: a.schemas[get.Response.Ref],
+		list:     a.schemas[list.Response.Ref],
+	}
+	if c.resource == nil || c.list == nil || c.resource.typ.Kind != disco.StructKind || c.list.typ.Kind != disco.StructKind {
+		return nil
+	}
+	i := strings.LastIndex(get.Path, "/{")
+	if i < 0 || !strings.HasSuffix(get.Path, "}") || get.Path[:i] != list.Path {
+		return nil
+	}
+	c.idParam = get.Path[i+2 : len(get.Path)-1]
+	if strings.HasPrefix(c.idParam, "+") {
+		return nil
+	}
+	for _, f := range []string{"id", "name"} {
+		if hasProperty(c.resource, f, "string") {
+			c.idField = f
+			break
+		}
+	}
+	for _, p := range c.list.properties() {
+		if t := p.Type(); t.Kind == disco.ArrayKind && t.ElementSchema().Ref == c.resource.apiName {
+			c.itemsField = p.p.Name
+			break
+		}
+	}
+	if c.idField == "" || c.itemsField == "" {
+		return nil
+	}
+	return c
+}
+
+// writeFakeDefault writes the in-memory implementation of the method m of the
+// standard collection c, if it has one.
+func (a *API) writeFakeDefault(pn func(string, ...interface{}), c *fakeCollection, m *fakeMethod, pkg string) {
+	dm := m.meth.m
+	req := ""
+	if dm.Request != nil {
+		req = dm.Request.Ref
+	}
+	res := ""
+	if dm.Response != nil {
+		res = dm.Response.Ref
+	}
+	resource, list := c.resource.apiName, c.list.apiName
+	var op string
+	switch {
+	case dm.Name == "get" && dm.Path == c.getPath && res == resource:
+		op = "Get"
+	case dm.Name == "list" && dm.Path == c.listPath && res == list:
+		op = "List"
+	case (dm.Name == "insert" || dm.Name == "create") && dm.Path == c.listPath && req == resource && res == resource:
+		op = "Insert"
+	case dm.Name == "patch" && dm.Path == c.getPath && req == resource && res == resource:
+		op = "Patch"
+	case dm.Name == "update" && dm.Path == c.getPath && req == resource && res == resource:
+		op = "Update"
+	case dm.Name == "delete" && dm.Path == c.getPath:
+		if res == "" {
+			pn(" s.%s = %s.Delete", m.field, c.varName)
+			return
+		}
+		// Delete methods that return an empty message are supported too.
+		if s := a.schemas[res]; s != nil && s.typ.Kind == disco.StructKind && len(s.properties()) == 0 {
+			pn(" s.%s = func(r *Request) (%s, error) {", m.field, m.retType)
+			pn("  if err := %s.Delete(r); err != nil { return nil, err }", c.varName)
+			pn("  return new(%s), nil", strings.TrimPrefix(m.retType, "*"))
+			pn(" }")
+		}
+		return
+	default:
+		return
+	}
+	pn(" s.%s = func(r *Request) (%s, error) {", m.field, m.retType)
+	pn("  ret := new(%s)", strings.TrimPrefix(m.retType, "*"))
+	pn("  if err := %s.%s(r, ret); err != nil { return nil, err }", c.varName, op)
+	pn("  return ret, nil")
+	pn(" }")
+}
+
+// qualifyType qualifies the names of the API's types in the Go type t with
+// the package name pkg.
+func qualifyType(t, pkg string) string {
+	for _, prefix := range []string{"*", "[]", "map[string]"} {
+		if strings.HasPrefix(t, prefix) {
+			return prefix + qualifyType(t[len(prefix):], pkg)
+		}
+	}
+	if t == "" || strings.Contains(t, ".") || strings.ToLower(t[:1]) == t[:1] {
+		return t
+	}
+	return pkg + "." + t
+}
+
+func lowerFirst(s string) string {
+	return strings.ToLower(s[:1]) + s[1:]
+}
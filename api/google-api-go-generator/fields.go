@@ -0,0 +1,164 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/google-api-go-generator/internal/disco"
+)
+
+// hasFieldSelector reports whether a field selector is generated for s.
+func (s *Schema) hasFieldSelector() bool {
+	return s.typ.Kind == disco.StructKind && s.typ.Variant == nil && len(s.properties()) > 0
+}
+
+// reserveFieldSelectors assigns the names of the field selectors of the
+// API's schemas. It must be called after the schemas have been written, so
+// that the selectors never take the names of schemas.
+func (a *API) reserveFieldSelectors() {
+	for _, name := range a.sortedSchemaNames() {
+		s := a.schemas[name]
+		if !s.hasFieldSelector() {
+			continue
+		}
+		s.fieldsVar = a.GetName(s.GoName() + "Fields")
+		s.fieldsType = a.GetName(s.GoName() + "FieldSelector")
+	}
+}
+
+// fieldSchemaOf returns the Go expression for the googleapi.FieldSchema of
+// values of type t, or "" if they are not objects.
+func (a *API) fieldSchemaOf(t *disco.Schema) string {
+	switch t.Kind {
+	case disco.ReferenceKind:
+		return a.fieldSchemaOf(t.RefSchema)
+	case disco.ArrayKind:
+		return a.fieldSchemaOf(t.ElementSchema())
+	case disco.MapKind, disco.AnyStructKind:
+		return "googleapi.AnyFieldSchema"
+	case disco.StructKind:
+		if s := a.schemas[t.Name]; s != nil && s.fieldsVar != "" {
+			return s.fieldsVar
+		}
+		if t.Variant != nil {
+			return "googleapi.AnyFieldSchema"
+		}
+	}
+	return ""
+}
+
+// writeFieldSelectors writes the field selectors of the API's schemas. The
+// selector of a schema has a method for each of its fields, which returns
+// the googleapi.Field that selects it in partial responses, and implements
+// googleapi.FieldSchema so that field masks can be checked against it.
+func (a *API) writeFieldSelectors() {
+	a.reserveFieldSelectors()
+	for _, name := range a.sortedSchemaNames() {
+		if s := a.schemas[name]; s.fieldsVar != "" {
+			a.writeFieldSelector(s)
+		}
+	}
+}
+
+func (a *API) writeFieldSelector(s *Schema) {
+	p, pn := a.p, a.pn
+	typ, v := s.fieldsType, s.fieldsVar
+
+	pn("\n// %s selects fields of %s, for partial responses and field masks.", v, s.GoName())
+	pn("var %s %s", v, typ)
+	p("\n%s", asComment("", fmt.Sprintf("%s has a method for each field of %s, which returns the "+
+		"googleapi.Field that selects it, for use with the Fields method of calls. "+
+		"The methods of fields that hold objects take the subfields to select, if any.", typ, s.GoName())))
+	pn("type %s struct{}", typ)
+
+	np := new(namePool)
+	np.Get("LookupField")
+	np.Get("Mask")
+	var leaves []string
+	nested := make(map[string][]string) // FieldSchema expression -> JSON names
+	for _, prop := range s.properties() {
+		if prop.assignedGoName == "" {
+			continue
+		}
+		name := prop.p.Name
+		fs := a.fieldSchemaOf(prop.Type())
+		method := np.Get(prop.assignedGoName)
+		if fs == "" {
+			leaves = append(leaves, name)
+			pn("\n// %s selects the %q field.", method, name)
+			pn("func (%s) %s() googleapi.Field { return %q }", typ, method, name)
+			continue
+		}
+		nested[fs] = append(nested[fs], name)
+		pn("\n// %s selects the %q field, or the subfields sub of it.", method, name)
+		pn("func (%s) %s(sub ...googleapi.Field) googleapi.Field {", typ, method)
+		pn(" return googleapi.Subfields(%q, sub...)", name)
+		pn("}")
+	}
+
+	pn("\n// LookupField implements googleapi.FieldSchema.")
+	pn("func (%s) LookupField(name string) (googleapi.FieldSchema, bool) {", typ)
+	pn(" switch name {")
+	if len(leaves) > 0 {
+		pn(" case %s:", quoteAll(leaves))
+		pn("  return nil, true")
+	}
+	var exprs []string
+	for fs := range nested {
+		exprs = append(exprs, fs)
+	}
+	sort.Strings(exprs)
+	for _, fs := range exprs {
+		pn(" case %s:", quoteAll(nested[fs]))
+		pn("  return %s, true", fs)
+	}
+	pn(" }")
+	pn(" return nil, false")
+	pn("}")
+
+	p("\n%s", asComment("", fmt.Sprintf("Mask returns the field mask of the fields fs of %s, as for the "+
+		"updateMask of patch methods. It returns an error if a field is not one of %s.", s.GoName(), s.GoName())))
+	pn("func (s %s) Mask(fs ...googleapi.Field) (googleapi.FieldMask, error) {", typ)
+	pn(" return googleapi.NewFieldMask(s, fs...)")
+	pn("}")
+}
+
+// writeUpdateFieldMask writes the UpdateFieldMask method of a call to a patch
+// method that takes an updateMask parameter.
+func (a *API) writeUpdateFieldMask(meth *Method, callName string) {
+	if meth.m.HTTPMethod != "PATCH" {
+		return
+	}
+	var mask *Param
+	for _, opt := range meth.OptParams() {
+		switch initialCap(opt.p.Name) {
+		case "UpdateFieldMask":
+			return
+		case "UpdateMask":
+			mask = opt
+		}
+	}
+	if mask == nil || mask.p.Type != "string" {
+		return
+	}
+	pn := a.pn
+	pn("\n// UpdateFieldMask sets the %q parameter to m. Use the Mask method of the", mask.p.Name)
+	pn("// field selector of the request's schema to make m.")
+	pn("func (c *%s) UpdateFieldMask(m googleapi.FieldMask) *%s {", callName, callName)
+	pn(" c.urlParams_.Set(%q, m.String())", mask.p.Name)
+	pn(" return c")
+	pn("}")
+}
+
+func quoteAll(names []string) string {
+	q := make([]string, len(names))
+	for i, n := range names {
+		q[i] = fmt.Sprintf("%q", n)
+	}
+	return strings.Join(q, ", ")
+}
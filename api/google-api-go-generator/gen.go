@@ -55,6 +55,12 @@ var (
 	optionPkg         = flag.String("option_pkg", "google.golang.org/api/option", "Go package path of the 'api/option' support package.")
 	internalOptionPkg = flag.String("internaloption_pkg", "google.golang.org/api/option/internaloption", "Go package path of the 'api/option/internaloption' support package.")
 	htransportPkg     = flag.String("htransport_pkg", "google.golang.org/api/transport/http", "Go package path of the 'api/transport/http' support package.")
+	fakeserverPkg     = flag.String("fakeserver_pkg", "google.golang.org/api/internal/fakeserver", "Go package path of the 'api/internal/fakeserver' support package.")
+
+	genFake       = flag.Bool("gen_fake", false, "Also generate a package <pkg>fake next to each API package, with a fake server for tests.")
+	directMarshal = flag.Bool("direct_marshal", false, "Generate MarshalJSON methods that encode schemas field by field, without reflection.")
+	genFields     = flag.Bool("field_selectors", false, "Generate a field selector for each schema, for partial responses and field masks.")
+	jwtWithScope  = flag.String("jwt_with_scope", "", "Comma-separated names of APIs, like 'storage', whose clients use self-signed JWTs with scopes in place of OAuth 2.0 access tokens for service account keys.")
 
 	copyrightYear = flag.String("copyright_year", fmt.Sprintf("%d", time.Now().Year()), "Year for copyright.")
 
@@ -87,6 +93,10 @@ type API struct {
 	usedNames     namePool
 	schemas       map[string]*Schema // apiName -> schema
 	responseTypes map[string]bool
+	enums         []*enumType
+	operations    *operationPoller // nil if the API has no operations to poll
+	batchType     string           // name of the Batch type, or "" if the API has no batch endpoint
+	unsupported   []string         // constructs that no code was generated for
 
 	p  func(format string, args ...interface{}) // print raw
 	pn func(format string, args ...interface{}) // print with newline
@@ -577,6 +587,17 @@ func (a *API) WriteGeneratedCode() error {
 	if err != nil {
 		return err
 	}
+	if *genFake {
+		fpkg := a.Package() + "fake"
+		code, err := a.GenerateFake()
+		errw := writeFile(filepath.Join(filepath.Dir(genfilename), fpkg, fpkg+"-gen.go"), code)
+		if err == nil {
+			err = errw
+		}
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -754,6 +775,12 @@ func (a *API) GenerateCode() ([]byte, error) {
 	if a.mtlsAPIBaseURL() != "" {
 		pn("opts = append(opts, internaloption.WithDefaultMTLSEndpoint(mtlsBasePath))")
 	}
+	if a.doc.RootURL != "" {
+		pn("opts = append(opts, internaloption.WithDefaultAudience(%q))", a.doc.RootURL)
+	}
+	if a.jwtWithScope() {
+		pn("opts = append(opts, internaloption.EnableJWTWithScope())")
+	}
 	pn("client, endpoint, err := htransport.NewClient(ctx, opts...)")
 	pn("if err != nil { return nil, err }")
 	pn("s, err := New(client)")
@@ -805,6 +832,11 @@ func (a *API) GenerateCode() ([]byte, error) {
 	for _, name := range a.sortedSchemaNames() {
 		a.schemas[name].writeSchemaCode(a)
 	}
+	if *genFields {
+		a.writeFieldSelectors()
+	}
+	a.operations = a.findOperationPoller()
+	a.reserveBatchType()
 
 	for _, meth := range a.APIMethods() {
 		meth.generateCode()
@@ -813,14 +845,44 @@ func (a *API) GenerateCode() ([]byte, error) {
 	for _, res := range a.doc.Resources {
 		a.generateResourceMethods(res)
 	}
+	for _, res := range a.doc.Resources {
+		a.writeModifiers(res)
+	}
+
+	a.writeOperationHelpers()
+	a.writeBatchType()
+	a.writeEnumTypes()
 
 	clean, err := format.Source(buf.Bytes())
 	if err != nil {
 		return buf.Bytes(), err
 	}
+	if *build && len(a.unsupported) > 0 {
+		return clean, fmt.Errorf("unsupported constructs:\n\t%s", strings.Join(a.unsupported, "\n\t"))
+	}
 	return clean, nil
 }
 
+// unsupportedf reports a construct of the discovery document that the
+// generator cannot generate code for. It is logged, and with -build it
+// makes GenerateCode fail, so that APIs don't lose code silently.
+func (a *API) unsupportedf(format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+	log.Printf("%s: unsupported: %s", a.ID, msg)
+	a.unsupported = append(a.unsupported, msg)
+}
+
+// jwtWithScope reports whether the API is one of those named by
+// -jwt_with_scope.
+func (a *API) jwtWithScope() bool {
+	for _, name := range strings.Split(*jwtWithScope, ",") {
+		if strings.TrimSpace(name) == a.Name {
+			return true
+		}
+	}
+	return false
+}
+
 func (a *API) generateScopeConstants() {
 	scopes := a.doc.Auth.OAuth2Scopes
 	if len(scopes) == 0 {
@@ -878,6 +940,8 @@ type Schema struct {
 	apiName      string // the native API-defined name of this type
 	goName       string // lazily populated by GoName
 	goReturnType string // lazily populated by GoReturnType
+	fieldsVar    string // name of the field selector variable, if any
+	fieldsType   string // name of the field selector type, if any
 	props        []*Property
 }
 
@@ -1284,12 +1348,16 @@ func (s *Schema) GoName() string {
 // GoReturnType returns the Go type to use as the return type.
 // If a type is a struct, it will return *StructType,
 // for a map it will return map[string]ValueType,
-// for (not yet supported) slices it will return []ValueType.
+// for a slice it will return *SliceTypeResponse, a struct that wraps
+// the slice together with the googleapi.ServerResponse.
 func (s *Schema) GoReturnType() string {
 	if s.goReturnType == "" {
-		if s.typ.Kind == disco.MapKind {
+		switch s.typ.Kind {
+		case disco.MapKind:
 			s.goReturnType = s.GoName()
-		} else {
+		case disco.ArrayKind:
+			s.goReturnType = "*" + s.api.GetName(s.GoName()+"Response")
+		default:
 			s.goReturnType = "*" + s.GoName()
 		}
 	}
@@ -1307,35 +1375,97 @@ func (s *Schema) writeSchemaCode(api *API) {
 	case disco.MapKind, disco.AnyStructKind:
 		// Do nothing.
 	case disco.ArrayKind:
-		log.Printf("TODO writeSchemaCode for arrays for %s", s.GoName())
+		// Arrays nested in other schemas are spelled out where they are
+		// used, so only top-level ones get named types.
+		if _, ok := api.doc.Schemas[s.apiName]; ok {
+			s.writeSchemaArray(api)
+		}
 	default:
 		fmt.Fprintf(os.Stderr, "in writeSchemaCode, schema is: %+v", s.typ)
 		panicf("writeSchemaCode: unsupported type for schema %q", s.apiName)
 	}
 }
 
+// writeSchemaArray writes the named slice type of a top-level array schema,
+// and the struct that wraps it if methods return it. Fields that refer to the
+// schema keep their unnamed slice type, which the named type is assignable
+// to and from.
+func (s *Schema) writeSchemaArray(api *API) {
+	s.api.p("\n")
+	if des := s.Description(); des != "" {
+		s.api.p("%s", asComment("", fmt.Sprintf("%s: %s", s.GoName(), des)))
+	}
+	s.api.pn("type %s %s", s.GoName(), api.typeAsGo(s.typ, false))
+
+	if !api.responseTypes[s.goReturnType] {
+		return
+	}
+	wrapper := strings.TrimPrefix(s.goReturnType, "*")
+	s.api.p("\n%s", asComment("", fmt.Sprintf("%s is the response of the methods that return a %s. "+
+		"It is encoded in JSON as its Items.", wrapper, s.GoName())))
+	s.api.pn("type %s struct {", wrapper)
+	s.api.pn(" Items %s", s.GoName())
+	s.api.pn("")
+	s.api.p("%s", asComment("\t", "ServerResponse contains the HTTP response code and headers from the server."))
+	s.api.pn(" googleapi.ServerResponse `json:\"-\"`")
+	s.api.pn("}")
+	s.api.pn("\nfunc (s *%s) MarshalJSON() ([]byte, error) {", wrapper)
+	s.api.pn(" return json.Marshal(s.Items)")
+	s.api.pn("}")
+	s.api.pn("\nfunc (s *%s) UnmarshalJSON(data []byte) error {", wrapper)
+	s.api.pn(" return json.Unmarshal(data, &s.Items)")
+	s.api.pn("}")
+}
+
+// writeVariant writes the map type of a variant schema, with a method that
+// returns the value of its discriminant and a method that converts it to
+// each of its variants. An entry without a type_value is the variant used
+// when the discriminant is absent. An entry without a $ref gets a method
+// that only reports whether the value has its type.
 func (s *Schema) writeVariant(api *API, v *disco.Variant) {
 	s.api.p("\ntype %s map[string]interface{}\n\n", s.GoName())
 
 	// Write out the "Type" method that identifies the variant type.
 	s.api.pn("func (t %s) Type() string {", s.GoName())
-	s.api.pn("  return googleapi.VariantType(t)")
+	if v.Discriminant == "" || v.Discriminant == "type" {
+		s.api.pn("  return googleapi.VariantType(t)")
+	} else {
+		s.api.pn("  s, _ := t[%q].(string)", v.Discriminant)
+		s.api.pn("  return s")
+	}
 	s.api.p("}\n\n")
 
 	// Write out helper methods to convert each possible variant.
+	np := new(namePool)
+	np.Get("Type")
 	for _, m := range v.Map {
-		if m.TypeValue == "" && m.Ref == "" {
-			log.Printf("TODO variant %s ref %s not yet supported.", m.TypeValue, m.Ref)
-			continue
+		var rs *Schema
+		if m.Ref != "" {
+			if rs = api.schemas[m.Ref]; rs == nil {
+				api.unsupportedf("variant %s of %s refers to unknown schema %q", m.TypeValue, s.apiName, m.Ref)
+				continue
+			}
+		}
+		switch {
+		case rs == nil && m.TypeValue == "":
+			api.unsupportedf("variant of %s with neither type_value nor $ref", s.apiName)
+		case rs == nil:
+			s.api.pn("func (t %s) %s() bool {", s.GoName(), np.Get("Is"+initialCap(m.TypeValue)))
+			s.api.pn(" return t.Type() == %q", m.TypeValue)
+			s.api.p("}\n\n")
+		default:
+			name := rs.GoName()
+			if m.TypeValue != "" {
+				name = initialCap(m.TypeValue)
+			}
+			s.api.pn("func (t %s) %s() (r %s, ok bool) {", s.GoName(), np.Get(name), rs.GoName())
+			s.api.pn(" if t.Type() != %q {", m.TypeValue)
+			s.api.pn("  return r, false")
+			s.api.pn(" }")
+			s.api.pn(" ok = googleapi.ConvertVariant(map[string]interface{}(t), &r)")
+			s.api.pn(" return r, ok")
+			s.api.p("}\n\n")
 		}
-
-		s.api.pn("func (t %s) %s() (r %s, ok bool) {", s.GoName(), initialCap(m.TypeValue), m.Ref)
-		s.api.pn(" if t.Type() != %q {", initialCap(m.TypeValue))
-		s.api.pn("  return r, false")
-		s.api.pn(" }")
-		s.api.pn(" ok = googleapi.ConvertVariant(map[string]interface{}(t), &r)")
-		s.api.pn(" return r, ok")
-		s.api.p("}\n\n")
 	}
 }
 
@@ -1391,6 +1521,12 @@ func (s *Schema) writeSchemaStruct(api *API) {
 		}
 
 		s.api.pn(" %s %s `json:\"%s,omitempty%s\"`", pname, typ, p.p.Name, extraOpt)
+		switch typ {
+		case "string":
+			s.api.addEnumType(s.GoName()+pname, fmt.Sprintf("%%s is the type of the %s field of %s.", pname, s.GoName()), p)
+		case "[]string":
+			s.api.addEnumType(s.GoName()+pname, fmt.Sprintf("%%s is the type of the elements of the %s field of %s.", pname, s.GoName()), p)
+		}
 		if firstFieldName == "" {
 			firstFieldName = pname
 		}
@@ -1446,6 +1582,9 @@ func (s *Schema) writeSchemaStruct(api *API) {
 // by forceSendFieldName, and allows fields to be transmitted with the null value
 // by listing them in the field identified by nullFieldsName.
 func (s *Schema) writeSchemaMarshal(forceSendFieldName, nullFieldsName string) {
+	if *directMarshal && s.writeDirectMarshal(forceSendFieldName, nullFieldsName) {
+		return
+	}
 	s.api.pn("func (s *%s) MarshalJSON() ([]byte, error) {", s.GoName())
 	s.api.pn("\ttype NoMethod %s", s.GoName())
 	// pass schema as methodless type to prevent subsequent calls to MarshalJSON from recursing indefinitely.
@@ -1766,6 +1905,20 @@ func (m *Method) OptParams() []*Param {
 	})
 }
 
+// requestIDParam returns the optional parameter that makes requests to m
+// idempotent, like the requestId of compute, or nil if m has none.
+func (m *Method) requestIDParam() *Param {
+	for _, p := range m.OptParams() {
+		switch p.p.Name {
+		case "requestId", "request_id":
+			if p.p.Location == "query" && p.p.Type == "string" && !p.p.Repeated {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
 func (meth *Method) cacheResponseTypes(api *API) {
 	if retType := responseType(api, meth.m); retType != "" && strings.HasPrefix(retType, "*") {
 		api.responseTypes[retType] = true
@@ -1805,6 +1958,11 @@ func (meth *Method) generateCode() {
 		prefix = initialCap(res.FullName)
 	}
 	callName := a.GetName(prefix + methodName + "Call")
+	for _, param := range meth.Params() {
+		if param.GoType() == "string" {
+			a.addEnumType(strings.TrimSuffix(callName, "Call")+initialCap(param.p.Name), fmt.Sprintf("%%s is the type of the %q parameter of %s.", param.p.Name, callName), param)
+		}
+	}
 
 	pn("\ntype %s struct {", callName)
 	pn(" s *%s", a.ServiceType())
@@ -1822,6 +1980,9 @@ func (meth *Method) generateCode() {
 	if meth.supportsMediaUpload() {
 		pn(" mediaInfo_ *gensupport.MediaInfo")
 	}
+	if a.returnsOperation(meth) {
+		pn(" op_ %s", a.operations.schema.GoReturnType())
+	}
 	pn(" ctx_ context.Context")
 	pn(" header_ http.Header")
 	pn("}")
@@ -1879,6 +2040,9 @@ func (meth *Method) generateCode() {
 		des := opt.p.Description
 		des = strings.Replace(des, "Optional.", "", 1)
 		des = strings.TrimSpace(des)
+		if rid := meth.requestIDParam(); rid != nil && rid.p.Name == opt.p.Name {
+			des += "\n\nIf it is not set, Do sets it to a random UUID, so that the request can be retried safely."
+		}
 		p("\n%s", asComment("", fmt.Sprintf("%s sets the optional parameter %q: %s", setter, opt.p.Name, des)))
 		addFieldValueComments(p, opt, "", true)
 		np := new(namePool)
@@ -1955,6 +2119,10 @@ func (meth *Method) generateCode() {
 		pn("}")
 	}
 
+	if *genFields {
+		a.writeUpdateFieldMask(meth, callName)
+	}
+
 	comment := "Fields allows partial responses to be retrieved. " +
 		"See https://developers.google.com/gdata/docs/2.0/basics#PartialResponse " +
 		"for more information."
@@ -2006,7 +2174,11 @@ func (meth *Method) generateCode() {
 	pn(" return c.header_")
 	pn("}")
 
+	validate := a.writeCallValidate(meth, callName)
 	pn("\nfunc (c *%s) doRequest(alt string) (*http.Response, error) {", callName)
+	if validate {
+		pn("if err := gensupport.Validate(c.urlParams_, c.validate); err != nil { return nil, err }")
+	}
 	pn(`reqHeaders := make(http.Header)`)
 	pn(`reqHeaders.Set("x-goog-api-client", "gl-go/"+gensupport.GoVersion()+" gdcl/%s")`, version.Repo)
 	pn("for k, v := range c.header_ {")
@@ -2063,6 +2235,18 @@ func (meth *Method) generateCode() {
 	if meth.supportsMediaUpload() {
 		pn("req.GetBody = getBody")
 	}
+	if meth.supportsMediaDownload() {
+		pn(`if alt == "media" {`)
+		pn(" req = gensupport.SetRawDownload(req, c.urlParams_)")
+		pn("}")
+	}
+	requestID := meth.requestIDParam()
+	if requestID != nil {
+		// With a request ID the server ignores repeats of the request, so it
+		// can be retried. The ID goes in req, not c.urlParams_, so that
+		// calling Do again sends a new request.
+		pn("if err := gensupport.SetRequestID(req, %q); err != nil { return nil, err }", requestID.p.Name)
+	}
 
 	// Replace param values after NewRequest to avoid reencoding them.
 	// E.g. Cloud Storage API requires '%2F' in entity param to be kept, but url.Parse replaces it with '/'.
@@ -2070,11 +2254,20 @@ func (meth *Method) generateCode() {
 	if len(argsForLocation) > 0 {
 		pn(`googleapi.Expand(req.URL, map[string]string{`)
 		for _, arg := range argsForLocation {
+			if arg.gotype == "[]string" {
+				a.unsupportedf("repeated path parameter %s of %s; only its first value is used", arg.apiname, meth.Id())
+			}
 			pn(`"%s": %s,`, arg.apiname, arg.exprAsString("c."))
 		}
 		pn(`})`)
 	}
-	if meth.supportsMediaUpload() && meth.api.Name == "storage" {
+	if requestID != nil || meth.supportsMediaUpload() && meth.api.Name == "storage" {
+		if meth.supportsMediaUpload() || meth.IsRawRequest() {
+			// The body may be a plain io.Reader, which can't be read again
+			// for a retry unless the caller asks for it to be kept.
+			pn("release := gensupport.BufferBody(req, c.urlParams_)")
+			pn("defer release()")
+		}
 		pn("return gensupport.SendRequestWithRetry(c.ctx_, c.s.client, req)")
 	} else {
 		pn("return gensupport.SendRequest(c.ctx_, c.s.client, req)")
@@ -2085,6 +2278,8 @@ func (meth *Method) generateCode() {
 		pn("\n// Download fetches the API endpoint's \"media\" value, instead of the normal")
 		pn("// API response value. If the returned error is nil, the Response is guaranteed to")
 		pn("// have a 2xx status code. Callers must close the Response.Body as usual.")
+		pn("// Use googleapi.RawDownload to get the body as sent by the server, compressed")
+		pn("// or not, as told by its Content-Encoding.")
 		pn("func (c *%s) Download(opts ...googleapi.CallOption) (*http.Response, error) {", callName)
 		pn(`gensupport.SetOptions(c.urlParams_, opts...)`)
 		pn(`res, err := c.doRequest("media")`)
@@ -2207,6 +2402,10 @@ func (meth *Method) generateCode() {
 		pn(" }")
 		pn("}")
 	}
+
+	a.writeCallStream(meth, callName)
+	a.writeCallWait(meth, callName)
+	a.writeCallBatch(meth, callName, retType)
 }
 
 // A Field provides methods that describe the characteristics of a Param or Property.
@@ -2348,10 +2547,14 @@ func (meth *Method) NewArguments() *arguments {
 
 func (meth *Method) NewBodyArg(ds *disco.Schema) *argument {
 	s := meth.api.schemaNamed(ds.RefSchema.Name)
+	gotype := "*" + s.GoName()
+	if s.typ.Kind == disco.ArrayKind {
+		gotype = s.GoName()
+	}
 	return &argument{
 		goname:   validGoIdentifer(strings.ToLower(ds.Ref)),
 		apiname:  "REQUEST",
-		gotype:   "*" + s.GoName(),
+		gotype:   gotype,
 		apitype:  ds.Ref,
 		location: "body",
 		schema:   s,
@@ -2394,7 +2597,6 @@ func (a *argument) String() string {
 func (a *argument) exprAsString(prefix string) string {
 	switch a.gotype {
 	case "[]string":
-		log.Printf("TODO(bradfitz): only including the first parameter in path query.")
 		return prefix + a.goname + `[0]`
 	case "string":
 		return prefix + a.goname
@@ -28,6 +28,7 @@ func TestAPIs(t *testing.T) {
 		"arrayofenum",
 		"arrayofmapofobjects",
 		"arrayofmapofstrings",
+		"arrays-toplevel",
 		"blogger-3",
 		"floats",
 		"getwithoutbody",
@@ -38,13 +39,19 @@ func TestAPIs(t *testing.T) {
 		"mapofint64strings",
 		"mapofobjects",
 		"mapofstrings-1",
+		"media",
+		"media-retry",
+		"modify",
+		"operation-compute",
 		"param-rename",
 		"quotednum",
 		"repeated",
 		"required-query",
 		"resource-named-service", // appengine/v1/appengine-api.json
 		"unfortunatedefaults",
+		"validation",
 		"variants",
+		"variants-partial",
 		"wrapnewlines",
 	}
 	for _, name := range names {
@@ -84,6 +91,169 @@ func TestAPIs(t *testing.T) {
 	}
 }
 
+func TestFakes(t *testing.T) {
+	*copyrightYear = "YEAR"
+
+	for _, name := range []string{
+		"blogger-3",
+		"operation-compute",
+	} {
+		t.Run(name, func(t *testing.T) {
+			api, err := apiFromFile(filepath.Join("testdata", name+".json"))
+			if err != nil {
+				t.Fatalf("Error loading API testdata/%s.json: %v", name, err)
+			}
+			if _, err := api.GenerateCode(); err != nil {
+				t.Fatalf("Error generating code for %s: %v", name, err)
+			}
+			clean, err := api.GenerateFake()
+			if err != nil {
+				t.Fatalf("Error generating fake for %s: %v", name, err)
+			}
+			goldenFile := filepath.Join("testdata", name+".fake.want")
+			if *updateGolden {
+				if err := ioutil.WriteFile(goldenFile, clean, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(want, clean) {
+				tf, _ := ioutil.TempFile("", "api-"+name+"-got-fake.")
+				if _, err := tf.Write(clean); err != nil {
+					t.Fatal(err)
+				}
+				if err := tf.Close(); err != nil {
+					t.Fatal(err)
+				}
+				// NOTE: update golden files with `go test -update_golden`
+				t.Errorf("Fake for API %s differs: diff -u %s %s", name, goldenFile, tf.Name())
+			}
+		})
+	}
+}
+
+func TestFieldSelectors(t *testing.T) {
+	*copyrightYear = "YEAR"
+	defer func(b bool) { *genFields = b }(*genFields)
+	*genFields = true
+
+	for _, name := range []string{
+		"validation",
+		"variants",
+	} {
+		t.Run(name, func(t *testing.T) {
+			api, err := apiFromFile(filepath.Join("testdata", name+".json"))
+			if err != nil {
+				t.Fatalf("Error loading API testdata/%s.json: %v", name, err)
+			}
+			clean, err := api.GenerateCode()
+			if err != nil {
+				t.Fatalf("Error generating code for %s: %v", name, err)
+			}
+			clean = bytes.Replace(clean, []byte(fmt.Sprintf("gdcl/%s", version.Repo)), []byte("gdcl/00000000"), -1)
+			goldenFile := filepath.Join("testdata", name+".fields.want")
+			if *updateGolden {
+				if err := ioutil.WriteFile(goldenFile, clean, 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := ioutil.ReadFile(goldenFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(want, clean) {
+				tf, _ := ioutil.TempFile("", "api-"+name+"-got-fields.")
+				if _, err := tf.Write(clean); err != nil {
+					t.Fatal(err)
+				}
+				if err := tf.Close(); err != nil {
+					t.Fatal(err)
+				}
+				// NOTE: update golden files with `go test -update_golden`
+				t.Errorf("Output with -field_selectors for API %s differs: diff -u %s %s", name, goldenFile, tf.Name())
+			}
+		})
+	}
+}
+
+func TestUnsupportedBuild(t *testing.T) {
+	defer func(b bool) { *build = b }(*build)
+	for _, b := range []bool{false, true} {
+		*build = b
+		api, err := apiFromFile(filepath.Join("testdata", "variants-unsupported.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = api.GenerateCode()
+		if b && (err == nil || !strings.Contains(err.Error(), "neither type_value nor $ref")) {
+			t.Errorf("with -build: got error %v, want one about the variant", err)
+		}
+		if !b && err != nil {
+			t.Errorf("without -build: got error %v, want nil", err)
+		}
+	}
+}
+
+func TestRepeatedPathParamBuild(t *testing.T) {
+	defer func(b bool) { *build = b }(*build)
+	b, err := ioutil.ReadFile(filepath.Join("testdata", "repeated.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Make the accountId path parameter repeated.
+	b = bytes.Replace(b, []byte(`"required": true,`), []byte(`"required": true, "repeated": true,`), 1)
+	for _, withBuild := range []bool{false, true} {
+		*build = withBuild
+		doc, err := disco.NewDocument(b)
+		if err != nil {
+			t.Fatal(err)
+		}
+		api := &API{ID: doc.ID, Name: doc.Name, Version: doc.Version, forceJSON: b, doc: doc}
+		clean, err := api.GenerateCode()
+		if withBuild {
+			if err == nil || !strings.Contains(err.Error(), "repeated path parameter accountId") {
+				t.Errorf("with -build: got error %v, want one about the repeated path parameter", err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("without -build: got error %v, want nil", err)
+		}
+		if want := `"accountId": c.accountId[0]`; !bytes.Contains(clean, []byte(want)) {
+			t.Errorf("generated code does not contain %q", want)
+		}
+	}
+}
+
+func TestJWTWithScope(t *testing.T) {
+	defer func(s string) { *jwtWithScope = s }(*jwtWithScope)
+	const want = "internaloption.EnableJWTWithScope()"
+	for _, tc := range []struct {
+		flag   string
+		wantIt bool
+	}{
+		{"", false},
+		{"storage", false},
+		{"storage, media", true},
+	} {
+		*jwtWithScope = tc.flag
+		api, err := apiFromFile(filepath.Join("testdata", "media.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		clean, err := api.GenerateCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := bytes.Contains(clean, []byte(want)); got != tc.wantIt {
+			t.Errorf("-jwt_with_scope=%q: got %q in generated code: %t, want %t", tc.flag, want, got, tc.wantIt)
+		}
+	}
+}
+
 func TestScope(t *testing.T) {
 	tests := [][]string{
 		{
@@ -106,6 +276,24 @@ func TestScope(t *testing.T) {
 	}
 }
 
+func TestEnumValueGoName(t *testing.T) {
+	tests := [][]string{
+		{"ACTIVE", "Active"},
+		{"STATE_UNSPECIFIED", "StateUnspecified"},
+		{"fooBar", "FooBar"},
+		{"text/plain", "TextPlain"},
+		{"1080p", "1080p"},
+		{"HTTP2", "Http2"},
+		{"", "Empty"},
+		{"-", "Value"},
+	}
+	for _, test := range tests {
+		if got := enumValueGoName(test[0]); got != test[1] {
+			t.Errorf("enumValueGoName(%q) got %q, want %q", test[0], got, test[1])
+		}
+	}
+}
+
 func TestDepunct(t *testing.T) {
 	tests := []struct {
 		needCap  bool
@@ -23,6 +23,7 @@ type Document struct {
 	MTLSRootURL       string             `json:"mtlsRootUrl"`
 	ServicePath       string             `json:"servicePath"`
 	BasePath          string             `json:"basePath"`
+	BatchPath         string             `json:"batchPath"`
 	DocumentationLink string             `json:"documentationLink"`
 	Auth              Auth               `json:"auth"`
 	Features          []string           `json:"features"`
@@ -140,12 +141,20 @@ type Schema struct {
 	// Google extensions to JSON Schema
 	EnumDescriptions []string
 	Variant          *Variant
+	Annotations      Annotations
 
 	RefSchema *Schema `json:"-"` // Schema referred to by $ref
 	Name      string  `json:"-"` // Schema name, if top level
 	Kind      Kind    `json:"-"`
 }
 
+// Annotations holds the annotations of a schema.
+type Annotations struct {
+	// Required holds the IDs of the methods whose requests must set the
+	// field that the schema belongs to.
+	Required []string
+}
+
 type Variant struct {
 	Discriminant string
 	Map          []*VariantMapItem
@@ -33,6 +33,7 @@ func TestDocument(t *testing.T) {
 		RootURL:           "https://www.googleapis.com/",
 		ServicePath:       "storage/v1/",
 		BasePath:          "/storage/v1/",
+		BatchPath:         "batch",
 		DocumentationLink: "https://developers.google.com/storage/docs/json_api/",
 		Auth: Auth{
 			OAuth2Scopes: []Scope{
@@ -77,7 +78,11 @@ func TestDocument(t *testing.T) {
 							},
 						},
 					}},
-					{"id", stringSchema},
+					{"id", &Schema{
+						Type:        "string",
+						Kind:        SimpleKind,
+						Annotations: Annotations{Required: []string{"storage.buckets.insert"}},
+					}},
 					{"kind", &Schema{
 						Type:    "string",
 						Kind:    SimpleKind,
@@ -0,0 +1,123 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/google-api-go-generator/internal/disco"
+)
+
+// directField is the code that marshals a struct field with a
+// gensupport.JSONWriter named w.
+type directField struct {
+	empty string // expression that reports whether the field is empty
+	isNil string // expression that reports whether it is a nil pointer or interface
+	write string // statement that writes its value
+}
+
+// directMarshalField returns the code that marshals the struct field x, which
+// holds the property p, or false if it has a type that is left to
+// gensupport.MarshalJSON.
+func directMarshalField(x string, p *Property) (directField, bool) {
+	typ := p.TypeAsGo()
+	if p.forcePointerType() {
+		typ = "*" + typ
+	}
+	quoted := p.Type().IsIntAsString()
+	if elem := strings.TrimPrefix(typ, "*"); elem != typ {
+		if w, ok := directWriteSimple(elem, "*"+x, quoted); ok {
+			return directField{x + " == nil", x + " == nil", w}, true
+		}
+		return directField{x + " == nil", x + " == nil", fmt.Sprintf("w.Value(%s)", x)}, true
+	}
+	switch typ {
+	case "string":
+		return directField{x + ` == ""`, "false", fmt.Sprintf("w.String(%s)", x)}, true
+	case "bool":
+		return directField{"!" + x, "false", fmt.Sprintf("w.Bool(%s)", x)}, true
+	case "[]string":
+		return directField{"len(" + x + ") == 0", "false", fmt.Sprintf("w.Strings(%s)", x)}, true
+	case "map[string]string":
+		return directField{"len(" + x + ") == 0", "false", fmt.Sprintf("w.StringMap(%s)", x)}, true
+	case "interface{}":
+		return directField{x + " == nil", x + " == nil", fmt.Sprintf("w.Value(%s)", x)}, true
+	}
+	if w, ok := directWriteSimple(typ, x, quoted); ok {
+		return directField{x + " == 0", "false", w}, true
+	}
+	t := p.Type()
+	if t.Kind == disco.ReferenceKind {
+		t = t.RefSchema
+	}
+	switch {
+	case strings.HasPrefix(typ, "[]"), t.Kind == disco.ArrayKind, t.Kind == disco.AnyStructKind:
+		return directField{"len(" + x + ") == 0", "false", fmt.Sprintf("w.Slice(%s, %s == nil)", x, x)}, true
+	case strings.HasPrefix(typ, "map["), t.Kind == disco.MapKind:
+		return directField{"len(" + x + ") == 0", "false", fmt.Sprintf("w.Map(%s, %s == nil)", x, x)}, true
+	}
+	return directField{}, false
+}
+
+// directWriteSimple returns the statement that writes x, a number of Go type
+// typ, or false if typ is not a number type. quoted reports whether the
+// number is written as a string.
+func directWriteSimple(typ, x string, quoted bool) (string, bool) {
+	var method, conv string
+	switch typ {
+	case "int64", "int32":
+		method, conv = "Int64", "int64"
+	case "uint64", "uint32":
+		method, conv = "Uint64", "uint64"
+	case "float64":
+		return fmt.Sprintf("w.Float64(%s)", x), !quoted
+	case "string":
+		return fmt.Sprintf("w.String(%s)", x), !quoted
+	case "bool":
+		return fmt.Sprintf("w.Bool(%s)", x), !quoted
+	default:
+		return "", false
+	}
+	if quoted {
+		method += "String"
+	}
+	if typ != conv {
+		x = conv + "(" + x + ")"
+	}
+	return fmt.Sprintf("w.%s(%s)", method, x), true
+}
+
+// writeDirectMarshal writes a MarshalJSON method for s that encodes its fields
+// with a gensupport.JSONWriter, with the same result as gensupport.MarshalJSON.
+// It reports false, and writes nothing, if a field of s has a type that it
+// can't encode.
+func (s *Schema) writeDirectMarshal(forceSendFieldName, nullFieldsName string) bool {
+	var props []*Property
+	var fields []directField
+	for _, p := range s.properties() {
+		if p.assignedGoName == "" {
+			continue
+		}
+		f, ok := directMarshalField("s."+p.assignedGoName, p)
+		if !ok {
+			return false
+		}
+		props = append(props, p)
+		fields = append(fields, f)
+	}
+	pn := s.api.pn
+	pn("func (s *%s) MarshalJSON() ([]byte, error) {", s.GoName())
+	pn(" w := gensupport.NewJSONWriter(s.%s, s.%s)", forceSendFieldName, nullFieldsName)
+	for i, p := range props {
+		f := fields[i]
+		pn(" if w.Field(%q, %q, %s, %s) {", p.assignedGoName, p.p.Name, f.empty, f.isNil)
+		pn("  %s", f.write)
+		pn(" }")
+	}
+	pn(" return w.Bytes()")
+	pn("}")
+	return true
+}
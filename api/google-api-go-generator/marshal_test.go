@@ -0,0 +1,249 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/google-api-go-generator/internal/disco"
+)
+
+// TestDirectMarshal checks that the MarshalJSON methods generated with
+// -direct_marshal give the same results as gensupport.MarshalJSON, for random
+// values of the schemas of the test APIs. It generates each API with a test
+// that compares the two, and runs the tests with the go command.
+func TestDirectMarshal(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds generated packages")
+	}
+	goTool, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("no go command:", err)
+	}
+	defer func(b bool) { *directMarshal = b }(*directMarshal)
+	*directMarshal = true
+
+	// The packages must be in this module, for their imports.
+	dir, err := ioutil.TempDir(".", "directmarshal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, name := range []string{
+		"any",
+		"arrayofarray-1",
+		"arrayofenum",
+		"arrayofmapofobjects",
+		"arrayofmapofstrings",
+		"arrays-toplevel",
+		"blogger-3",
+		"floats",
+		"json-body",
+		"mapofany",
+		"mapofarrayofobjects",
+		"mapofint64strings",
+		"mapofobjects",
+		"mapofstrings-1",
+		"quotednum",
+		"unfortunatedefaults",
+		"validation",
+		"variants",
+	} {
+		api, err := apiFromFile(filepath.Join("testdata", name+".json"))
+		if err != nil {
+			t.Fatalf("Error loading API testdata/%s.json: %v", name, err)
+		}
+		code, err := api.GenerateCode()
+		if err != nil {
+			t.Fatalf("Error generating code for %s: %v", name, err)
+		}
+		if !bytes.Contains(code, []byte("gensupport.NewJSONWriter")) {
+			t.Errorf("%s: no direct MarshalJSON methods generated", name)
+		}
+		pkgDir := filepath.Join(dir, strings.Replace(name, "-", "_", -1))
+		if err := os.Mkdir(pkgDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pkgDir, "api.go"), code, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(pkgDir, "marshal_test.go"), directMarshalTest(api), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	out, err := exec.Command(goTool, "test", "./"+filepath.ToSlash(dir)+"/...").CombinedOutput()
+	if err != nil {
+		t.Fatalf("%v\n%s", err, out)
+	}
+}
+
+// directMarshalTest returns a test for the package of api, which compares the
+// MarshalJSON methods of its schemas with gensupport.MarshalJSON.
+func directMarshalTest(api *API) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "package %s\n", api.Package())
+	buf.WriteString(directMarshalTestHeader)
+	buf.WriteString("\nvar schemas = []schema{\n")
+	for _, name := range api.sortedSchemaNames() {
+		s := api.schemas[name]
+		if s.typ.Kind != disco.StructKind || s.typ.Variant != nil || !hasAssignedField(s) {
+			continue
+		}
+		fmt.Fprintf(&buf, "\t{%q, func() interface{} { return new(%s) }, func(v interface{}) ([]byte, error) {\n", s.GoName(), s.GoName())
+		fmt.Fprintf(&buf, "\t\ttype NoMethod %s\n", s.GoName())
+		fmt.Fprintf(&buf, "\t\ts := v.(*%s)\n", s.GoName())
+		buf.WriteString("\t\tforce, null := fieldLists(reflect.ValueOf(s).Elem())\n")
+		buf.WriteString("\t\treturn gensupport.MarshalJSON(NoMethod(*s), force.Interface().([]string), null.Interface().([]string))\n")
+		buf.WriteString("\t}},\n")
+	}
+	buf.WriteString("}\n")
+	return buf.Bytes()
+}
+
+func hasAssignedField(s *Schema) bool {
+	for _, p := range s.properties() {
+		if p.assignedGoName != "" {
+			return true
+		}
+	}
+	return false
+}
+
+const directMarshalTestHeader = `
+import (
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/internal/gensupport"
+)
+
+type schema struct {
+	name       string
+	new        func() interface{}
+	reflective func(interface{}) ([]byte, error)
+}
+
+// fieldLists returns the ForceSendFields and NullFields of the schema struct
+// s, which are its two []string fields that are not marshaled.
+func fieldLists(s reflect.Value) (force, null reflect.Value) {
+	var lists []reflect.Value
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Type().Field(i)
+		if f.Tag.Get("json") == "-" && f.Type == reflect.TypeOf([]string(nil)) {
+			lists = append(lists, s.Field(i))
+		}
+	}
+	return lists[0], lists[1]
+}
+
+// randomValue returns a random value of type t, which may be empty.
+func randomValue(t reflect.Type, r *rand.Rand, depth int) reflect.Value {
+	v := reflect.New(t).Elem()
+	if r.Intn(4) == 0 || depth > 3 {
+		return v
+	}
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString([]string{"", "a", "<b>&", "\u00e9\n", "\xff"}[r.Intn(5)])
+	case reflect.Bool:
+		v.SetBool(r.Intn(2) == 0)
+	case reflect.Int64, reflect.Int32:
+		v.SetInt(r.Int63() >> uint(r.Intn(64)) * int64(1-2*r.Intn(2)))
+		if v.OverflowInt(v.Int()) {
+			v.SetInt(-7)
+		}
+	case reflect.Uint64, reflect.Uint32:
+		v.SetUint(r.Uint64() >> uint(r.Intn(64)))
+		if v.OverflowUint(v.Uint()) {
+			v.SetUint(7)
+		}
+	case reflect.Float64:
+		v.SetFloat([]float64{0, 1, -1.5, 1e21, 1e-7, r.NormFloat64() * 1e6, math.NaN()}[r.Intn(7)])
+	case reflect.Ptr:
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(randomValue(t.Elem(), r, depth+1))
+	case reflect.Slice:
+		if t == reflect.TypeOf(googleapi.RawMessage{}) {
+			v.SetBytes([]byte([]string{"", ` + "`" + `"raw"` + "`" + `, ` + "`" + `{"a": [1]}` + "`" + `}[r.Intn(3)]))
+			break
+		}
+		n := r.Intn(3)
+		v.Set(reflect.MakeSlice(t, n, n))
+		for i := 0; i < n; i++ {
+			v.Index(i).Set(randomValue(t.Elem(), r, depth+1))
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		for i := r.Intn(3); i > 0; i-- {
+			v.SetMapIndex(randomValue(t.Key(), r, depth+1), randomValue(t.Elem(), r, depth+1))
+		}
+	case reflect.Interface:
+		v.Set(reflect.ValueOf([]interface{}{"x", 1.5, []interface{}{true, nil}}[r.Intn(3)]))
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if f := t.Field(i); f.PkgPath == "" && f.Tag.Get("json") != "-" {
+				v.Field(i).Set(randomValue(f.Type, r, depth+1))
+			}
+		}
+	}
+	return v
+}
+
+// randomFieldLists sets the ForceSendFields and NullFields of the schema
+// struct s to random field names, which may also name the keys of map
+// fields. Fields in NullFields are usually made empty.
+func randomFieldLists(s reflect.Value, r *rand.Rand) {
+	var force, null []string
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Type().Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		switch r.Intn(6) {
+		case 0:
+			force = append(force, f.Name)
+		case 1:
+			if r.Intn(8) > 0 {
+				s.Field(i).Set(reflect.Zero(f.Type))
+			}
+			null = append(null, f.Name)
+		case 2:
+			null = append(null, f.Name+".a")
+		}
+	}
+	forceField, nullField := fieldLists(s)
+	forceField.Set(reflect.ValueOf(force))
+	nullField.Set(reflect.ValueOf(null))
+}
+
+func TestDirectMarshal(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for _, sc := range schemas {
+		for i := 0; i < 300; i++ {
+			v := sc.new()
+			s := reflect.ValueOf(v).Elem()
+			s.Set(randomValue(s.Type(), r, -1))
+			randomFieldLists(s, r)
+			want, wantErr := sc.reflective(v)
+			got, gotErr := v.(interface{ MarshalJSON() ([]byte, error) }).MarshalJSON()
+			if (gotErr != nil) != (wantErr != nil) {
+				t.Errorf("%s %+v: got error %v, want %v", sc.name, v, gotErr, wantErr)
+			} else if string(got) != string(want) {
+				t.Errorf("%s %+v:\ngot  %s\nwant %s", sc.name, v, got, want)
+			}
+		}
+	}
+}
+`
@@ -0,0 +1,184 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/google-api-go-generator/internal/disco"
+)
+
+// modifier describes the Modify method of a resource, which updates a
+// resource with gensupport.ReadModifyWrite.
+type modifier struct {
+	name     string  // Go name of the method, e.g. "Modify"
+	get      *Method // method that reads the resource
+	write    *Method // method that writes the resource back
+	schema   *Schema // schema of the resource
+	wrapper  *Schema // schema of the write request that holds the resource, or nil
+	wrapped  *Property
+	setCond  string // statement that sets the precondition of the write call c from x, or ""
+	condDesc string // description of the precondition
+}
+
+// findModifiers returns the Modify methods of the resource r: one for each
+// pair of get and update or patch methods, and one for getIamPolicy and
+// setIamPolicy, whose writes are conditional on the resource being
+// unchanged since it was read.
+func (a *API) findModifiers(r *disco.Resource) []*modifier {
+	byName := make(map[string]*Method)
+	for _, m := range a.resourceMethods(r) {
+		byName[m.m.Name] = m
+	}
+	var mods []*modifier
+	add := func(name, get string, writes ...string) {
+		if byName[strings.ToLower(name[:1])+name[1:]] != nil {
+			return // The resource has a method with the same name.
+		}
+		for _, w := range writes {
+			if m := a.newModifier(name, byName[get], byName[w]); m != nil {
+				mods = append(mods, m)
+				return
+			}
+		}
+	}
+	add("Modify", "get", "update", "patch")
+	add("ModifyIamPolicy", "getIamPolicy", "setIamPolicy")
+	return mods
+}
+
+// newModifier returns the modifier that reads a resource with get and writes
+// it with write, or nil if they can't be used together.
+func (a *API) newModifier(name string, get, write *Method) *modifier {
+	if get == nil || write == nil || get.m.Response == nil || write.m.Request == nil || write.m.Response == nil ||
+		get.IsRawResponse() || write.supportsMediaUpload() {
+		return nil
+	}
+	for _, opt := range write.OptParams() {
+		if opt.p.Name == "updateMask" {
+			// The fields to write are up to the caller.
+			return nil
+		}
+	}
+	s := get.responseType()
+	if s == nil || s.typ.Kind != disco.StructKind || s.typ.Variant != nil || write.responseType() != s {
+		return nil
+	}
+	m := &modifier{name: name, get: get, write: write, schema: s}
+	req := a.schemas[write.m.Request.RefSchema.Name]
+	if req != s {
+		// A set method whose request holds the resource, like setIamPolicy.
+		m.wrapper, m.wrapped = req, propertyNamed(req, "policy")
+		if req == nil || m.wrapped == nil || m.wrapped.assignedGoName == "" || a.schemaOf(m.wrapped.Type()) != s {
+			return nil
+		}
+	}
+
+	// The write must take the same parameters as the get.
+	getArgs := make(map[string]*argument)
+	for _, arg := range get.NewArguments().l {
+		if arg.location != "body" {
+			getArgs[arg.apiname] = arg
+		}
+	}
+	for _, arg := range write.NewArguments().l {
+		if arg.location == "body" {
+			continue
+		}
+		if ga := getArgs[arg.apiname]; ga == nil || ga.gotype != arg.gotype {
+			return nil
+		}
+	}
+
+	opts := make(map[string]*Param)
+	for _, opt := range write.OptParams() {
+		opts[opt.p.Name] = opt
+	}
+	switch {
+	case opts["ifMetagenerationMatch"] != nil && opts["ifMetagenerationMatch"].GoType() == "int64" && hasProperty(s, "metageneration", "int64"):
+		m.setCond = fmt.Sprintf("c.IfMetagenerationMatch(x.%s)", propertyNamed(s, "metageneration").assignedGoName)
+		m.condDesc = "its metageneration has not changed"
+	case opts["ifMatch"] != nil && opts["ifMatch"].GoType() == "string" && hasProperty(s, "etag", "string"):
+		m.setCond = fmt.Sprintf("c.IfMatch(x.%s)", propertyNamed(s, "etag").assignedGoName)
+		m.condDesc = "its ETag has not changed"
+	case hasProperty(s, "etag", "string"):
+		m.condDesc = "its ETag, which is sent back with it, has not changed"
+	default:
+		return nil
+	}
+	return m
+}
+
+// writeModifiers writes the Modify methods of the resource r and of its
+// subresources.
+func (a *API) writeModifiers(r *disco.Resource) {
+	for _, m := range a.findModifiers(r) {
+		a.writeModifier(r, m)
+	}
+	for _, res := range r.Resources {
+		a.writeModifiers(res)
+	}
+}
+
+func (a *API) writeModifier(r *disco.Resource, m *modifier) {
+	p, pn := a.p, a.pn
+	getArgs := m.get.NewArguments()
+	np := new(namePool)
+	np.Get("r")
+	var params, getCallArgs []string
+	for _, arg := range getArgs.l {
+		if arg.location == "body" {
+			getCallArgs = append(getCallArgs, "&"+strings.TrimPrefix(arg.gotype, "*")+"{}")
+			continue
+		}
+		np.Get(arg.goname)
+		params = append(params, arg.String())
+		getCallArgs = append(getCallArgs, arg.goname)
+	}
+	ctx, f, opts := np.Get("ctx"), np.Get("f"), np.Get("opts")
+	x, ret, c, err := np.Get("x"), np.Get("ret"), np.Get("c"), np.Get("err")
+	var writeCallArgs []string
+	for _, arg := range m.write.NewArguments().l {
+		switch {
+		case arg.location != "body":
+			writeCallArgs = append(writeCallArgs, arg.goname)
+		case m.wrapper != nil:
+			writeCallArgs = append(writeCallArgs, fmt.Sprintf("&%s{%s: %s}", m.wrapper.GoName(), m.wrapped.assignedGoName, x))
+		default:
+			writeCallArgs = append(writeCallArgs, x)
+		}
+	}
+	typ := m.schema.GoReturnType()
+
+	p("\n%s", asComment("", fmt.Sprintf("%s reads the %s with %s, calls %s to modify it, and writes it back "+
+		"with %s, on condition that %s. If the %s was changed in the meantime, %s starts over, "+
+		"up to a few times. An error from %s is returned as is. "+
+		"The call options are used for both calls.",
+		m.name, m.schema.GoName(), initialCap(m.get.m.Name), f, initialCap(m.write.m.Name), m.condDesc,
+		m.schema.GoName(), m.name, f)))
+	params = append([]string{ctx + " context.Context"}, params...)
+	params = append(params, fmt.Sprintf("%s func(%s) error", f, typ), opts+" ...googleapi.CallOption")
+	pn("func (r *%s) %s(%s) (%s, error) {", resourceGoType(r), m.name, strings.Join(params, ", "), typ)
+	pn(" var %s, %s %s", x, ret, typ)
+	pn(" %s := gensupport.ReadModifyWrite(%s,", err, ctx)
+	pn("  func() (%s error) {", err)
+	pn("   %s, %s = r.%s(%s).Context(%s).Do(%s...)", x, err, initialCap(m.get.m.Name), strings.Join(getCallArgs, ", "), ctx, opts)
+	pn("   return %s", err)
+	pn("  },")
+	pn("  func() error { return %s(%s) },", f, x)
+	pn("  func() (%s error) {", err)
+	pn("   %s := r.%s(%s).Context(%s)", c, initialCap(m.write.m.Name), strings.Join(writeCallArgs, ", "), ctx)
+	if m.setCond != "" {
+		cond := strings.Replace(m.setCond, "c.", c+".", 1)
+		cond = strings.Replace(cond, "(x.", "("+x+".", 1)
+		pn("   %s", cond)
+	}
+	pn("   %s, %s = %s.Do(%s...)", ret, err, c, opts)
+	pn("   return %s", err)
+	pn("  })")
+	pn(" return %s, %s", ret, err)
+	pn("}")
+}
@@ -0,0 +1,380 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/google-api-go-generator/internal/disco"
+)
+
+// operationPoller describes how to poll the long-running operations returned
+// by an API's methods. Two styles of operation are supported: AIP-151
+// operations, which have a name and a done field and are fetched with an
+// operations.get method taking that name, and Compute Engine style
+// operations, which have a status that becomes "DONE" and are fetched with
+// the get or wait method of a zonal, regional or global operations resource.
+type operationPoller struct {
+	schema  *Schema
+	aip     bool               // whether the operations are AIP-151 style
+	getters []*operationGetter // in the order they are tried
+}
+
+// operationGetter is a method that fetches the current state of an operation.
+type operationGetter struct {
+	call string   // Go expression for the method, e.g. "s.ZoneOperations.Wait"
+	args []string // Go expressions for its arguments
+	vars []string // variables that must be non-empty for the method to apply
+}
+
+// findOperationPoller returns how to poll the operations of the API, or nil if
+// it has no operations that can be polled. It must be called after the schema
+// structs have been written.
+func (a *API) findOperationPoller() *operationPoller {
+	var op *operationPoller
+	var visit func(rs []*disco.Resource, parent *disco.Resource, expr string)
+	visit = func(rs []*disco.Resource, parent *disco.Resource, expr string) {
+		for _, r := range rs {
+			rexpr := expr + "." + resourceGoField(r, parent)
+			if r.Name == "operations" || strings.HasSuffix(r.Name, "Operations") {
+				op = a.addOperationGetter(op, r, rexpr)
+			}
+			visit(r.Resources, r, rexpr)
+		}
+	}
+	visit(a.doc.Resources, nil, "s")
+	if op == nil {
+		return nil
+	}
+	for _, m := range a.doc.Methods {
+		if n := initialCap(m.Name); n == "PollOperation" || n == "WaitOperation" {
+			log.Printf("%s: method %s clashes with the operation helpers; not generating them", a.doc.ID, m.Name)
+			return nil
+		}
+	}
+	// Try the getters that need the most information first, so that a zonal
+	// operation isn't mistaken for a global one.
+	sort.SliceStable(op.getters, func(i, j int) bool {
+		return len(op.getters[i].args) > len(op.getters[j].args)
+	})
+	return op
+}
+
+// addOperationGetter adds the get or wait method of the operations resource r
+// to op, if it is one, and returns op. op is created by the first getter found.
+func (a *API) addOperationGetter(op *operationPoller, r *disco.Resource, rexpr string) *operationPoller {
+	var meth *Method
+	for _, m := range a.resourceMethods(r) {
+		// Prefer wait, which returns as soon as the operation is done.
+		if m.m.Name == "wait" || m.m.Name == "get" && meth == nil {
+			meth = m
+		}
+	}
+	if meth == nil || meth.m.Response == nil || meth.m.Response.RefSchema == nil {
+		return op
+	}
+	s := meth.responseType()
+	if s == nil || op != nil && s != op.schema {
+		return op
+	}
+	aip := a.isAIPOperation(s)
+	if !aip && !a.isComputeOperation(s) {
+		return op
+	}
+	if op != nil && op.aip {
+		// Any operations.get method can fetch any AIP-151 operation by name.
+		return op
+	}
+	g := &operationGetter{call: rexpr + "." + initialCap(meth.m.Name)}
+	for _, arg := range meth.NewArguments().l {
+		if arg.location == "body" || arg.gotype != "string" {
+			return op
+		}
+		var expr string
+		switch arg.apiname {
+		case "name":
+			if aip {
+				expr = "op." + propertyNamed(s, "name").assignedGoName
+			}
+		case "project", "projectId":
+			if !aip && propertyNamed(s, "selfLink") != nil {
+				expr = "project"
+			}
+		case "zone", "region":
+			if !aip && propertyNamed(s, arg.apiname) != nil {
+				expr = arg.apiname
+			}
+		case "operation", "operationId":
+			if !aip {
+				expr = "op." + propertyNamed(s, "name").assignedGoName
+			}
+		}
+		if expr == "" {
+			return op
+		}
+		g.args = append(g.args, expr)
+		g.vars = append(g.vars, expr)
+	}
+	if len(g.args) == 0 {
+		return op
+	}
+	if op == nil {
+		op = &operationPoller{schema: s, aip: aip}
+	}
+	op.getters = append(op.getters, g)
+	return op
+}
+
+// isAIPOperation reports whether s is an AIP-151 operation: it has a name, a
+// done field and an error that is a google.rpc.Status.
+func (a *API) isAIPOperation(s *Schema) bool {
+	if !hasProperty(s, "name", "string") || !hasProperty(s, "done", "bool") {
+		return false
+	}
+	st := a.propertySchema(s, "error")
+	return st != nil && hasProperty(st, "code", "int64") && hasProperty(st, "message", "string")
+}
+
+// isComputeOperation reports whether s is a Compute Engine style operation: it
+// has a name, a status that can be "DONE", and an error with a list of errors.
+func (a *API) isComputeOperation(s *Schema) bool {
+	if !hasProperty(s, "name", "string") || !hasProperty(s, "status", "string") {
+		return false
+	}
+	enum, _ := propertyNamed(s, "status").Enum()
+	if !containsString(enum, "DONE") {
+		return false
+	}
+	es := a.propertySchema(s, "error")
+	if es == nil {
+		return false
+	}
+	p := propertyNamed(es, "errors")
+	if p == nil || p.Type().Kind != disco.ArrayKind {
+		return false
+	}
+	item := a.schemaOf(p.Type().ElementSchema())
+	return item != nil && hasProperty(item, "code", "string") && hasProperty(item, "message", "string")
+}
+
+// writeOperationHelpers writes the PollOperation and WaitOperation methods of
+// the service.
+func (a *API) writeOperationHelpers() {
+	op := a.operations
+	if op == nil {
+		return
+	}
+	pn := a.pn
+	s := op.schema
+	typ := s.GoReturnType()
+	field := func(name string) string { return "op." + propertyNamed(s, name).assignedGoName }
+
+	pn("\n// PollOperation fetches the current state of the long-running operation op.")
+	pn("func (s *%s) PollOperation(ctx context.Context, op %s) (%s, error) {", a.ServiceType(), typ, typ)
+	if op.aip {
+		g := op.getters[0]
+		pn(" return %s(%s).Context(ctx).Do()", g.call, strings.Join(g.args, ", "))
+	} else {
+		used := make(map[string]bool)
+		for _, g := range op.getters {
+			for _, v := range g.vars {
+				used[v] = true
+			}
+		}
+		if used["project"] {
+			pn(` project := gensupport.PathSegmentAfter(%s, "projects")`, field("selfLink"))
+		}
+		for _, v := range []string{"zone", "region"} {
+			if used[v] {
+				pn(" %s := gensupport.LastPathSegment(%s)", v, field(v))
+			}
+		}
+		pn(" switch {")
+		for _, g := range op.getters {
+			var conds []string
+			for _, v := range g.vars {
+				conds = append(conds, v+` != ""`)
+			}
+			pn(" case %s:", strings.Join(conds, " && "))
+			pn("  return %s(%s).Context(ctx).Do()", g.call, strings.Join(g.args, ", "))
+		}
+		pn(" }")
+		pn(` return nil, fmt.Errorf("cannot poll operation %%q", %s)`, field("name"))
+	}
+	pn("}")
+
+	var done string
+	if op.aip {
+		done = field("done")
+	} else {
+		done = field("status") + ` == "DONE"`
+	}
+	pn("\n// WaitOperation polls the long-running operation op with exponential backoff")
+	pn("// until it is done, and returns its final state. If the operation failed, the")
+	pn("// error is a *googleapi.Error that describes the failure. If ctx is done first,")
+	pn("// WaitOperation returns the last state of the operation and ctx.Err().")
+	pn("func (s *%s) WaitOperation(ctx context.Context, op %s) (%s, error) {", a.ServiceType(), typ, typ)
+	pn(" err := gensupport.WaitOperation(ctx, func() (bool, error) {")
+	pn("  if %s { return true, nil }", done)
+	pn("  o, err := s.PollOperation(ctx, op)")
+	pn("  if err != nil { return false, err }")
+	pn("  op = o")
+	pn("  return %s, nil", done)
+	pn(" })")
+	pn(" if err != nil { return op, err }")
+	pn(" return op, s.operationError(op)")
+	pn("}")
+
+	pn("\n// operationError returns the error that the done operation op failed with,")
+	pn("// or nil if it succeeded.")
+	pn("func (s *%s) operationError(op %s) error {", a.ServiceType(), typ)
+	es := a.propertySchema(s, "error")
+	ef := field("error")
+	if op.aip {
+		details := "nil"
+		if hasProperty(es, "details", "[]googleapi.RawMessage") {
+			details = ef + "." + propertyNamed(es, "details").assignedGoName
+		}
+		pn(" if %s != nil {", ef)
+		pn("  return gensupport.OperationError(%s.%s, %s.%s, %s)", ef, propertyNamed(es, "code").assignedGoName,
+			ef, propertyNamed(es, "message").assignedGoName, details)
+		pn(" }")
+	} else {
+		errs := ef + "." + propertyNamed(es, "errors").assignedGoName
+		item := a.schemaOf(propertyNamed(es, "errors").Type().ElementSchema())
+		pn(" if %s != nil && len(%s) > 0 {", ef, errs)
+		var init []string
+		if hasProperty(s, "httpErrorStatusCode", "int64") {
+			init = append(init, fmt.Sprintf("Code: int(%s)", field("httpErrorStatusCode")))
+		}
+		if hasProperty(s, "httpErrorMessage", "string") {
+			init = append(init, fmt.Sprintf("Message: %s", field("httpErrorMessage")))
+		}
+		pn("  e := &googleapi.Error{%s}", strings.Join(init, ", "))
+		pn("  for _, item := range %s {", errs)
+		pn("   e.Errors = append(e.Errors, googleapi.ErrorItem{Reason: item.%s, Message: item.%s})",
+			propertyNamed(item, "code").assignedGoName, propertyNamed(item, "message").assignedGoName)
+		pn("  }")
+		pn("  return e")
+		pn(" }")
+	}
+	pn(" return nil")
+	pn("}")
+}
+
+// returnsOperation reports whether the calls of meth get Wait and Poll
+// methods: their response is an operation that can be polled.
+func (a *API) returnsOperation(meth *Method) bool {
+	op := a.operations
+	if op == nil || meth.IsRawResponse() || meth.m.Response == nil || meth.responseType() != op.schema {
+		return false
+	}
+	for _, opt := range meth.OptParams() {
+		if n := initialCap(opt.p.Name); n == "Wait" || n == "Poll" {
+			return false
+		}
+	}
+	return true
+}
+
+// writeCallWait writes the Wait and Poll methods of a call whose response is
+// an operation that can be polled.
+func (a *API) writeCallWait(meth *Method, callName string) {
+	if !a.returnsOperation(meth) {
+		return
+	}
+	p, pn := a.p, a.pn
+	s := a.operations.schema
+	typ := s.GoReturnType()
+	p("\n%s", asComment("", fmt.Sprintf("Wait executes the %q call and then waits for the long-running operation it returns, as described by %s.WaitOperation. "+
+		"The provided context supersedes any context provided to the Context method.", meth.m.ID, a.ServiceType())))
+	pn("func (c *%s) Wait(ctx context.Context, opts ...googleapi.CallOption) (%s, error) {", callName, typ)
+	pn(" c.ctx_ = ctx")
+	pn(" op, err := c.Do(opts...)")
+	pn(" if err != nil { return nil, err }")
+	pn(" return c.s.WaitOperation(ctx, op)")
+	pn("}")
+
+	var notDone string
+	if a.operations.aip {
+		notDone = "!op." + propertyNamed(s, "done").assignedGoName
+	} else {
+		notDone = "op." + propertyNamed(s, "status").assignedGoName + ` != "DONE"`
+	}
+	p("\n%s", asComment("", fmt.Sprintf("Poll checks on the long-running operation of the %q call without waiting for it. "+
+		"The first call of Poll executes the call with opts and returns the operation it starts. "+
+		"Each later call fetches the current state of that operation once, as described by %s.PollOperation, until it is done. "+
+		"If the operation failed, the error is the one %s.WaitOperation would return. "+
+		"The provided context supersedes any context provided to the Context method.", meth.m.ID, a.ServiceType(), a.ServiceType())))
+	pn("func (c *%s) Poll(ctx context.Context, opts ...googleapi.CallOption) (%s, error) {", callName, typ)
+	pn(" op := c.op_")
+	pn(" if op == nil {")
+	pn("  c.ctx_ = ctx")
+	pn("  o, err := c.Do(opts...)")
+	pn("  if err != nil { return nil, err }")
+	pn("  op = o")
+	pn(" } else if %s {", notDone)
+	pn("  o, err := c.s.PollOperation(ctx, op)")
+	pn("  if err != nil { return op, err }")
+	pn("  op = o")
+	pn(" }")
+	pn(" c.op_ = op")
+	pn(" if %s { return op, nil }", notDone)
+	pn(" return op, c.s.operationError(op)")
+	pn("}")
+}
+
+// propertyNamed returns the property of s with the API name name, or nil.
+func propertyNamed(s *Schema, name string) *Property {
+	if s.typ.Kind != disco.StructKind {
+		return nil
+	}
+	for _, p := range s.properties() {
+		if p.p.Name == name {
+			return p
+		}
+	}
+	return nil
+}
+
+// hasProperty reports whether s has a property with the API name name and
+// the Go type goType.
+func hasProperty(s *Schema, name, goType string) bool {
+	p := propertyNamed(s, name)
+	return p != nil && p.assignedGoName != "" && p.TypeAsGo() == goType
+}
+
+// propertySchema returns the schema of the struct-valued property of s with
+// the API name name, or nil.
+func (a *API) propertySchema(s *Schema, name string) *Schema {
+	p := propertyNamed(s, name)
+	if p == nil || p.assignedGoName == "" {
+		return nil
+	}
+	return a.schemaOf(p.Type())
+}
+
+// schemaOf returns the schema for the struct type t, following references.
+func (a *API) schemaOf(t *disco.Schema) *Schema {
+	if t.RefSchema != nil {
+		t = t.RefSchema
+	}
+	if t.Kind != disco.StructKind {
+		return nil
+	}
+	return a.schemas[t.Name]
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// streamItems returns the property of the response of the paged list method
+// meth that holds the items of a page, or nil if it has none or the Stream
+// method can't be generated for meth.
+func (meth *Method) streamItems() *Property {
+	if _, _, ok := meth.supportsPaging(); !ok || meth.api.needsDataWrapper() || meth.IsRawResponse() {
+		return nil
+	}
+	for _, opt := range meth.OptParams() {
+		if initialCap(opt.p.Name) == "Stream" {
+			return nil
+		}
+	}
+	for _, p := range meth.responseType().properties() {
+		switch p.p.Name {
+		case "items", "resources":
+			if p.assignedGoName != "" && strings.HasPrefix(p.TypeAsGo(), "[]") {
+				return p
+			}
+		}
+	}
+	return nil
+}
+
+// writeCallStream writes the Stream method of a call to a paged list method,
+// which decodes the items of the response one at a time.
+func (a *API) writeCallStream(meth *Method, callName string) {
+	items := meth.streamItems()
+	if items == nil {
+		return
+	}
+	p, pn := a.p, a.pn
+	retType := responseType(a, meth.m)
+	elemType := strings.TrimPrefix(items.TypeAsGo(), "[]")
+
+	p("\n%s", asComment("", fmt.Sprintf("Stream is like Do, but instead of storing the %s of the response, "+
+		"it calls f with each of them as it is decoded, so that a large page needn't be held in memory. "+
+		"The other fields of the response, like the next page token, are filled in as usual. "+
+		"A non-nil error returned from f stops the decoding and is returned.", items.assignedGoName)))
+	pn("func (c *%s) Stream(f func(%s) error, opts ...googleapi.CallOption) (%s, error) {", callName, elemType, retType)
+	pn(`gensupport.SetOptions(c.urlParams_, opts...)`)
+	pn(`res, err := c.doRequest("json")`)
+	pn("if res != nil && res.StatusCode == http.StatusNotModified {")
+	pn(" if res.Body != nil { res.Body.Close() }")
+	pn(" return nil, &googleapi.Error{")
+	pn("  Code: res.StatusCode,")
+	pn("  Header: res.Header,")
+	pn(" }")
+	pn("}")
+	pn("if err != nil { return nil, err }")
+	pn("defer googleapi.CloseBody(res)")
+	pn("if err := googleapi.CheckResponse(res); err != nil { return nil, err }")
+	pn("ret := &%s{", responseTypeLiteral(a, meth.m))
+	pn(" ServerResponse: googleapi.ServerResponse{")
+	pn("  Header: res.Header,")
+	pn("  HTTPStatusCode: res.StatusCode,")
+	pn(" },")
+	pn("}")
+	pn("err = gensupport.DecodeResponseItems(ret, res, %q, func(dec *json.Decoder) error {", items.p.Name)
+	pn(" var item %s", elemType)
+	pn(" if err := dec.Decode(&item); err != nil { return err }")
+	pn(" return f(item)")
+	pn("})")
+	pn("if err != nil { return nil, err }")
+	pn("return ret, nil")
+	pn("}")
+}
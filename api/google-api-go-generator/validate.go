@@ -0,0 +1,125 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"google.golang.org/api/google-api-go-generator/internal/disco"
+)
+
+// validateChecks returns the statements of the validate method of a call to
+// meth, which check its parameters and request body with a
+// gensupport.Validator named v. It returns nil if there is nothing to check.
+func (meth *Method) validateChecks() []string {
+	var checks []string
+	params := make(map[string]*disco.Parameter)
+	for _, p := range meth.m.Parameters {
+		params[p.Name] = p
+	}
+	for _, arg := range meth.NewArguments().l {
+		switch arg.location {
+		case "path", "query":
+			value := "c." + arg.goname
+			if arg.location == "query" {
+				value = fmt.Sprintf("c.urlParams_.Get(%q)", arg.apiname)
+			}
+			switch {
+			case arg.gotype == "string":
+				checks = append(checks, fmt.Sprintf("v.Required(%q, %s != \"\")", arg.apiname, value))
+				if p := params[arg.apiname]; p != nil && p.Pattern != "" {
+					checks = append(checks, fmt.Sprintf("v.Pattern(%q, %s, %q)", arg.apiname, value, p.Pattern))
+				}
+			case arg.location == "path" && strings.HasPrefix(arg.gotype, "[]"):
+				checks = append(checks, fmt.Sprintf("v.Required(%q, len(%s) > 0)", arg.apiname, value))
+			case arg.location == "query" && strings.HasPrefix(arg.gotype, "[]"):
+				checks = append(checks, fmt.Sprintf("v.Required(%q, len(c.urlParams_[%q]) > 0)", arg.apiname, arg.apiname))
+			}
+		case "body":
+			checks = append(checks, meth.bodyChecks(arg)...)
+		}
+	}
+	for _, opt := range meth.OptParams() {
+		if opt.p.Pattern != "" && !opt.p.Repeated && opt.GoType() == "string" {
+			checks = append(checks, fmt.Sprintf("v.Pattern(%q, c.urlParams_.Get(%q), %q)", opt.p.Name, opt.p.Name, opt.p.Pattern))
+		}
+	}
+	return checks
+}
+
+// bodyChecks returns the checks of the fields of the request body arg that
+// are annotated as required by meth.
+func (meth *Method) bodyChecks(arg *argument) []string {
+	s := arg.schema
+	if s == nil || s.typ.Kind != disco.StructKind || s.typ.Variant != nil {
+		return nil
+	}
+	body := "c." + arg.goname
+	var fields []string
+	for _, p := range s.properties() {
+		if p.assignedGoName == "" || !containsString(p.p.Schema.Annotations.Required, meth.m.ID) {
+			continue
+		}
+		set := fieldSetExpr(body+"."+p.assignedGoName, p)
+		if set == "" {
+			continue
+		}
+		fields = append(fields, fmt.Sprintf(" v.Required(%q, %s)", arg.goname+"."+p.p.Name, set))
+	}
+	if len(fields) == 0 {
+		return nil
+	}
+	checks := []string{fmt.Sprintf("if %s != nil {", body)}
+	checks = append(checks, fields...)
+	return append(checks, "}")
+}
+
+// fieldSetExpr returns an expression that reports whether the struct field
+// x, which holds the property p, is set, or "" if that can't be told from
+// its value.
+func fieldSetExpr(x string, p *Property) string {
+	typ := p.TypeAsGo()
+	if p.forcePointerType() {
+		typ = "*" + typ
+	}
+	t := p.Type()
+	if t.Kind == disco.ReferenceKind {
+		t = t.RefSchema
+	}
+	switch {
+	case typ == "string":
+		return x + ` != ""`
+	case strings.HasPrefix(typ, "*"):
+		return x + " != nil"
+	case strings.HasPrefix(typ, "[]"), strings.HasPrefix(typ, "map["),
+		t.Kind == disco.ArrayKind, t.Kind == disco.MapKind, t.Kind == disco.AnyStructKind,
+		t.Kind == disco.StructKind && t.Variant != nil:
+		return "len(" + x + ") > 0"
+	case t.Kind == disco.SimpleKind && t.Type == "string":
+		return x + ` != ""`
+	}
+	return ""
+}
+
+// writeCallValidate writes the validate method of a call to meth, and
+// reports whether it did.
+func (a *API) writeCallValidate(meth *Method, callName string) bool {
+	checks := meth.validateChecks()
+	if len(checks) == 0 {
+		return false
+	}
+	pn := a.pn
+	pn("\n// validate checks the parameters and request body of the call before")
+	pn("// its request is sent.")
+	pn("func (c *%s) validate() error {", callName)
+	pn(" v := gensupport.NewValidator(%q)", meth.m.ID)
+	for _, c := range checks {
+		pn(" %s", c)
+	}
+	pn(" return v.Err()")
+	pn("}")
+	return true
+}
@@ -14,8 +14,11 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"google.golang.org/api/internal/callopt"
 	"google.golang.org/api/internal/third_party/uritemplates"
 )
 
@@ -78,6 +81,16 @@ type Error struct {
 	Header http.Header
 
 	Errors []ErrorItem
+
+	// ErrorInfo, RetryInfo, QuotaFailure, BadRequest, Help and
+	// LocalizedMessage are the standard google.rpc error details found in
+	// Details, if any.
+	ErrorInfo        *ErrorInfo        `json:"-"`
+	RetryInfo        *RetryInfo        `json:"-"`
+	QuotaFailure     *QuotaFailure     `json:"-"`
+	BadRequest       *BadRequest       `json:"-"`
+	Help             *Help             `json:"-"`
+	LocalizedMessage *LocalizedMessage `json:"-"`
 }
 
 // ErrorItem is a detailed error code & message from the Google API frontend.
@@ -88,6 +101,168 @@ type ErrorItem struct {
 	Message string `json:"message"`
 }
 
+// ErrorInfo describes the cause of an error. It is the google.rpc.ErrorInfo
+// error detail.
+type ErrorInfo struct {
+	// Reason is a constant identifying the proximate cause of the error,
+	// unique within Domain. For example: "API_DISABLED".
+	Reason string `json:"reason"`
+	// Domain is the logical grouping to which Reason belongs, typically the
+	// name of the service. For example: "googleapis.com".
+	Domain string `json:"domain"`
+	// Metadata holds additional structured details about the error.
+	Metadata map[string]string `json:"metadata"`
+}
+
+// RetryInfo tells the client when it can retry a failed request. It is the
+// google.rpc.RetryInfo error detail.
+type RetryInfo struct {
+	// RetryDelay is how long clients should wait before retrying.
+	RetryDelay time.Duration
+}
+
+// QuotaFailure describes how a quota check failed. It is the
+// google.rpc.QuotaFailure error detail.
+type QuotaFailure struct {
+	Violations []QuotaViolation `json:"violations"`
+}
+
+// QuotaViolation describes a single quota violation.
+type QuotaViolation struct {
+	// Subject is the subject on which the quota check failed. For example:
+	// "project:my-project".
+	Subject string `json:"subject"`
+	// Description explains how the quota check failed.
+	Description string `json:"description"`
+}
+
+// BadRequest describes violations in a client request. It is the
+// google.rpc.BadRequest error detail.
+type BadRequest struct {
+	FieldViolations []FieldViolation `json:"fieldViolations"`
+}
+
+// FieldViolation describes a single bad request field.
+type FieldViolation struct {
+	// Field is the path to the field in the request. For example:
+	// "instance.name".
+	Field string `json:"field"`
+	// Description explains why the field is bad.
+	Description string `json:"description"`
+}
+
+// Help provides links to documentation. It is the google.rpc.Help error
+// detail.
+type Help struct {
+	Links []HelpLink `json:"links"`
+}
+
+// HelpLink is a link to documentation.
+type HelpLink struct {
+	Description string `json:"description"`
+	URL         string `json:"url"`
+}
+
+// LocalizedMessage is an error message in a given locale. It is the
+// google.rpc.LocalizedMessage error detail.
+type LocalizedMessage struct {
+	// Locale is the BCP-47 locale of Message. For example: "en-US".
+	Locale  string `json:"locale"`
+	Message string `json:"message"`
+}
+
+// Reason returns the reason of the error from its ErrorInfo detail, or else
+// the reason of its first ErrorItem. It returns "" if neither is present.
+func (e *Error) Reason() string {
+	if e.ErrorInfo != nil {
+		return e.ErrorInfo.Reason
+	}
+	if len(e.Errors) > 0 {
+		return e.Errors[0].Reason
+	}
+	return ""
+}
+
+// Domain returns the domain of the error from its ErrorInfo detail, or "".
+func (e *Error) Domain() string {
+	if e.ErrorInfo != nil {
+		return e.ErrorInfo.Domain
+	}
+	return ""
+}
+
+// RetryDelay returns how long the server asked clients to wait before
+// retrying, from the error's RetryInfo detail. It returns 0 if the server
+// didn't say.
+func (e *Error) RetryDelay() time.Duration {
+	if e.RetryInfo != nil {
+		return e.RetryInfo.RetryDelay
+	}
+	return 0
+}
+
+// detailTypePrefix precedes the names of the google.rpc error detail types in
+// the "@type" field of a detail.
+const detailTypePrefix = "type.googleapis.com/google.rpc."
+
+// decodeDetails sets the typed error details from e.Details. Details that
+// can't be decoded are ignored.
+func (e *Error) decodeDetails() {
+	for _, d := range e.Details {
+		m, ok := d.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		typ, _ := m["@type"].(string)
+		if !strings.HasPrefix(typ, detailTypePrefix) {
+			continue
+		}
+		b, err := json.Marshal(m)
+		if err != nil {
+			continue
+		}
+		switch strings.TrimPrefix(typ, detailTypePrefix) {
+		case "ErrorInfo":
+			v := new(ErrorInfo)
+			if json.Unmarshal(b, v) == nil {
+				e.ErrorInfo = v
+			}
+		case "RetryInfo":
+			var v struct {
+				RetryDelay string `json:"retryDelay"`
+			}
+			if json.Unmarshal(b, &v) != nil {
+				continue
+			}
+			// Durations are encoded as decimal seconds with an "s" suffix,
+			// which time.ParseDuration accepts.
+			if delay, err := time.ParseDuration(v.RetryDelay); err == nil {
+				e.RetryInfo = &RetryInfo{RetryDelay: delay}
+			}
+		case "QuotaFailure":
+			v := new(QuotaFailure)
+			if json.Unmarshal(b, v) == nil {
+				e.QuotaFailure = v
+			}
+		case "BadRequest":
+			v := new(BadRequest)
+			if json.Unmarshal(b, v) == nil {
+				e.BadRequest = v
+			}
+		case "Help":
+			v := new(Help)
+			if json.Unmarshal(b, v) == nil {
+				e.Help = v
+			}
+		case "LocalizedMessage":
+			v := new(LocalizedMessage)
+			if json.Unmarshal(b, v) == nil {
+				e.LocalizedMessage = v
+			}
+		}
+	}
+}
+
 func (e *Error) Error() string {
 	if len(e.Errors) == 0 && e.Message == "" {
 		return fmt.Sprintf("googleapi: got HTTP response code %d with body: %v", e.Code, e.Body)
@@ -121,6 +296,30 @@ func (e *Error) Error() string {
 	return buf.String()
 }
 
+// A ValidationError is returned by a call whose parameters or request body
+// fail the checks made before its request is sent, such as a required
+// parameter that is empty or a resource name that doesn't match the pattern
+// of its parameter. Use SkipValidation to turn the checks off.
+type ValidationError struct {
+	// Method is the ID of the method, e.g. "tasks.tasklists.get".
+	Method string
+	// Violations describes each problem. The Field of a request body field
+	// is prefixed with the name of the body, as in "task.title".
+	Violations []FieldViolation
+}
+
+func (e *ValidationError) Error() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "googleapi: invalid call to %s: ", e.Method)
+	for i, v := range e.Violations {
+		if i > 0 {
+			buf.WriteString("; ")
+		}
+		fmt.Fprintf(&buf, "%s: %s", v.Field, v.Description)
+	}
+	return buf.String()
+}
+
 type errorReply struct {
 	Error *Error `json:"error"`
 }
@@ -140,6 +339,8 @@ func CheckResponse(res *http.Response) error {
 				jerr.Error.Code = res.StatusCode
 			}
 			jerr.Error.Body = string(slurp)
+			jerr.Error.Header = res.Header
+			jerr.Error.decodeDetails()
 			return jerr.Error
 		}
 	}
@@ -381,6 +582,130 @@ func CombineFields(s []Field) string {
 	return strings.Join(r, ",")
 }
 
+// Subfields returns the field f restricted to the subfields sub, as in
+// "items(id,updated)". It returns f if sub is empty.
+func Subfields(f Field, sub ...Field) Field {
+	if len(sub) == 0 {
+		return f
+	}
+	return f + "(" + Field(CombineFields(sub)) + ")"
+}
+
+// A FieldSchema describes the fields of an API schema, so that field masks
+// can be checked against it. The field selectors that the generator writes
+// for the schemas of an API with -field_selectors, such as
+// storage.ObjectFields, implement FieldSchema.
+type FieldSchema interface {
+	// LookupField reports whether the schema has a field with the given
+	// JSON name, and returns the FieldSchema of the field's value if that
+	// is an object, or nil otherwise.
+	LookupField(name string) (FieldSchema, bool)
+}
+
+// AnyFieldSchema is the FieldSchema of maps and of objects with arbitrary
+// fields, which have fields of any name.
+var AnyFieldSchema FieldSchema = anyFieldSchema{}
+
+type anyFieldSchema struct{}
+
+func (anyFieldSchema) LookupField(string) (FieldSchema, bool) { return anyFieldSchema{}, true }
+
+// A FieldMask is a set of paths to fields of a resource, such as
+// "displayName" or "metadata.labels", that tells methods like patch which
+// fields of the resource to update. The elements of a path are the JSON
+// names of the fields, separated by dots.
+type FieldMask []string
+
+// String returns m in the form of an updateMask parameter.
+func (m FieldMask) String() string {
+	return strings.Join(m, ",")
+}
+
+// NewFieldMask returns the field mask of the fields fs of a resource whose
+// schema is s. The fields are written as for partial responses, so that
+// "owner/entity" and "owner(entity,entityId)" become the paths
+// "owner.entity" and "owner.entity", "owner.entityId". NewFieldMask returns
+// an error if a field is not one of s.
+func NewFieldMask(s FieldSchema, fs ...Field) (FieldMask, error) {
+	var m FieldMask
+	for _, f := range fs {
+		paths, err := fieldPaths(string(f))
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range paths {
+			if err := checkFieldPath(s, p); err != nil {
+				return nil, err
+			}
+			m = append(m, p)
+		}
+	}
+	return m, nil
+}
+
+// fieldPaths returns the dotted paths of the fields selected by f, which is
+// written as for partial responses.
+func fieldPaths(f string) ([]string, error) {
+	var paths []string
+	for _, item := range splitFields(f) {
+		i := strings.IndexByte(item, '(')
+		if i < 0 {
+			if item == "" || strings.ContainsAny(item, ")") {
+				return nil, fmt.Errorf("googleapi: malformed field %q", f)
+			}
+			paths = append(paths, strings.Replace(item, "/", ".", -1))
+			continue
+		}
+		if i == 0 || !strings.HasSuffix(item, ")") {
+			return nil, fmt.Errorf("googleapi: malformed field %q", f)
+		}
+		prefix := strings.Replace(item[:i], "/", ".", -1)
+		sub, err := fieldPaths(item[i+1 : len(item)-1])
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range sub {
+			paths = append(paths, prefix+"."+p)
+		}
+	}
+	return paths, nil
+}
+
+// splitFields splits f at the commas that are not within parentheses.
+func splitFields(f string) []string {
+	var items []string
+	depth, start := 0, 0
+	for i, c := range f {
+		switch c {
+		case '(':
+			depth++
+		case ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				items = append(items, f[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(items, f[start:])
+}
+
+// checkFieldPath returns an error if the dotted path p is not the path of a
+// field of s.
+func checkFieldPath(s FieldSchema, p string) error {
+	for _, name := range strings.Split(p, ".") {
+		if s == nil {
+			return fmt.Errorf("googleapi: field mask path %q: field before %q has no subfields", p, name)
+		}
+		var ok bool
+		if s, ok = s.LookupField(name); !ok {
+			return fmt.Errorf("googleapi: field mask path %q: unknown field %q", p, name)
+		}
+	}
+	return nil
+}
+
 // A CallOption is an optional argument to an API call.
 // It should be treated as an opaque value by users of Google APIs.
 //
@@ -418,3 +743,48 @@ type traceTok string
 func (t traceTok) Get() (string, string) { return "trace", "token:" + string(t) }
 
 // TODO: Fields too
+
+// SkipValidation returns a CallOption that turns off the checks of a call's
+// parameters and request body that are made before its request is sent, so
+// that the server sees the request even if it is invalid.
+func SkipValidation() CallOption { return skipValidation{} }
+
+type skipValidation struct{}
+
+// Get returns a parameter whose name begins with "$". Such parameters hold
+// call options for the client, and are not sent.
+func (skipValidation) Get() (string, string) { return callopt.SkipValidation, "true" }
+
+// RawDownload returns a CallOption for the Download method of calls that
+// asks the server for a gzip-compressed body and returns it as sent. The
+// Content-Encoding header of the response tells whether the body is
+// compressed, and the caller must decompress it. Without RawDownload, a
+// compressed body is decompressed transparently. RawDownload has no effect
+// on Do.
+func RawDownload() CallOption { return rawDownload{} }
+
+type rawDownload struct{}
+
+// Get returns a parameter whose name begins with "$". Such parameters hold
+// call options for the client, and are not sent.
+func (rawDownload) Get() (string, string) { return callopt.RawDownload, "true" }
+
+// RetryBuffer returns a CallOption that makes a call retryable even if its
+// request body can only be read once, like media read from a plain io.Reader
+// with chunking turned off: the body is kept as it is sent, up to maxMemory
+// bytes in memory and the rest in a temporary file, and sent again from the
+// copy when the call is retried. The copy is released when the call is done.
+//
+// Only some calls are retried: those of methods with a request ID parameter,
+// which the client fills in so that a retry is not applied twice, and media
+// uploads to Cloud Storage. RetryBuffer has no effect on other calls, or on
+// calls whose body can be read again anyway.
+func RetryBuffer(maxMemory int64) CallOption { return retryBuffer(maxMemory) }
+
+type retryBuffer int64
+
+// Get returns a parameter whose name begins with "$". Such parameters hold
+// call options for the client, and are not sent.
+func (b retryBuffer) Get() (string, string) {
+	return callopt.RetryBuffer, strconv.FormatInt(int64(b), 10)
+}
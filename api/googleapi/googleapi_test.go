@@ -12,6 +12,7 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 )
 
 type ExpandTest struct {
@@ -283,6 +284,12 @@ var checkResponseTests = []CheckResponseTest{
 				},
 			},
 			Body: `{"error": {"code": 400,"message": "The request has errors","status": "INVALID_ARGUMENT","details": [{"@type": "type.googleapis.com/google.rpc.BadRequest","fieldViolations": [{"field": "metadata.name","description": "The revision name must be prefixed by the name of the enclosing Service or Configuration with a trailing -"}]}]}}`,
+			BadRequest: &BadRequest{
+				FieldViolations: []FieldViolation{{
+					Field:       "metadata.name",
+					Description: "The revision name must be prefixed by the name of the enclosing Service or Configuration with a trailing -",
+				}},
+			},
 		},
 		`googleapi: Error 400: The request has errors
 Details:
@@ -401,3 +408,117 @@ func TestRoundChunkSize(t *testing.T) {
 		}
 	}
 }
+
+func TestCheckResponseErrorDetails(t *testing.T) {
+	res := &http.Response{
+		StatusCode: http.StatusTooManyRequests,
+		Header:     http.Header{"Retry-After": {"2"}},
+		Body: ioutil.NopCloser(strings.NewReader(`{"error": {"code": 429, "message": "Quota exceeded", "status": "RESOURCE_EXHAUSTED", "details": [
+			{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "RATE_LIMIT_EXCEEDED", "domain": "googleapis.com", "metadata": {"service": "pubsub.googleapis.com"}},
+			{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "1.500s"},
+			{"@type": "type.googleapis.com/google.rpc.QuotaFailure", "violations": [{"subject": "project:p", "description": "too many"}]},
+			{"@type": "type.googleapis.com/google.rpc.Help", "links": [{"description": "docs", "url": "https://example.com"}]},
+			{"@type": "type.googleapis.com/google.rpc.LocalizedMessage", "locale": "en-US", "message": "slow down"},
+			{"@type": "type.googleapis.com/google.rpc.DebugInfo", "detail": "ignored"}
+		]}}`)),
+	}
+	err, ok := CheckResponse(res).(*Error)
+	if !ok {
+		t.Fatalf("CheckResponse: got %T, want *Error", err)
+	}
+	if got, want := err.ErrorInfo, (&ErrorInfo{Reason: "RATE_LIMIT_EXCEEDED", Domain: "googleapis.com", Metadata: map[string]string{"service": "pubsub.googleapis.com"}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("ErrorInfo: got %+v, want %+v", got, want)
+	}
+	if got, want := err.QuotaFailure, (&QuotaFailure{Violations: []QuotaViolation{{Subject: "project:p", Description: "too many"}}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("QuotaFailure: got %+v, want %+v", got, want)
+	}
+	if got, want := err.Help, (&Help{Links: []HelpLink{{Description: "docs", URL: "https://example.com"}}}); !reflect.DeepEqual(got, want) {
+		t.Errorf("Help: got %+v, want %+v", got, want)
+	}
+	if got, want := err.LocalizedMessage, (&LocalizedMessage{Locale: "en-US", Message: "slow down"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("LocalizedMessage: got %+v, want %+v", got, want)
+	}
+	if err.BadRequest != nil {
+		t.Errorf("BadRequest: got %+v, want nil", err.BadRequest)
+	}
+	if got, want := err.Reason(), "RATE_LIMIT_EXCEEDED"; got != want {
+		t.Errorf("Reason: got %q, want %q", got, want)
+	}
+	if got, want := err.Domain(), "googleapis.com"; got != want {
+		t.Errorf("Domain: got %q, want %q", got, want)
+	}
+	if got, want := err.RetryDelay(), 1500*time.Millisecond; got != want {
+		t.Errorf("RetryDelay: got %v, want %v", got, want)
+	}
+	if got := err.Header.Get("Retry-After"); got != "2" {
+		t.Errorf("Header: got Retry-After %q, want %q", got, "2")
+	}
+}
+
+func TestErrorAccessorsWithoutDetails(t *testing.T) {
+	e := &Error{Errors: []ErrorItem{{Reason: "keyInvalid"}}}
+	if got, want := e.Reason(), "keyInvalid"; got != want {
+		t.Errorf("Reason: got %q, want %q", got, want)
+	}
+	if got := e.Domain(); got != "" {
+		t.Errorf("Domain: got %q, want empty", got)
+	}
+	if got := e.RetryDelay(); got != 0 {
+		t.Errorf("RetryDelay: got %v, want 0", got)
+	}
+}
+
+// testFieldSchema is a FieldSchema whose fields are its keys. A field whose
+// value is nil holds no object.
+type testFieldSchema map[string]FieldSchema
+
+func (s testFieldSchema) LookupField(name string) (FieldSchema, bool) {
+	fs, ok := s[name]
+	return fs, ok
+}
+
+func TestSubfields(t *testing.T) {
+	if got := Subfields("items"); got != "items" {
+		t.Errorf("got %q, want %q", got, "items")
+	}
+	if got, want := Subfields("items", "id", Subfields("owner", "entity")), Field("items(id,owner(entity))"); got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestNewFieldMask(t *testing.T) {
+	owner := testFieldSchema{"entity": nil, "entityId": nil}
+	s := testFieldSchema{
+		"name":     nil,
+		"owner":    owner,
+		"metadata": AnyFieldSchema,
+	}
+	for _, test := range []struct {
+		fields  []Field
+		want    FieldMask
+		wantErr bool
+	}{
+		{fields: nil, want: nil},
+		{fields: []Field{"name", "owner/entity"}, want: FieldMask{"name", "owner.entity"}},
+		{fields: []Field{"name,owner(entity,entityId)"}, want: FieldMask{"name", "owner.entity", "owner.entityId"}},
+		{fields: []Field{"metadata.color", "owner"}, want: FieldMask{"metadata.color", "owner"}},
+		{fields: []Field{"nme"}, wantErr: true},
+		{fields: []Field{"owner(entty)"}, wantErr: true},
+		{fields: []Field{"name/first"}, wantErr: true},
+		{fields: []Field{"owner(entity"}, wantErr: true},
+		{fields: []Field{"(entity)"}, wantErr: true},
+		{fields: []Field{"name,"}, wantErr: true},
+	} {
+		got, err := NewFieldMask(s, test.fields...)
+		if (err != nil) != test.wantErr {
+			t.Errorf("%q: got error %v, want error: %t", test.fields, err, test.wantErr)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%q: got %q, want %q", test.fields, got, test.want)
+		}
+	}
+	if got, want := (FieldMask{"name", "owner.entity"}).String(), "name,owner.entity"; got != want {
+		t.Errorf("String: got %q, want %q", got, want)
+	}
+}
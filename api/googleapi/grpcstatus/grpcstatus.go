@@ -0,0 +1,234 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package grpcstatus converts between gRPC statuses and googleapi.Errors, so
+// that errors from gRPC and HTTP clients can be handled in the same way.
+package grpcstatus // import "google.golang.org/api/googleapi/grpcstatus"
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+)
+
+// HTTPStatus returns the HTTP status code that corresponds to c, as
+// documented for google.rpc.Code.
+func HTTPStatus(c codes.Code) int {
+	switch c {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		return 499 // Client Closed Request
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		// Unknown, Internal and DataLoss.
+		return http.StatusInternalServerError
+	}
+}
+
+// Code returns the gRPC code that corresponds to the HTTP status code
+// httpStatus. Where several gRPC codes map to the same HTTP status code,
+// the most general one is returned.
+func Code(httpStatus int) codes.Code {
+	switch httpStatus {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed:
+		return codes.FailedPrecondition
+	case http.StatusRequestedRangeNotSatisfiable:
+		return codes.OutOfRange
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case 499:
+		return codes.Canceled
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout:
+		return codes.DeadlineExceeded
+	}
+	switch {
+	case httpStatus >= 200 && httpStatus < 300:
+		return codes.OK
+	case httpStatus >= 400 && httpStatus < 500:
+		return codes.FailedPrecondition
+	case httpStatus >= 500 && httpStatus < 600:
+		return codes.Internal
+	}
+	return codes.Unknown
+}
+
+// FromStatus returns the googleapi.Error equivalent to s, or nil if s is OK.
+// Its Details hold the JSON form of the details of s, as they would appear in
+// an HTTP error response, and the standard google.rpc details among them are
+// also set in the typed fields.
+func FromStatus(s *status.Status) *googleapi.Error {
+	if s.Code() == codes.OK {
+		return nil
+	}
+	e := &googleapi.Error{
+		Code:    HTTPStatus(s.Code()),
+		Message: s.Message(),
+	}
+	for _, any := range s.Proto().GetDetails() {
+		if b, err := protojson.Marshal(any); err == nil {
+			var v interface{}
+			if err := json.Unmarshal(b, &v); err == nil {
+				e.Details = append(e.Details, v)
+			}
+		}
+	}
+	for _, d := range s.Details() {
+		switch d := d.(type) {
+		case *errdetails.ErrorInfo:
+			e.ErrorInfo = &googleapi.ErrorInfo{
+				Reason:   d.GetReason(),
+				Domain:   d.GetDomain(),
+				Metadata: d.GetMetadata(),
+			}
+		case *errdetails.RetryInfo:
+			if delay, err := ptypes.Duration(d.GetRetryDelay()); err == nil {
+				e.RetryInfo = &googleapi.RetryInfo{RetryDelay: delay}
+			}
+		case *errdetails.QuotaFailure:
+			e.QuotaFailure = &googleapi.QuotaFailure{}
+			for _, v := range d.GetViolations() {
+				e.QuotaFailure.Violations = append(e.QuotaFailure.Violations, googleapi.QuotaViolation{
+					Subject:     v.GetSubject(),
+					Description: v.GetDescription(),
+				})
+			}
+		case *errdetails.BadRequest:
+			e.BadRequest = &googleapi.BadRequest{}
+			for _, v := range d.GetFieldViolations() {
+				e.BadRequest.FieldViolations = append(e.BadRequest.FieldViolations, googleapi.FieldViolation{
+					Field:       v.GetField(),
+					Description: v.GetDescription(),
+				})
+			}
+		case *errdetails.Help:
+			e.Help = &googleapi.Help{}
+			for _, l := range d.GetLinks() {
+				e.Help.Links = append(e.Help.Links, googleapi.HelpLink{
+					Description: l.GetDescription(),
+					URL:         l.GetUrl(),
+				})
+			}
+		case *errdetails.LocalizedMessage:
+			e.LocalizedMessage = &googleapi.LocalizedMessage{
+				Locale:  d.GetLocale(),
+				Message: d.GetMessage(),
+			}
+		}
+	}
+	return e
+}
+
+// ToStatus returns the gRPC status equivalent to e. Only the typed details of
+// e are carried over.
+func ToStatus(e *googleapi.Error) *status.Status {
+	s := status.New(Code(e.Code), e.Message)
+	var details []proto.Message
+	if v := e.ErrorInfo; v != nil {
+		details = append(details, &errdetails.ErrorInfo{
+			Reason:   v.Reason,
+			Domain:   v.Domain,
+			Metadata: v.Metadata,
+		})
+	}
+	if v := e.RetryInfo; v != nil {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(v.RetryDelay)})
+	}
+	if v := e.QuotaFailure; v != nil {
+		d := &errdetails.QuotaFailure{}
+		for _, qv := range v.Violations {
+			d.Violations = append(d.Violations, &errdetails.QuotaFailure_Violation{
+				Subject:     qv.Subject,
+				Description: qv.Description,
+			})
+		}
+		details = append(details, d)
+	}
+	if v := e.BadRequest; v != nil {
+		d := &errdetails.BadRequest{}
+		for _, fv := range v.FieldViolations {
+			d.FieldViolations = append(d.FieldViolations, &errdetails.BadRequest_FieldViolation{
+				Field:       fv.Field,
+				Description: fv.Description,
+			})
+		}
+		details = append(details, d)
+	}
+	if v := e.Help; v != nil {
+		d := &errdetails.Help{}
+		for _, l := range v.Links {
+			d.Links = append(d.Links, &errdetails.Help_Link{
+				Description: l.Description,
+				Url:         l.URL,
+			})
+		}
+		details = append(details, d)
+	}
+	if v := e.LocalizedMessage; v != nil {
+		details = append(details, &errdetails.LocalizedMessage{
+			Locale:  v.Locale,
+			Message: v.Message,
+		})
+	}
+	if len(details) == 0 {
+		return s
+	}
+	if sd, err := s.WithDetails(details...); err == nil {
+		return sd
+	}
+	return s
+}
+
+// FromError returns err as a *googleapi.Error if it is one, or converts it if
+// it is a gRPC status error. Otherwise it returns nil and false.
+func FromError(err error) (*googleapi.Error, bool) {
+	if err == nil {
+		return nil, false
+	}
+	if e, ok := err.(*googleapi.Error); ok {
+		return e, true
+	}
+	if s, ok := status.FromError(err); ok {
+		return FromStatus(s), true
+	}
+	return nil, false
+}
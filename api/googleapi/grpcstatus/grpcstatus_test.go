@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package grpcstatus
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/protobuf/ptypes"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCodeRoundTrip(t *testing.T) {
+	// These codes are the ones Code returns for HTTPStatus(c).
+	for _, c := range []codes.Code{
+		codes.OK, codes.Canceled, codes.InvalidArgument, codes.DeadlineExceeded,
+		codes.NotFound, codes.Aborted, codes.PermissionDenied, codes.Unauthenticated,
+		codes.ResourceExhausted, codes.Unimplemented, codes.Internal, codes.Unavailable,
+	} {
+		if got := Code(HTTPStatus(c)); got != c {
+			t.Errorf("Code(HTTPStatus(%v)) = %v", c, got)
+		}
+	}
+	for httpStatus, want := range map[int]codes.Code{
+		http.StatusPreconditionFailed: codes.FailedPrecondition,
+		http.StatusMethodNotAllowed:   codes.FailedPrecondition,
+		http.StatusBadGateway:         codes.Internal,
+		http.StatusNoContent:          codes.OK,
+		http.StatusMovedPermanently:   codes.Unknown,
+	} {
+		if got := Code(httpStatus); got != want {
+			t.Errorf("Code(%d) = %v, want %v", httpStatus, got, want)
+		}
+	}
+}
+
+func TestFromStatus(t *testing.T) {
+	s, err := status.New(codes.ResourceExhausted, "quota exceeded").WithDetails(
+		&errdetails.ErrorInfo{Reason: "RATE_LIMIT_EXCEEDED", Domain: "googleapis.com", Metadata: map[string]string{"service": "pubsub.googleapis.com"}},
+		&errdetails.RetryInfo{RetryDelay: ptypes.DurationProto(1500 * time.Millisecond)},
+		&errdetails.QuotaFailure{Violations: []*errdetails.QuotaFailure_Violation{{Subject: "project:p", Description: "too many"}}},
+		&errdetails.Help{Links: []*errdetails.Help_Link{{Description: "docs", Url: "https://example.com"}}},
+		&errdetails.LocalizedMessage{Locale: "en-US", Message: "slow down"},
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := FromStatus(s)
+	want := &googleapi.Error{
+		Code:    http.StatusTooManyRequests,
+		Message: "quota exceeded",
+		ErrorInfo: &googleapi.ErrorInfo{
+			Reason:   "RATE_LIMIT_EXCEEDED",
+			Domain:   "googleapis.com",
+			Metadata: map[string]string{"service": "pubsub.googleapis.com"},
+		},
+		RetryInfo:        &googleapi.RetryInfo{RetryDelay: 1500 * time.Millisecond},
+		QuotaFailure:     &googleapi.QuotaFailure{Violations: []googleapi.QuotaViolation{{Subject: "project:p", Description: "too many"}}},
+		Help:             &googleapi.Help{Links: []googleapi.HelpLink{{Description: "docs", URL: "https://example.com"}}},
+		LocalizedMessage: &googleapi.LocalizedMessage{Locale: "en-US", Message: "slow down"},
+	}
+	if diff := cmp.Diff(want, got, cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "Details" }, cmp.Ignore())); diff != "" {
+		t.Errorf("FromStatus mismatch (-want +got):\n%s", diff)
+	}
+	if len(got.Details) != 5 {
+		t.Fatalf("got %d details, want 5", len(got.Details))
+	}
+	if d, ok := got.Details[1].(map[string]interface{}); !ok || d["@type"] != "type.googleapis.com/google.rpc.RetryInfo" || d["retryDelay"] != "1.500s" {
+		t.Errorf("got detail %#v, want the JSON form of RetryInfo", got.Details[1])
+	}
+	if got.Reason() != "RATE_LIMIT_EXCEEDED" || got.Domain() != "googleapis.com" || got.RetryDelay() != 1500*time.Millisecond {
+		t.Errorf("got Reason %q, Domain %q, RetryDelay %v", got.Reason(), got.Domain(), got.RetryDelay())
+	}
+
+	// The typed details survive the trip back.
+	back := FromStatus(ToStatus(got))
+	if diff := cmp.Diff(want, back, cmp.FilterPath(func(p cmp.Path) bool { return p.String() == "Details" }, cmp.Ignore())); diff != "" {
+		t.Errorf("FromStatus(ToStatus) mismatch (-want +got):\n%s", diff)
+	}
+
+	if FromStatus(status.New(codes.OK, "")) != nil {
+		t.Error("FromStatus(OK) != nil")
+	}
+}
+
+func TestToStatus(t *testing.T) {
+	s := ToStatus(&googleapi.Error{
+		Code:       http.StatusBadRequest,
+		Message:    "bad",
+		BadRequest: &googleapi.BadRequest{FieldViolations: []googleapi.FieldViolation{{Field: "name", Description: "empty"}}},
+	})
+	if s.Code() != codes.InvalidArgument || s.Message() != "bad" {
+		t.Errorf("got %v, want InvalidArgument: bad", s)
+	}
+	details := s.Details()
+	if len(details) != 1 {
+		t.Fatalf("got %d details, want 1", len(details))
+	}
+	br, ok := details[0].(*errdetails.BadRequest)
+	if !ok || len(br.FieldViolations) != 1 || br.FieldViolations[0].Field != "name" {
+		t.Errorf("got detail %v, want BadRequest for field name", details[0])
+	}
+}
+
+func TestFromError(t *testing.T) {
+	apiErr := &googleapi.Error{Code: http.StatusNotFound}
+	for _, tc := range []struct {
+		err      error
+		wantCode int
+		wantOK   bool
+	}{
+		{err: nil},
+		{err: errors.New("other")},
+		{err: apiErr, wantCode: http.StatusNotFound, wantOK: true},
+		{err: status.Error(codes.NotFound, "missing"), wantCode: http.StatusNotFound, wantOK: true},
+	} {
+		got, ok := FromError(tc.err)
+		if ok != tc.wantOK {
+			t.Errorf("FromError(%v): got ok %t, want %t", tc.err, ok, tc.wantOK)
+			continue
+		}
+		if ok && got.Code != tc.wantCode {
+			t.Errorf("FromError(%v): got code %d, want %d", tc.err, got.Code, tc.wantCode)
+		}
+	}
+}
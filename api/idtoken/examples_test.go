@@ -9,6 +9,7 @@ import (
 	"net/http"
 
 	"google.golang.org/api/idtoken"
+	"google.golang.org/api/option"
 )
 
 func ExampleNewTokenSource_setAuthorizationHeader() {
@@ -28,3 +29,21 @@ func ExampleNewTokenSource_setAuthorizationHeader() {
 	}
 	token.SetAuthHeader(req)
 }
+
+func ExampleNewTokenSource_impersonateCredentials() {
+	ctx := context.Background()
+	audience := "http://example.com"
+	// The default credentials must have roles/iam.serviceAccountTokenCreator
+	// on the target service account.
+	ts, err := idtoken.NewTokenSource(ctx, audience,
+		option.ImpersonateCredentials("target@project.iam.gserviceaccount.com"),
+		idtoken.WithIncludeEmail())
+	if err != nil {
+		// TODO: Handle error.
+	}
+	token, err := ts.Token()
+	if err != nil {
+		// TODO: Handle error.
+	}
+	_ = token
+}
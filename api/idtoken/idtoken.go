@@ -15,6 +15,7 @@ import (
 	"golang.org/x/oauth2/google"
 
 	"google.golang.org/api/internal"
+	"google.golang.org/api/internal/impersonate"
 	"google.golang.org/api/option"
 	"google.golang.org/api/option/internaloption"
 	htransport "google.golang.org/api/transport/http"
@@ -34,6 +35,9 @@ func NewClient(ctx context.Context, audience string, opts ...ClientOption) (*htt
 	for _, opt := range opts {
 		opt.Apply(&ds)
 	}
+	if ds.ImpersonationConfig != nil {
+		ds.SetImpersonationScopes()
+	}
 	if err := ds.Validate(); err != nil {
 		return nil, err
 	}
@@ -52,8 +56,9 @@ func NewClient(ctx context.Context, audience string, opts ...ClientOption) (*htt
 		return nil, err
 	}
 	// Skip DialSettings validation so added TokenSource will not conflict with user
-	// provided credentials.
-	opts = append(opts, option.WithTokenSource(ts), internaloption.SkipDialSettingsValidation())
+	// provided credentials. Impersonation has already been applied by the
+	// TokenSource, so it must not be applied to the transport again.
+	opts = append(opts, option.WithTokenSource(ts), internaloption.SkipDialSettingsValidation(), withoutImpersonation{})
 	t, err := htransport.NewTransport(ctx, http.DefaultTransport, opts...)
 	if err != nil {
 		return nil, err
@@ -72,19 +77,22 @@ func NewTokenSource(ctx context.Context, audience string, opts ...ClientOption)
 	for _, opt := range opts {
 		opt.Apply(&ds)
 	}
+	if ds.ImpersonationConfig != nil {
+		ds.SetImpersonationScopes()
+	}
 	if err := ds.Validate(); err != nil {
 		return nil, err
 	}
 	if ds.TokenSource != nil {
 		return nil, fmt.Errorf("idtoken: option.WithTokenSource not supported")
 	}
-	if ds.ImpersonationConfig != nil {
-		return nil, fmt.Errorf("idtoken: option.WithImpersonatedCredentials not supported")
-	}
 	return newTokenSource(ctx, audience, &ds)
 }
 
 func newTokenSource(ctx context.Context, audience string, ds *internal.DialSettings) (oauth2.TokenSource, error) {
+	if ds.ImpersonationConfig != nil {
+		return impersonatedTokenSource(ctx, audience, ds)
+	}
 	creds, err := internal.Creds(ctx, ds)
 	if err != nil {
 		return nil, err
@@ -100,6 +108,28 @@ func newTokenSource(ctx context.Context, audience string, ds *internal.DialSetti
 	return nil, fmt.Errorf("idtoken: couldn't find any credentials")
 }
 
+// impersonatedTokenSource mints ID tokens for the impersonation target through
+// the IAM Credentials API, using the base credentials from ds to authorize the
+// calls. Any credential type supported by internal.Creds may be used as the
+// base credential.
+func impersonatedTokenSource(ctx context.Context, audience string, ds *internal.DialSettings) (oauth2.TokenSource, error) {
+	if ds.CustomClaims != nil {
+		return nil, fmt.Errorf("idtoken: WithCustomClaims can't be used with option.ImpersonateCredentials")
+	}
+	baseDS := *ds
+	baseDS.ImpersonationConfig = nil
+	creds, err := internal.Creds(ctx, &baseDS)
+	if err != nil {
+		return nil, err
+	}
+	return impersonate.IDTokenSource(ctx, creds.TokenSource, &impersonate.IDTokenConfig{
+		Target:       ds.ImpersonationConfig.Target,
+		Audience:     audience,
+		IncludeEmail: ds.IncludeEmail,
+		Delegates:    ds.ImpersonationConfig.Delegates,
+	})
+}
+
 func tokenSourceFromBytes(ctx context.Context, data []byte, audience string, ds *internal.DialSettings) (oauth2.TokenSource, error) {
 	if err := isServiceAccount(data); err != nil {
 		return nil, err
@@ -137,7 +167,7 @@ func isServiceAccount(data []byte) error {
 		return err
 	}
 	if f.Type != "service_account" {
-		return fmt.Errorf("idtoken: credential must be service_account, found %q", f.Type)
+		return fmt.Errorf("idtoken: credential must be service_account, found %q; use option.ImpersonateCredentials to mint ID tokens with other credential types", f.Type)
 	}
 	return nil
 }
@@ -153,6 +183,25 @@ func (w withCustomClaims) Apply(o *internal.DialSettings) {
 	o.CustomClaims = w
 }
 
+// WithIncludeEmail optionally specifies that the email and email_verified
+// claims should be included in ID tokens minted for an impersonated service
+// account. It only has an effect when used with option.ImpersonateCredentials.
+func WithIncludeEmail() ClientOption {
+	return withIncludeEmail{}
+}
+
+type withIncludeEmail struct{}
+
+func (w withIncludeEmail) Apply(o *internal.DialSettings) {
+	o.IncludeEmail = true
+}
+
+type withoutImpersonation struct{}
+
+func (w withoutImpersonation) Apply(o *internal.DialSettings) {
+	o.ImpersonationConfig = nil
+}
+
 // WithCredentialsFile returns a ClientOption that authenticates
 // API calls with the given service account or refresh token JSON
 // credentials file.
@@ -0,0 +1,134 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package idtoken
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+)
+
+const authorizedUserJSON = `{
+  "type": "authorized_user",
+  "client_id": "client-id",
+  "client_secret": "client-secret",
+  "refresh_token": "refresh-token"
+}`
+
+// fakeIAMServer serves the OAuth2 token endpoint and the IAM Credentials
+// generateIdToken method.
+type fakeIAMServer struct {
+	t   *testing.T
+	exp time.Time
+
+	gotPath string
+	gotAuth string
+	gotReq  map[string]interface{}
+}
+
+func (f *fakeIAMServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.URL.Path == "/token" {
+		fmt.Fprint(w, `{"access_token": "base-token", "token_type": "Bearer", "expires_in": 3600}`)
+		return
+	}
+	f.gotPath = r.URL.Path
+	f.gotAuth = r.Header.Get("Authorization")
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		f.t.Errorf("ReadAll: %v", err)
+	}
+	if err := json.Unmarshal(b, &f.gotReq); err != nil {
+		f.t.Errorf("Unmarshal: %v", err)
+	}
+	payload, _ := json.Marshal(map[string]interface{}{
+		"aud": f.gotReq["audience"],
+		"exp": f.exp.Unix(),
+	})
+	token := "header." + base64.RawURLEncoding.EncodeToString(payload) + ".signature"
+	fmt.Fprintf(w, `{"token": %q}`, token)
+}
+
+// rewriteTransport sends every request to the test server regardless of host.
+type rewriteTransport struct {
+	u *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := *r
+	u := *r.URL
+	u.Scheme = t.u.Scheme
+	u.Host = t.u.Host
+	r2.URL = &u
+	return http.DefaultTransport.RoundTrip(&r2)
+}
+
+func TestNewTokenSource_Impersonate(t *testing.T) {
+	exp := time.Now().Add(time.Hour).Truncate(time.Second)
+	fake := &fakeIAMServer{t: t, exp: exp}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: rewriteTransport{u}})
+
+	ts, err := NewTokenSource(ctx, testAudience,
+		option.WithCredentialsJSON([]byte(authorizedUserJSON)),
+		option.ImpersonateCredentials("sa@example.iam.gserviceaccount.com", "delegate@example.iam.gserviceaccount.com"),
+		WithIncludeEmail(),
+	)
+	if err != nil {
+		t.Fatalf("NewTokenSource: %v", err)
+	}
+	if fake.gotPath != "" {
+		t.Errorf("NewTokenSource called %q; want no calls until Token", fake.gotPath)
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if !tok.Expiry.Equal(exp) {
+		t.Errorf("got expiry %v, want %v", tok.Expiry, exp)
+	}
+	if !strings.HasPrefix(tok.AccessToken, "header.") {
+		t.Errorf("got token %q, want ID token from IAM", tok.AccessToken)
+	}
+	if want := "/v1/projects/-/serviceAccounts/sa@example.iam.gserviceaccount.com:generateIdToken"; fake.gotPath != want {
+		t.Errorf("got path %q, want %q", fake.gotPath, want)
+	}
+	if want := "Bearer base-token"; fake.gotAuth != want {
+		t.Errorf("got Authorization %q, want %q", fake.gotAuth, want)
+	}
+	if got := fake.gotReq["audience"]; got != testAudience {
+		t.Errorf("got audience %v, want %q", got, testAudience)
+	}
+	if got := fake.gotReq["includeEmail"]; got != true {
+		t.Errorf("got includeEmail %v, want true", got)
+	}
+	delegates, _ := fake.gotReq["delegates"].([]interface{})
+	if len(delegates) != 1 || delegates[0] != "projects/-/serviceAccounts/delegate@example.iam.gserviceaccount.com" {
+		t.Errorf("got delegates %v", fake.gotReq["delegates"])
+	}
+}
+
+func TestNewTokenSource_AuthorizedUserWithoutImpersonation(t *testing.T) {
+	_, err := NewTokenSource(context.Background(), testAudience, option.WithCredentialsJSON([]byte(authorizedUserJSON)))
+	if err == nil {
+		t.Fatal("got nil error, want error for authorized_user credentials")
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package impersonate is used to impersonate Google service accounts through
+// the IAM Credentials API. It can mint access tokens for a service account and
+// sign data with the service account's system-managed keys, so no private key
+// needs to be downloaded.
+//
+// The base credentials used to call the IAM Credentials API are found from the
+// provided ClientOptions, or from Application Default Credentials if none are
+// provided. They must have the roles/iam.serviceAccountTokenCreator role on
+// the target service account, or on the first service account in Delegates.
+package impersonate
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"golang.org/x/oauth2"
+
+	"google.golang.org/api/internal"
+	"google.golang.org/api/internal/impersonate"
+	"google.golang.org/api/option"
+)
+
+// CredentialsConfig configures the service account to impersonate.
+type CredentialsConfig struct {
+	// TargetPrincipal is the email address of the service account to
+	// impersonate. Required.
+	TargetPrincipal string
+	// Scopes the impersonated access token should have. Required for
+	// CredentialsTokenSource, ignored by SignBlob and SignJWT.
+	Scopes []string
+	// Delegates are the service accounts in a delegation chain. Each service
+	// account must be granted roles/iam.serviceAccountTokenCreator on the next
+	// service account in the chain. Optional.
+	Delegates []string
+	// Lifetime is the amount of time until the impersonated token expires.
+	// Values over one hour require the organization policy
+	// constraints/iam.allowServiceAccountCredentialLifetimeExtension. Defaults
+	// to one hour. Optional.
+	Lifetime time.Duration
+	// EarlyRefresh is how long before the impersonated token expires that a
+	// new token is fetched. Defaults to 10 seconds. Optional.
+	EarlyRefresh time.Duration
+}
+
+func (c CredentialsConfig) config() *impersonate.Config {
+	return &impersonate.Config{
+		Target:       c.TargetPrincipal,
+		Scopes:       c.Scopes,
+		Delegates:    c.Delegates,
+		Lifetime:     c.Lifetime,
+		EarlyRefresh: c.EarlyRefresh,
+	}
+}
+
+// CredentialsTokenSource returns a TokenSource that provides access tokens for
+// the service account in config. The tokens are cached and refreshed
+// config.EarlyRefresh before they expire.
+func CredentialsTokenSource(ctx context.Context, config CredentialsConfig, opts ...option.ClientOption) (oauth2.TokenSource, error) {
+	if config.TargetPrincipal == "" {
+		return nil, errors.New("impersonate: a target service account must be provided")
+	}
+	ts, err := baseTokenSource(ctx, opts)
+	if err != nil {
+		return nil, err
+	}
+	return impersonate.TokenSource(ctx, ts, config.config())
+}
+
+// SignBlob signs payload with a system-managed private key of the service
+// account in config. It returns the ID of the key used and the signature.
+//
+// SignBlob can be used as the SignBytes function when generating Cloud Storage
+// signed URLs without access to a private key.
+func SignBlob(ctx context.Context, config CredentialsConfig, payload []byte, opts ...option.ClientOption) (keyID string, signature []byte, err error) {
+	ts, err := baseTokenSource(ctx, opts)
+	if err != nil {
+		return "", nil, err
+	}
+	return impersonate.SignBlob(ctx, ts, config.config(), payload)
+}
+
+// SignJWT signs the JSON-encoded JWT claim set in payload with a
+// system-managed private key of the service account in config. It returns the
+// ID of the key used and the signed JWT.
+func SignJWT(ctx context.Context, config CredentialsConfig, payload string, opts ...option.ClientOption) (keyID string, jwt string, err error) {
+	ts, err := baseTokenSource(ctx, opts)
+	if err != nil {
+		return "", "", err
+	}
+	return impersonate.SignJWT(ctx, ts, config.config(), payload)
+}
+
+// baseTokenSource returns the TokenSource used to call the IAM Credentials
+// API.
+func baseTokenSource(ctx context.Context, opts []option.ClientOption) (oauth2.TokenSource, error) {
+	var ds internal.DialSettings
+	for _, opt := range opts {
+		opt.Apply(&ds)
+	}
+	if ds.ImpersonationConfig != nil {
+		return nil, errors.New("impersonate: option.ImpersonateCredentials is not supported; use CredentialsConfig.Delegates")
+	}
+	ds.SetImpersonationScopes()
+	if err := ds.Validate(); err != nil {
+		return nil, err
+	}
+	creds, err := internal.Creds(ctx, &ds)
+	if err != nil {
+		return nil, err
+	}
+	return creds.TokenSource, nil
+}
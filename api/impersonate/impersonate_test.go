@@ -0,0 +1,153 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package impersonate
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/option"
+)
+
+type fakeIAMServer struct {
+	t       *testing.T
+	gotPath string
+	gotAuth string
+	gotReq  map[string]interface{}
+}
+
+func (f *fakeIAMServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	f.gotPath = r.URL.Path
+	f.gotAuth = r.Header.Get("Authorization")
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		f.t.Errorf("ReadAll: %v", err)
+	}
+	f.gotReq = nil
+	if err := json.Unmarshal(b, &f.gotReq); err != nil {
+		f.t.Errorf("Unmarshal: %v", err)
+	}
+	switch {
+	case strings.HasSuffix(r.URL.Path, ":generateAccessToken"):
+		fmt.Fprintf(w, `{"accessToken": "impersonated", "expireTime": %q}`, time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	default:
+		fmt.Fprintf(w, `{"keyId": "key", "signedBlob": %q}`, base64.StdEncoding.EncodeToString([]byte("signature")))
+	}
+}
+
+// rewriteTransport sends every request to the test server regardless of host.
+type rewriteTransport struct {
+	u *url.URL
+}
+
+func (t rewriteTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	r2 := *r
+	u := *r.URL
+	u.Scheme = t.u.Scheme
+	u.Host = t.u.Host
+	r2.URL = &u
+	return http.DefaultTransport.RoundTrip(&r2)
+}
+
+func newTestContext(t *testing.T) (context.Context, *fakeIAMServer, func()) {
+	fake := &fakeIAMServer{t: t}
+	server := httptest.NewServer(fake)
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.WithValue(context.Background(), oauth2.HTTPClient, &http.Client{Transport: rewriteTransport{u}})
+	return ctx, fake, server.Close
+}
+
+func TestCredentialsTokenSource(t *testing.T) {
+	ctx, fake, done := newTestContext(t)
+	defer done()
+
+	ts, err := CredentialsTokenSource(ctx, CredentialsConfig{
+		TargetPrincipal: "sa@example.iam.gserviceaccount.com",
+		Scopes:          []string{"scope"},
+		Lifetime:        30 * time.Minute,
+		EarlyRefresh:    time.Minute,
+	}, option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "base"})))
+	if err != nil {
+		t.Fatalf("CredentialsTokenSource: %v", err)
+	}
+	if fake.gotPath != "" {
+		t.Errorf("CredentialsTokenSource called %q; want no calls until Token", fake.gotPath)
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatalf("Token: %v", err)
+	}
+	if tok.AccessToken != "impersonated" {
+		t.Errorf("got token %q, want %q", tok.AccessToken, "impersonated")
+	}
+	if want := "/v1/projects/-/serviceAccounts/sa@example.iam.gserviceaccount.com:generateAccessToken"; fake.gotPath != want {
+		t.Errorf("got path %q, want %q", fake.gotPath, want)
+	}
+	if want := "Bearer base"; fake.gotAuth != want {
+		t.Errorf("got Authorization %q, want %q", fake.gotAuth, want)
+	}
+	if got, want := fake.gotReq["lifetime"], "1800s"; got != want {
+		t.Errorf("got lifetime %v, want %q", got, want)
+	}
+}
+
+func TestCredentialsTokenSource_Errors(t *testing.T) {
+	ctx := context.Background()
+	base := option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "base"}))
+	for _, test := range []struct {
+		name   string
+		config CredentialsConfig
+		opts   []option.ClientOption
+	}{
+		{"no target", CredentialsConfig{Scopes: []string{"scope"}}, []option.ClientOption{base}},
+		{"no scopes", CredentialsConfig{TargetPrincipal: "sa"}, []option.ClientOption{base}},
+		{"impersonate option", CredentialsConfig{TargetPrincipal: "sa", Scopes: []string{"scope"}}, []option.ClientOption{base, option.ImpersonateCredentials("other")}},
+		{"early refresh too long", CredentialsConfig{TargetPrincipal: "sa", Scopes: []string{"scope"}, Lifetime: time.Minute, EarlyRefresh: time.Hour}, []option.ClientOption{base}},
+	} {
+		if _, err := CredentialsTokenSource(ctx, test.config, test.opts...); err == nil {
+			t.Errorf("%s: got nil error, want error", test.name)
+		}
+	}
+}
+
+func TestSignBlob(t *testing.T) {
+	ctx, fake, done := newTestContext(t)
+	defer done()
+
+	keyID, sig, err := SignBlob(ctx, CredentialsConfig{
+		TargetPrincipal: "sa@example.iam.gserviceaccount.com",
+		Delegates:       []string{"delegate@example.iam.gserviceaccount.com"},
+	}, []byte("payload"), option.WithTokenSource(oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "base"})))
+	if err != nil {
+		t.Fatalf("SignBlob: %v", err)
+	}
+	if keyID != "key" || string(sig) != "signature" {
+		t.Errorf("got (%q, %q), want (%q, %q)", keyID, sig, "key", "signature")
+	}
+	if want := "/v1/projects/-/serviceAccounts/sa@example.iam.gserviceaccount.com:signBlob"; fake.gotPath != want {
+		t.Errorf("got path %q, want %q", fake.gotPath, want)
+	}
+	if got, want := fake.gotReq["payload"], base64.StdEncoding.EncodeToString([]byte("payload")); got != want {
+		t.Errorf("got payload %v, want %q", got, want)
+	}
+	delegates, _ := fake.gotReq["delegates"].([]interface{})
+	if len(delegates) != 1 || delegates[0] != "projects/-/serviceAccounts/delegate@example.iam.gserviceaccount.com" {
+		t.Errorf("got delegates %v", fake.gotReq["delegates"])
+	}
+}
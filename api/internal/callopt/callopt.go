@@ -0,0 +1,27 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package callopt holds the names of the call options in package googleapi
+// that are read by the client and are not sent to the server. Such options
+// are kept with the URL parameters of a call, under names that begin with
+// Prefix, and gensupport.URLParams.Encode leaves them out.
+package callopt
+
+import "strings"
+
+// Prefix begins the name of every call option that is not sent.
+const Prefix = "$"
+
+// Names of the call options that are not sent.
+const (
+	SkipValidation = Prefix + "skipValidation" // googleapi.SkipValidation
+	RawDownload    = Prefix + "rawDownload"    // googleapi.RawDownload
+	RetryBuffer    = Prefix + "retryBuffer"    // googleapi.RetryBuffer
+)
+
+// IsClientOnly reports whether the URL parameter named key holds a call
+// option that must not be sent.
+func IsClientOnly(key string) bool {
+	return strings.HasPrefix(key, Prefix)
+}
@@ -9,9 +9,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"os"
 
 	"golang.org/x/oauth2"
+	"google.golang.org/api/internal/externalaccount"
 	"google.golang.org/api/internal/impersonate"
+	"google.golang.org/api/internal/installedapp"
 
 	"golang.org/x/oauth2/google"
 )
@@ -34,24 +37,37 @@ func baseCreds(ctx context.Context, ds *DialSettings) (*google.Credentials, erro
 		return ds.Credentials, nil
 	}
 	if ds.CredentialsJSON != nil {
-		return credentialsFromJSON(ctx, ds.CredentialsJSON, ds.Endpoint, ds.Scopes, ds.Audiences)
+		return credentialsFromJSON(ctx, ds.CredentialsJSON, ds)
+	}
+	if ds.InstalledAppConfig != nil {
+		ts, err := installedapp.TokenSource(ctx, ds.InstalledAppConfig, ds.Scopes)
+		if err != nil {
+			return nil, err
+		}
+		return &google.Credentials{TokenSource: ts}, nil
 	}
 	if ds.CredentialsFile != "" {
 		data, err := ioutil.ReadFile(ds.CredentialsFile)
 		if err != nil {
 			return nil, fmt.Errorf("cannot read credentials file: %v", err)
 		}
-		return credentialsFromJSON(ctx, data, ds.Endpoint, ds.Scopes, ds.Audiences)
+		return credentialsFromJSON(ctx, data, ds)
 	}
 	if ds.TokenSource != nil {
 		return &google.Credentials{TokenSource: ds.TokenSource}, nil
 	}
+	// google.FindDefaultCredentials doesn't know external accounts.
+	if f := os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"); f != "" {
+		if data, err := ioutil.ReadFile(f); err == nil && credentialsType(data) == externalaccount.Type {
+			return credentialsFromJSON(ctx, data, ds)
+		}
+	}
 	cred, err := google.FindDefaultCredentials(ctx, ds.Scopes...)
 	if err != nil {
 		return nil, err
 	}
 	if len(cred.JSON) > 0 {
-		return credentialsFromJSON(ctx, cred.JSON, ds.Endpoint, ds.Scopes, ds.Audiences)
+		return credentialsFromJSON(ctx, cred.JSON, ds)
 	}
 	// For GAE and GCE, the JSON is empty so return the default credentials directly.
 	return cred, nil
@@ -65,13 +81,18 @@ const (
 // credentialsFromJSON returns a google.Credentials based on the input.
 //
 // - If the JSON is a service account and no scopes provided, returns self-signed JWT auth flow
+// - If the JSON is a service account, scopes are provided and JWTs with scopes are enabled, returns self-signed JWT auth flow with the scopes
+// - If the JSON is an external account, returns the token exchange flow
 // - Otherwise, returns OAuth 2.0 flow.
-func credentialsFromJSON(ctx context.Context, data []byte, endpoint string, scopes []string, audiences []string) (*google.Credentials, error) {
-	cred, err := google.CredentialsFromJSON(ctx, data, scopes...)
+func credentialsFromJSON(ctx context.Context, data []byte, ds *DialSettings) (*google.Credentials, error) {
+	if credentialsType(data) == externalaccount.Type {
+		return externalAccountCredentials(ctx, data, ds)
+	}
+	cred, err := google.CredentialsFromJSON(ctx, data, ds.Scopes...)
 	if err != nil {
 		return nil, err
 	}
-	if len(data) > 0 && len(scopes) == 0 {
+	if len(data) > 0 && (len(ds.Scopes) == 0 || ds.EnableJWTWithScope) {
 		var f struct {
 			Type string `json:"type"`
 			// The rest JSON fields are omitted because they are not used.
@@ -80,27 +101,60 @@ func credentialsFromJSON(ctx context.Context, data []byte, endpoint string, scop
 			return nil, err
 		}
 		if f.Type == serviceAccountKey {
-			ts, err := selfSignedJWTTokenSource(data, endpoint, audiences)
+			ts, err := selfSignedJWTTokenSource(data, ds)
 			if err != nil {
 				return nil, err
 			}
-			cred.TokenSource = ts
+			if ts != nil {
+				cred.TokenSource = ts
+			}
 		}
 	}
 	return cred, err
 }
 
-func selfSignedJWTTokenSource(data []byte, endpoint string, audiences []string) (oauth2.TokenSource, error) {
-	// Use the API endpoint as the default audience
-	audience := endpoint
-	if len(audiences) > 0 {
-		// TODO(shinfan): Update golang oauth to support multiple audiences.
-		if len(audiences) > 1 {
-			return nil, fmt.Errorf("multiple audiences support is not implemented")
-		}
-		audience = audiences[0]
+// selfSignedJWTTokenSource returns a source of self-signed JWTs for the
+// service account key in data, or nil if the JWTs would have neither an
+// audience nor scopes. Scopes are used in place of an audience if they are
+// enabled and there are no audiences from option.WithAudiences.
+func selfSignedJWTTokenSource(data []byte, ds *DialSettings) (oauth2.TokenSource, error) {
+	var audiences, scopes []string
+	if len(ds.Scopes) > 0 && len(ds.Audiences) == 0 {
+		scopes = ds.Scopes
+	} else if audiences = jwtAudiences(ds); len(audiences) == 0 {
+		return nil, nil
+	}
+	ts, err := newSelfSignedJWTSource(data, audiences, scopes)
+	if err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+// credentialsType returns the type of the credentials JSON data, or "" if it
+// can't be told.
+func credentialsType(data []byte) string {
+	var f struct {
+		Type string `json:"type"`
+	}
+	if err := json.Unmarshal(data, &f); err != nil {
+		return ""
+	}
+	return f.Type
+}
+
+// externalAccountCredentials returns the credentials of the external_account
+// JSON data.
+func externalAccountCredentials(ctx context.Context, data []byte, ds *DialSettings) (*google.Credentials, error) {
+	c, err := externalaccount.ParseConfig(data)
+	if err != nil {
+		return nil, err
+	}
+	ts, err := externalaccount.TokenSource(ctx, c, ds.Scopes)
+	if err != nil {
+		return nil, err
 	}
-	return google.JWTAccessTokenSourceFromJSON(data, audience)
+	return &google.Credentials{TokenSource: ts, JSON: data}, nil
 }
 
 // QuotaProjectFromCreds returns the quota project from the JSON blob in the provided credentials.
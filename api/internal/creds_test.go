@@ -6,6 +6,12 @@ package internal
 
 import (
 	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -119,7 +125,7 @@ const validServiceAccountJSON = `{
 func TestQuotaProjectFromCreds(t *testing.T) {
 	ctx := context.Background()
 
-	cred, err := credentialsFromJSON(ctx, []byte(validServiceAccountJSON), "foo.googleapis.com", nil, nil)
+	cred, err := credentialsFromJSON(ctx, []byte(validServiceAccountJSON), &DialSettings{Endpoint: "foo.googleapis.com"})
 	if err != nil {
 		t.Fatalf("got %v, wanted no error", err)
 	}
@@ -133,7 +139,7 @@ func TestQuotaProjectFromCreds(t *testing.T) {
 	"quota_project_id": "foobar"
 }`)
 
-	cred, err = credentialsFromJSON(ctx, []byte(quotaProjectJSON), "foo.googleapis.com", nil, nil)
+	cred, err = credentialsFromJSON(ctx, []byte(quotaProjectJSON), &DialSettings{Endpoint: "foo.googleapis.com"})
 	if err != nil {
 		t.Fatalf("got %v, wanted no error", err)
 	}
@@ -141,3 +147,58 @@ func TestQuotaProjectFromCreds(t *testing.T) {
 		t.Errorf("QuotaProjectFromCreds(quotaProjectJSON): want %q, got %q", want, got)
 	}
 }
+
+func TestExternalAccount(t *testing.T) {
+	ctx := context.Background()
+	sts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got := r.FormValue("subject_token"); got != "subject-token" {
+			t.Errorf("got subject token %q, want subject-token", got)
+		}
+		fmt.Fprint(w, `{"access_token": "sts-token", "token_type": "Bearer", "expires_in": 3600}`)
+	}))
+	defer sts.Close()
+	dir, err := ioutil.TempDir("", "creds")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	tokenFile := filepath.Join(dir, "token")
+	if err := ioutil.WriteFile(tokenFile, []byte("subject-token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	data := []byte(fmt.Sprintf(`{
+	"type": "external_account",
+	"audience": "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider",
+	"subject_token_type": "urn:ietf:params:oauth:token-type:jwt",
+	"token_url": %q,
+	"credential_source": {"file": %q},
+	"quota_project_id": "foobar"
+}`, sts.URL+"/v1/token", tokenFile))
+	credsFile := filepath.Join(dir, "creds.json")
+	if err := ioutil.WriteFile(credsFile, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", os.Getenv("GOOGLE_APPLICATION_CREDENTIALS"))
+	os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", credsFile)
+
+	for _, ds := range []*DialSettings{
+		{CredentialsJSON: data},
+		{CredentialsFile: credsFile},
+		{}, // Application default credentials.
+	} {
+		cred, err := Creds(ctx, ds)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tok, err := cred.TokenSource.Token()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tok.AccessToken != "sts-token" {
+			t.Errorf("got token %q, want sts-token", tok.AccessToken)
+		}
+		if got := QuotaProjectFromCreds(cred); got != "foobar" {
+			t.Errorf("got quota project %q, want foobar", got)
+		}
+	}
+}
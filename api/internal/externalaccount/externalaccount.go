@@ -0,0 +1,216 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package externalaccount provides credentials for workloads that run outside
+// of Google Cloud, configured by external_account JSON files: a subject token
+// of the workload's own identity provider is exchanged for a Google access
+// token through the Security Token Service, optionally followed by the
+// impersonation of a service account.
+package externalaccount
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/internal/impersonate"
+)
+
+// Type is the type of external account JSON files.
+const Type = "external_account"
+
+const (
+	// defaultTokenURL is the token endpoint of the Security Token Service,
+	// the one of google.golang.org/api/sts/v1.
+	defaultTokenURL = "https://sts.googleapis.com/v1/token"
+
+	// cloudPlatformScope is requested from the Security Token Service when a
+	// service account is impersonated, and when no scopes are given.
+	cloudPlatformScope = "https://www.googleapis.com/auth/cloud-platform"
+
+	tokenExchangeGrantType = "urn:ietf:params:oauth:grant-type:token-exchange"
+	accessTokenType        = "urn:ietf:params:oauth:token-type:access_token"
+)
+
+// Config is the content of an external_account JSON file.
+type Config struct {
+	// Audience is the resource name of the workload identity pool provider,
+	// like "//iam.googleapis.com/projects/$NUMBER/locations/global/
+	// workloadIdentityPools/$POOL/providers/$PROVIDER". Required.
+	Audience string `json:"audience"`
+	// SubjectTokenType is the type of the subject token, like
+	// "urn:ietf:params:oauth:token-type:jwt". Required.
+	SubjectTokenType string `json:"subject_token_type"`
+	// TokenURL is the token endpoint of the Security Token Service.
+	// Defaults to https://sts.googleapis.com/v1/token. Optional.
+	TokenURL string `json:"token_url"`
+	// ServiceAccountImpersonationURL is the generateAccessToken URL of the
+	// service account to impersonate, if any. Optional.
+	ServiceAccountImpersonationURL string `json:"service_account_impersonation_url"`
+	// ClientID and ClientSecret authenticate the client to the Security
+	// Token Service, if it requires it. Optional.
+	ClientID     string `json:"client_id"`
+	ClientSecret string `json:"client_secret"`
+	// CredentialSource tells where the subject token comes from. Required.
+	CredentialSource CredentialSource `json:"credential_source"`
+	// QuotaProjectID is the project billed for the calls. Optional.
+	QuotaProjectID string `json:"quota_project_id"`
+}
+
+// ParseConfig parses and checks the external_account JSON data.
+func ParseConfig(data []byte) (*Config, error) {
+	var c struct {
+		Type string `json:"type"`
+		Config
+	}
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("externalaccount: %v", err)
+	}
+	if c.Type != Type {
+		return nil, fmt.Errorf("externalaccount: got type %q, want %q", c.Type, Type)
+	}
+	if c.Audience == "" {
+		return nil, errors.New("externalaccount: missing audience")
+	}
+	if c.SubjectTokenType == "" {
+		return nil, errors.New("externalaccount: missing subject_token_type")
+	}
+	if err := c.CredentialSource.check(); err != nil {
+		return nil, err
+	}
+	if c.ServiceAccountImpersonationURL != "" {
+		if _, _, err := parseImpersonationURL(c.ServiceAccountImpersonationURL); err != nil {
+			return nil, err
+		}
+	}
+	return &c.Config, nil
+}
+
+// TokenSource returns a TokenSource of access tokens with the scopes for the
+// external account c. The context is used to get subject tokens and to call
+// the Security Token Service and IAM Credentials API.
+func TokenSource(ctx context.Context, c *Config, scopes []string) (oauth2.TokenSource, error) {
+	if len(scopes) == 0 {
+		scopes = []string{cloudPlatformScope}
+	}
+	sts := &stsTokenSource{ctx: ctx, c: c, scopes: scopes}
+	if c.ServiceAccountImpersonationURL == "" {
+		return oauth2.ReuseTokenSource(nil, sts), nil
+	}
+	// The federated token only needs to be good enough to impersonate the
+	// service account, which gets the scopes.
+	sts.scopes = []string{cloudPlatformScope}
+	endpoint, target, err := parseImpersonationURL(c.ServiceAccountImpersonationURL)
+	if err != nil {
+		return nil, err
+	}
+	return impersonate.TokenSource(ctx, oauth2.ReuseTokenSource(nil, sts), &impersonate.Config{
+		Target:   target,
+		Scopes:   scopes,
+		Endpoint: endpoint,
+	})
+}
+
+// parseImpersonationURL returns the IAM Credentials endpoint and the service
+// account of a service account impersonation URL, like
+// "https://iamcredentials.googleapis.com/v1/projects/-/serviceAccounts/sa@p.iam.gserviceaccount.com:generateAccessToken".
+func parseImpersonationURL(s string) (endpoint, target string, err error) {
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", fmt.Errorf("externalaccount: bad service_account_impersonation_url: %v", err)
+	}
+	const prefix, suffix = "/v1/projects/-/serviceAccounts/", ":generateAccessToken"
+	if u.Scheme == "" || u.Host == "" || !strings.HasPrefix(u.Path, prefix) || !strings.HasSuffix(u.Path, suffix) ||
+		len(u.Path) == len(prefix)+len(suffix) {
+		return "", "", fmt.Errorf("externalaccount: bad service_account_impersonation_url %q", s)
+	}
+	return u.Scheme + "://" + u.Host, strings.TrimSuffix(strings.TrimPrefix(u.Path, prefix), suffix), nil
+}
+
+// stsTokenSource exchanges subject tokens for access tokens with the
+// Security Token Service.
+type stsTokenSource struct {
+	ctx    context.Context
+	c      *Config
+	scopes []string
+}
+
+type stsResponse struct {
+	AccessToken     string `json:"access_token"`
+	IssuedTokenType string `json:"issued_token_type"`
+	TokenType       string `json:"token_type"`
+	ExpiresIn       int64  `json:"expires_in"`
+}
+
+// Token gets a subject token and exchanges it for an access token.
+func (s *stsTokenSource) Token() (*oauth2.Token, error) {
+	subjectToken, err := s.c.CredentialSource.subjectToken(s.ctx, s.c)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type":           {tokenExchangeGrantType},
+		"audience":             {s.c.Audience},
+		"scope":                {strings.Join(s.scopes, " ")},
+		"requested_token_type": {accessTokenType},
+		"subject_token":        {subjectToken},
+		"subject_token_type":   {s.c.SubjectTokenType},
+	}
+	tokenURL := s.c.TokenURL
+	if tokenURL == "" {
+		tokenURL = defaultTokenURL
+	}
+	req, err := http.NewRequest("POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, fmt.Errorf("externalaccount: unable to create request: %v", err)
+	}
+	req = req.WithContext(s.ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if s.c.ClientID != "" {
+		req.SetBasicAuth(url.QueryEscape(s.c.ClientID), url.QueryEscape(s.c.ClientSecret))
+	}
+	now := time.Now()
+	body, err := do(s.ctx, req)
+	if err != nil {
+		return nil, fmt.Errorf("externalaccount: unable to exchange token: %v", err)
+	}
+	var resp stsResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, fmt.Errorf("externalaccount: unable to parse token exchange response: %v", err)
+	}
+	if resp.AccessToken == "" {
+		return nil, errors.New("externalaccount: token exchange response has no access token")
+	}
+	tok := &oauth2.Token{AccessToken: resp.AccessToken, TokenType: resp.TokenType}
+	if resp.ExpiresIn > 0 {
+		tok.Expiry = now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+	}
+	return tok, nil
+}
+
+// do sends req with the HTTP client of ctx, as oauth2 does, and returns the
+// response body if the status is 2xx.
+func do(ctx context.Context, req *http.Request) ([]byte, error) {
+	resp, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read body: %v", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("status code %d: %s", resp.StatusCode, body)
+	}
+	return body, nil
+}
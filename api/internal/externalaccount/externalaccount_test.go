@@ -0,0 +1,360 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package externalaccount
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testAudience  = "//iam.googleapis.com/projects/123/locations/global/workloadIdentityPools/pool/providers/provider"
+	jwtTokenType  = "urn:ietf:params:oauth:token-type:jwt"
+	subjectToken  = "subject-token"
+	testTarget    = "sa@p.iam.gserviceaccount.com"
+	testScope     = "https://www.googleapis.com/auth/devstorage.read_only"
+	executableEnv = "EXTERNALACCOUNT_TEST_EXECUTABLE"
+)
+
+// fakeSTS is a Security Token Service that exchanges subjectToken for
+// "sts-token-N".
+type fakeSTS struct {
+	t *testing.T
+
+	mu      sync.Mutex
+	calls   int
+	gotForm map[string]string
+	gotUser string
+	gotPass string
+}
+
+func (f *fakeSTS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls++
+	if err := r.ParseForm(); err != nil {
+		f.t.Errorf("ParseForm: %v", err)
+	}
+	f.gotForm = make(map[string]string)
+	for k := range r.PostForm {
+		f.gotForm[k] = r.PostForm.Get(k)
+	}
+	f.gotUser, f.gotPass, _ = r.BasicAuth()
+	if f.gotForm["subject_token"] != subjectToken {
+		http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"access_token": "sts-token-%d", "issued_token_type": %q, "token_type": "Bearer", "expires_in": 3600}`,
+		f.calls, accessTokenType)
+}
+
+// fakeIAM is an IAM Credentials server that returns "iam-token" to callers
+// authenticated with an STS token.
+type fakeIAM struct {
+	t *testing.T
+
+	gotPath string
+	gotAuth string
+	gotReq  map[string]interface{}
+}
+
+func (f *fakeIAM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.gotPath = r.URL.Path
+	f.gotAuth = r.Header.Get("Authorization")
+	b, _ := ioutil.ReadAll(r.Body)
+	if err := json.Unmarshal(b, &f.gotReq); err != nil {
+		f.t.Errorf("Unmarshal: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	fmt.Fprintf(w, `{"accessToken": "iam-token", "expireTime": %q}`, time.Now().Add(time.Hour).Format(time.RFC3339))
+}
+
+// config returns the JSON of an external account with the given credential
+// source, exchanging tokens at sts.
+func config(sts, source string, extra ...string) []byte {
+	fields := append([]string{
+		`"type": "external_account"`,
+		`"audience": "` + testAudience + `"`,
+		`"subject_token_type": "` + jwtTokenType + `"`,
+		`"token_url": "` + sts + `/v1/token"`,
+		`"credential_source": ` + source,
+	}, extra...)
+	return []byte("{" + strings.Join(fields, ", ") + "}")
+}
+
+func mustParse(t *testing.T, data []byte) *Config {
+	t.Helper()
+	c, err := ParseConfig(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func tempFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseConfig(t *testing.T) {
+	for _, tc := range []struct {
+		desc, json string
+		wantErr    string
+	}{
+		{"type", `{"type": "service_account"}`, "type"},
+		{"audience", `{"type": "external_account", "subject_token_type": "t", "credential_source": {"file": "f"}}`, "audience"},
+		{"token type", `{"type": "external_account", "audience": "a", "credential_source": {"file": "f"}}`, "subject_token_type"},
+		{"no source", `{"type": "external_account", "audience": "a", "subject_token_type": "t"}`, "exactly one"},
+		{"two sources", `{"type": "external_account", "audience": "a", "subject_token_type": "t", "credential_source": {"file": "f", "url": "u"}}`, "exactly one"},
+		{"format", `{"type": "external_account", "audience": "a", "subject_token_type": "t", "credential_source": {"file": "f", "format": {"type": "xml"}}}`, "format"},
+		{"json field", `{"type": "external_account", "audience": "a", "subject_token_type": "t", "credential_source": {"file": "f", "format": {"type": "json"}}}`, "subject_token_field_name"},
+		{"timeout", `{"type": "external_account", "audience": "a", "subject_token_type": "t", "credential_source": {"executable": {"command": "c", "timeout_millis": 1000}}}`, "timeout"},
+		{"impersonation", `{"type": "external_account", "audience": "a", "subject_token_type": "t", "credential_source": {"file": "f"}, "service_account_impersonation_url": "https://iamcredentials.googleapis.com/v1/sa"}`, "service_account_impersonation_url"},
+		{"ok", `{"type": "external_account", "audience": "a", "subject_token_type": "t", "credential_source": {"file": "f"}}`, ""},
+	} {
+		_, err := ParseConfig([]byte(tc.json))
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%s: got %v, want no error", tc.desc, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s: got %v, want an error about %s", tc.desc, err, tc.wantErr)
+		}
+	}
+}
+
+func TestTokenSourceFile(t *testing.T) {
+	sts := &fakeSTS{t: t}
+	server := httptest.NewServer(sts)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "externalaccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	text := tempFile(t, dir, "token.txt", subjectToken+"\n")
+	jsonFile := tempFile(t, dir, "token.json", `{"id_token": "`+subjectToken+`"}`)
+
+	for _, source := range []string{
+		`{"file": "` + text + `"}`,
+		`{"file": "` + jsonFile + `", "format": {"type": "json", "subject_token_field_name": "id_token"}}`,
+	} {
+		sts.calls = 0
+		c := mustParse(t, config(server.URL, source, `"client_id": "id", "client_secret": "secret"`))
+		ts, err := TokenSource(context.Background(), c, []string{testScope})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			tok, err := ts.Token()
+			if err != nil {
+				t.Fatalf("%s: %v", source, err)
+			}
+			// The token is cached.
+			if tok.AccessToken != "sts-token-1" {
+				t.Errorf("%s: got token %q, want sts-token-1", source, tok.AccessToken)
+			}
+		}
+		want := map[string]string{
+			"grant_type":           tokenExchangeGrantType,
+			"audience":             testAudience,
+			"scope":                testScope,
+			"requested_token_type": accessTokenType,
+			"subject_token":        subjectToken,
+			"subject_token_type":   jwtTokenType,
+		}
+		for k, v := range want {
+			if sts.gotForm[k] != v {
+				t.Errorf("%s: got %s %q, want %q", source, k, sts.gotForm[k], v)
+			}
+		}
+		if sts.gotUser != "id" || sts.gotPass != "secret" {
+			t.Errorf("%s: got basic auth %q, %q, want id, secret", source, sts.gotUser, sts.gotPass)
+		}
+	}
+}
+
+func TestTokenSourceURL(t *testing.T) {
+	sts := &fakeSTS{t: t}
+	server := httptest.NewServer(sts)
+	defer server.Close()
+	var gotHeader string
+	metadata := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotHeader = r.Header.Get("Metadata-Flavor")
+		fmt.Fprintf(w, `{"access_token": %q}`, subjectToken)
+	}))
+	defer metadata.Close()
+
+	c := mustParse(t, config(server.URL, `{"url": "`+metadata.URL+`", "headers": {"Metadata-Flavor": "Google"},
+		"format": {"type": "json", "subject_token_field_name": "access_token"}}`))
+	ts, err := TokenSource(context.Background(), c, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if gotHeader != "Google" {
+		t.Errorf("got header %q, want Google", gotHeader)
+	}
+	if got := sts.gotForm["scope"]; got != cloudPlatformScope {
+		t.Errorf("got scope %q, want %q", got, cloudPlatformScope)
+	}
+}
+
+func TestTokenSourceImpersonation(t *testing.T) {
+	sts := &fakeSTS{t: t}
+	server := httptest.NewServer(sts)
+	defer server.Close()
+	iam := &fakeIAM{t: t}
+	iamServer := httptest.NewServer(iam)
+	defer iamServer.Close()
+	dir, err := ioutil.TempDir("", "externalaccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := tempFile(t, dir, "token.txt", subjectToken)
+
+	c := mustParse(t, config(server.URL, `{"file": "`+file+`"}`,
+		`"service_account_impersonation_url": "`+iamServer.URL+`/v1/projects/-/serviceAccounts/`+testTarget+`:generateAccessToken"`))
+	ts, err := TokenSource(context.Background(), c, []string{testScope})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "iam-token" {
+		t.Errorf("got token %q, want iam-token", tok.AccessToken)
+	}
+	if got := sts.gotForm["scope"]; got != cloudPlatformScope {
+		t.Errorf("got STS scope %q, want %q", got, cloudPlatformScope)
+	}
+	if want := "/v1/projects/-/serviceAccounts/" + testTarget + ":generateAccessToken"; iam.gotPath != want {
+		t.Errorf("got IAM path %q, want %q", iam.gotPath, want)
+	}
+	if iam.gotAuth != "Bearer sts-token-1" {
+		t.Errorf("got IAM authorization %q, want the STS token", iam.gotAuth)
+	}
+	if got := fmt.Sprint(iam.gotReq["scope"]); got != "["+testScope+"]" {
+		t.Errorf("got IAM scope %s, want [%s]", got, testScope)
+	}
+}
+
+func TestTokenSourceErrors(t *testing.T) {
+	sts := &fakeSTS{t: t}
+	server := httptest.NewServer(sts)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "externalaccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	for _, tc := range []struct {
+		desc, source, wantErr string
+	}{
+		{"missing file", `{"file": "` + filepath.Join(dir, "missing") + `"}`, "unable to read"},
+		{"empty token", `{"file": "` + tempFile(t, dir, "empty", "\n") + `"}`, "empty subject token"},
+		{"rejected token", `{"file": "` + tempFile(t, dir, "bad", "bad-token") + `"}`, "status code 400"},
+	} {
+		ts, err := TokenSource(context.Background(), mustParse(t, config(server.URL, tc.source)), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ts.Token(); err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: got %v, want an error about %s", tc.desc, err, tc.wantErr)
+		}
+	}
+}
+
+// TestExecutableHelper is not a test, but the executable run by
+// TestTokenSourceExecutable: it prints the value of executableEnv.
+func TestExecutableHelper(t *testing.T) {
+	out := os.Getenv(executableEnv)
+	if out == "" {
+		return
+	}
+	if got := os.Getenv("GOOGLE_EXTERNAL_ACCOUNT_AUDIENCE"); got != testAudience {
+		out = `{"version": 1, "success": false, "code": "bad_env", "message": "audience ` + got + `"}`
+	}
+	fmt.Print(out)
+	os.Exit(0)
+}
+
+func TestTokenSourceExecutable(t *testing.T) {
+	sts := &fakeSTS{t: t}
+	server := httptest.NewServer(sts)
+	defer server.Close()
+	dir, err := ioutil.TempDir("", "externalaccount")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv(allowExecutablesEnv, os.Getenv(allowExecutablesEnv))
+	defer os.Setenv(executableEnv, os.Getenv(executableEnv))
+	defer func(f func() time.Time) { now = f }(now)
+	now = func() time.Time { return time.Unix(1000, 0) }
+
+	command := os.Args[0] + " -test.run=^TestExecutableHelper$"
+	response := func(success bool, tokenType string, expiration int64) string {
+		return fmt.Sprintf(`{"version": 1, "success": %t, "token_type": %q, "expiration_time": %d, "id_token": %q, "code": "401", "message": "denied"}`,
+			success, tokenType, expiration, subjectToken)
+	}
+	for _, tc := range []struct {
+		desc       string
+		allow      string
+		output     string // printed by the executable
+		outputFile string // content of the output file, if any
+		wantErr    string
+	}{
+		{desc: "not allowed", allow: "", output: response(true, jwtTokenType, 2000), wantErr: allowExecutablesEnv},
+		{desc: "ok", allow: "1", output: response(true, jwtTokenType, 2000)},
+		{desc: "no expiration", allow: "1", output: response(true, jwtTokenType, 0)},
+		{desc: "failure", allow: "1", output: response(false, jwtTokenType, 2000), wantErr: "denied"},
+		{desc: "expired", allow: "1", output: response(true, jwtTokenType, 500), wantErr: "expired"},
+		{desc: "token type", allow: "1", output: response(true, "urn:ietf:params:oauth:token-type:id_token", 2000), wantErr: "type"},
+		{desc: "bad output", allow: "1", output: "token", wantErr: "unable to parse"},
+		{desc: "output file", allow: "1", output: "token", outputFile: response(true, jwtTokenType, 2000)},
+		{desc: "expired output file", allow: "1", output: response(true, jwtTokenType, 2000), outputFile: response(true, jwtTokenType, 500)},
+		// A saved response with no expiration time is never used.
+		{desc: "output file without expiration", allow: "1", output: "token", outputFile: response(true, jwtTokenType, 0), wantErr: "unable to parse"},
+	} {
+		os.Setenv(allowExecutablesEnv, tc.allow)
+		os.Setenv(executableEnv, tc.output)
+		outputFile := ""
+		if tc.outputFile != "" {
+			outputFile = tempFile(t, dir, "output.json", tc.outputFile)
+		}
+		c := mustParse(t, config(server.URL, fmt.Sprintf(`{"executable": {"command": %q, "timeout_millis": 10000, "output_file": %q}}`,
+			command, outputFile)))
+		ts, err := TokenSource(context.Background(), c, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ts.Token()
+		if tc.wantErr == "" && err != nil {
+			t.Errorf("%s: got %v, want no error", tc.desc, err)
+		}
+		if tc.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%s: got %v, want an error about %s", tc.desc, err, tc.wantErr)
+		}
+	}
+}
@@ -0,0 +1,250 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package externalaccount
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	// allowExecutablesEnv must be set to "1" for subject tokens to be got
+	// from executables, which the JSON file could otherwise make run any
+	// command.
+	allowExecutablesEnv = "GOOGLE_EXTERNAL_ACCOUNT_ALLOW_EXECUTABLES"
+
+	defaultExecutableTimeout = 30 * time.Second
+	minExecutableTimeout     = 5 * time.Second
+	maxExecutableTimeout     = 120 * time.Second
+)
+
+// now is overridden in tests.
+var now = time.Now
+
+// CredentialSource tells where the subject token comes from: exactly one of
+// File, URL and Executable must be set.
+type CredentialSource struct {
+	// File is the path of a file that holds the subject token.
+	File string `json:"file"`
+	// URL is a local URL that returns the subject token to a GET request
+	// with Headers.
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"`
+	// Executable is a command that prints the subject token.
+	Executable *ExecutableConfig `json:"executable"`
+	// Format is the format of the content of File and of the response from
+	// URL.
+	Format Format `json:"format"`
+}
+
+// Format is the format of a subject token from a file or URL.
+type Format struct {
+	// Type is "text" for the subject token as is, or "json" for a JSON
+	// object that holds the subject token in SubjectTokenFieldName.
+	// Defaults to "text".
+	Type                  string `json:"type"`
+	SubjectTokenFieldName string `json:"subject_token_field_name"`
+}
+
+// ExecutableConfig is a command that prints the subject token.
+type ExecutableConfig struct {
+	// Command is the path of the executable and its arguments, separated
+	// by spaces. It is run without a shell.
+	Command string `json:"command"`
+	// TimeoutMillis is how long the command may run, between 5 and 120
+	// seconds. Defaults to 30 seconds.
+	TimeoutMillis int `json:"timeout_millis"`
+	// OutputFile is where the command caches its response, if anywhere. A
+	// response there is used without running the command if it has an
+	// expiration time that has not passed.
+	OutputFile string `json:"output_file"`
+}
+
+// check reports an error if s is invalid.
+func (s *CredentialSource) check() error {
+	n := 0
+	if s.File != "" {
+		n++
+	}
+	if s.URL != "" {
+		n++
+	}
+	if s.Executable != nil {
+		n++
+	}
+	if n != 1 {
+		return errors.New("externalaccount: credential_source must have exactly one of file, url and executable")
+	}
+	switch s.Format.Type {
+	case "", "text":
+	case "json":
+		if s.Format.SubjectTokenFieldName == "" {
+			return errors.New("externalaccount: credential_source format of type json has no subject_token_field_name")
+		}
+	default:
+		return fmt.Errorf("externalaccount: unsupported credential_source format type %q", s.Format.Type)
+	}
+	if e := s.Executable; e != nil {
+		if e.Command == "" {
+			return errors.New("externalaccount: credential_source executable has no command")
+		}
+		if t := time.Duration(e.TimeoutMillis) * time.Millisecond; t != 0 && (t < minExecutableTimeout || t > maxExecutableTimeout) {
+			return fmt.Errorf("externalaccount: credential_source executable timeout must be between %v and %v", minExecutableTimeout, maxExecutableTimeout)
+		}
+	}
+	return nil
+}
+
+// subjectToken returns a subject token for the external account c.
+func (s *CredentialSource) subjectToken(ctx context.Context, c *Config) (string, error) {
+	switch {
+	case s.File != "":
+		b, err := ioutil.ReadFile(s.File)
+		if err != nil {
+			return "", fmt.Errorf("externalaccount: unable to read subject token file: %v", err)
+		}
+		return s.Format.parse(b)
+	case s.URL != "":
+		req, err := http.NewRequest("GET", s.URL, nil)
+		if err != nil {
+			return "", fmt.Errorf("externalaccount: unable to create subject token request: %v", err)
+		}
+		req = req.WithContext(ctx)
+		for k, v := range s.Headers {
+			req.Header.Set(k, v)
+		}
+		b, err := do(ctx, req)
+		if err != nil {
+			return "", fmt.Errorf("externalaccount: unable to get subject token: %v", err)
+		}
+		return s.Format.parse(b)
+	default:
+		return s.Executable.subjectToken(ctx, c)
+	}
+}
+
+// parse returns the subject token in b.
+func (f Format) parse(b []byte) (string, error) {
+	var tok string
+	if f.Type == "json" {
+		var v map[string]interface{}
+		if err := json.Unmarshal(b, &v); err != nil {
+			return "", fmt.Errorf("externalaccount: unable to parse subject token: %v", err)
+		}
+		tok, _ = v[f.SubjectTokenFieldName].(string)
+	} else {
+		tok = strings.TrimSpace(string(b))
+	}
+	if tok == "" {
+		return "", errors.New("externalaccount: empty subject token")
+	}
+	return tok, nil
+}
+
+// executableResponse is the output of an executable.
+type executableResponse struct {
+	Version        int    `json:"version"`
+	Success        *bool  `json:"success"`
+	TokenType      string `json:"token_type"`
+	ExpirationTime int64  `json:"expiration_time"`
+	IDToken        string `json:"id_token"`
+	SAMLResponse   string `json:"saml_response"`
+	Code           string `json:"code"`
+	Message        string `json:"message"`
+}
+
+// subjectToken returns the subject token from the output file of e, if it
+// holds an unexpired one, else from running e.
+func (e *ExecutableConfig) subjectToken(ctx context.Context, c *Config) (string, error) {
+	if os.Getenv(allowExecutablesEnv) != "1" {
+		return "", fmt.Errorf("externalaccount: executables must be allowed by setting %s to 1", allowExecutablesEnv)
+	}
+	if e.OutputFile != "" {
+		if b, err := ioutil.ReadFile(e.OutputFile); err == nil && len(bytes.TrimSpace(b)) > 0 {
+			// A response that can't be used is ignored, and the command
+			// is run as if there were none. Without an expiration time
+			// there is no telling when a saved response goes stale, so
+			// it must have one.
+			if tok, err := parseExecutableResponse(b, c, true); err == nil {
+				return tok, nil
+			}
+		}
+	}
+
+	timeout := defaultExecutableTimeout
+	if e.TimeoutMillis != 0 {
+		timeout = time.Duration(e.TimeoutMillis) * time.Millisecond
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	args := strings.Fields(e.Command)
+	cmd := exec.CommandContext(ctx, args[0], args[1:]...)
+	cmd.Env = append(os.Environ(),
+		"GOOGLE_EXTERNAL_ACCOUNT_AUDIENCE="+c.Audience,
+		"GOOGLE_EXTERNAL_ACCOUNT_TOKEN_TYPE="+c.SubjectTokenType,
+		"GOOGLE_EXTERNAL_ACCOUNT_INTERACTIVE=0",
+	)
+	if e.OutputFile != "" {
+		cmd.Env = append(cmd.Env, "GOOGLE_EXTERNAL_ACCOUNT_OUTPUT_FILE="+e.OutputFile)
+	}
+	if c.ServiceAccountImpersonationURL != "" {
+		if _, target, err := parseImpersonationURL(c.ServiceAccountImpersonationURL); err == nil {
+			cmd.Env = append(cmd.Env, "GOOGLE_EXTERNAL_ACCOUNT_IMPERSONATED_EMAIL="+target)
+		}
+	}
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("externalaccount: executable timed out after %v", timeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("externalaccount: executable failed: %v", err)
+	}
+	return parseExecutableResponse(out, c, false)
+}
+
+// parseExecutableResponse returns the subject token in the response b of an
+// executable. If needExpiration is set, a response without an expiration time
+// is an error.
+func parseExecutableResponse(b []byte, c *Config, needExpiration bool) (string, error) {
+	var r executableResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		return "", fmt.Errorf("externalaccount: unable to parse executable response: %v", err)
+	}
+	if r.Version != 1 {
+		return "", fmt.Errorf("externalaccount: unsupported executable response version %d", r.Version)
+	}
+	if r.Success == nil {
+		return "", errors.New("externalaccount: executable response has no success field")
+	}
+	if !*r.Success {
+		return "", fmt.Errorf("externalaccount: executable failed with code %q: %s", r.Code, r.Message)
+	}
+	if r.TokenType != c.SubjectTokenType {
+		return "", fmt.Errorf("externalaccount: executable returned a token of type %q, want %q", r.TokenType, c.SubjectTokenType)
+	}
+	if r.ExpirationTime == 0 && needExpiration {
+		return "", errors.New("externalaccount: executable response has no expiration time")
+	}
+	if r.ExpirationTime != 0 && !now().Before(time.Unix(r.ExpirationTime, 0)) {
+		return "", errors.New("externalaccount: executable returned an expired token")
+	}
+	tok := r.IDToken
+	if r.TokenType == "urn:ietf:params:oauth:token-type:saml2" {
+		tok = r.SAMLResponse
+	}
+	if tok == "" {
+		return "", errors.New("externalaccount: executable returned an empty token")
+	}
+	return tok, nil
+}
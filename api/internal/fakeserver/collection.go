@@ -0,0 +1,194 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+)
+
+// Collection is an in-memory store for the resources of a standard
+// collection, one whose methods get, list, insert, patch, update and delete
+// resources. Resources are kept as JSON objects, in the order they were
+// inserted, and are grouped by parent: the values of the path parameters
+// of a method, other than the ID.
+type Collection struct {
+	IDParam    string // path parameter that holds the ID of a resource, e.g. "tasklist"
+	IDField    string // JSON field of a resource that holds its ID, e.g. "id"
+	ItemsField string // JSON field of a list response that holds the resources, e.g. "items"
+
+	mu      sync.Mutex
+	lastID  int
+	parents map[string][]*entry
+}
+
+type entry struct {
+	id  string
+	obj map[string]interface{}
+}
+
+// parent returns the parent of the resources that r is about.
+func (c *Collection) parent(r *Request) string {
+	var vals []string
+	for _, p := range r.pathParams {
+		if p != c.IDParam {
+			vals = append(vals, r.Params[p])
+		}
+	}
+	return strings.Join(vals, "/")
+}
+
+func (c *Collection) find(r *Request) (parent string, i int, err error) {
+	parent = c.parent(r)
+	id := r.Params[c.IDParam]
+	for i, e := range c.parents[parent] {
+		if e.id == id {
+			return parent, i, nil
+		}
+	}
+	return parent, -1, &googleapi.Error{
+		Code:    http.StatusNotFound,
+		Message: fmt.Sprintf("%s %q not found", c.IDParam, id),
+	}
+}
+
+// Get decodes the resource requested by r into ret.
+func (c *Collection) Get(r *Request, ret interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parent, i, err := c.find(r)
+	if err != nil {
+		return err
+	}
+	return convert(c.parents[parent][i].obj, ret)
+}
+
+// List decodes a page of the resources requested by r into the list response
+// ret. The page size is taken from the maxResults or pageSize parameter, if
+// any, and the page from the pageToken parameter.
+func (c *Collection) List(r *Request, ret interface{}) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := c.parents[c.parent(r)]
+	start := 0
+	if tok := r.Params["pageToken"]; tok != "" {
+		n, err := strconv.Atoi(tok)
+		if err != nil || n < 0 || n > len(entries) {
+			return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid page token %q", tok)}
+		}
+		start = n
+	}
+	end := len(entries)
+	for _, p := range []string{"maxResults", "pageSize"} {
+		if n, err := strconv.Atoi(r.Params[p]); err == nil && n > 0 && start+n < end {
+			end = start + n
+		}
+	}
+	items := []interface{}{}
+	for _, e := range entries[start:end] {
+		items = append(items, e.obj)
+	}
+	list := map[string]interface{}{c.ItemsField: items}
+	if end < len(entries) {
+		list["nextPageToken"] = strconv.Itoa(end)
+	}
+	return convert(list, ret)
+}
+
+// Insert stores the resource in the body of r, and decodes it into ret. The
+// resource is given a new ID if it has none.
+func (c *Collection) Insert(r *Request, ret interface{}) error {
+	var obj map[string]interface{}
+	if err := r.DecodeBody(&obj); err != nil {
+		return err
+	}
+	if obj == nil {
+		obj = make(map[string]interface{})
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parent := c.parent(r)
+	id, _ := obj[c.IDField].(string)
+	if id == "" {
+		c.lastID++
+		id = strconv.Itoa(c.lastID)
+		obj[c.IDField] = id
+	}
+	for _, e := range c.parents[parent] {
+		if e.id == id {
+			return &googleapi.Error{
+				Code:    http.StatusConflict,
+				Message: fmt.Sprintf("%s %q already exists", c.IDParam, id),
+			}
+		}
+	}
+	if c.parents == nil {
+		c.parents = make(map[string][]*entry)
+	}
+	c.parents[parent] = append(c.parents[parent], &entry{id: id, obj: obj})
+	return convert(obj, ret)
+}
+
+// Patch sets the fields of the resource requested by r to those in the body
+// of r, and decodes the result into ret.
+func (c *Collection) Patch(r *Request, ret interface{}) error {
+	return c.modify(r, ret, false)
+}
+
+// Update replaces the resource requested by r with the body of r, and
+// decodes the result into ret.
+func (c *Collection) Update(r *Request, ret interface{}) error {
+	return c.modify(r, ret, true)
+}
+
+func (c *Collection) modify(r *Request, ret interface{}, replace bool) error {
+	var obj map[string]interface{}
+	if err := r.DecodeBody(&obj); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parent, i, err := c.find(r)
+	if err != nil {
+		return err
+	}
+	e := c.parents[parent][i]
+	if replace {
+		e.obj = make(map[string]interface{})
+	}
+	for k, v := range obj {
+		e.obj[k] = v
+	}
+	e.obj[c.IDField] = e.id
+	return convert(e.obj, ret)
+}
+
+// Delete deletes the resource requested by r.
+func (c *Collection) Delete(r *Request) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	parent, i, err := c.find(r)
+	if err != nil {
+		return err
+	}
+	entries := c.parents[parent]
+	c.parents[parent] = append(entries[:i:i], entries[i+1:]...)
+	return nil
+}
+
+// convert decodes the JSON encoding of v into ret.
+func convert(v, ret interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, ret)
+}
@@ -0,0 +1,225 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package fakeserver is a helper package for the fake API servers generated
+// alongside the API packages.
+//
+// This package is not intended for use by end developers. Use the generated
+// fake packages instead.
+package fakeserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// ErrNotImplemented is returned for methods that have no implementation. It
+// is reported to clients as 501 Not Implemented.
+var ErrNotImplemented = &googleapi.Error{
+	Code:    http.StatusNotImplemented,
+	Message: "method not implemented by the fake server",
+}
+
+// Request is a request to a method of a fake server.
+type Request struct {
+	*http.Request
+
+	// Params holds the path parameters of the method, and the first value of
+	// each query parameter.
+	Params map[string]string
+
+	// Body is the request body.
+	Body []byte
+
+	pathParams []string // names of the path parameters, in path order
+}
+
+// DecodeBody decodes the JSON request body into v.
+func (r *Request) DecodeBody(v interface{}) error {
+	if err := json.Unmarshal(r.Body, v); err != nil {
+		return &googleapi.Error{Code: http.StatusBadRequest, Message: fmt.Sprintf("invalid request body: %v", err)}
+	}
+	return nil
+}
+
+// A Handler implements a method of a fake server. The result is written to
+// the client as JSON. A nil result is written as an empty 204 response.
+type Handler func(r *Request) (interface{}, error)
+
+// Router routes requests to Handlers by HTTP method and path. The zero value
+// is an empty Router.
+type Router struct {
+	// DataWrapper is set for APIs whose request and response bodies are
+	// wrapped in a "data" field.
+	DataWrapper bool
+
+	routes []*route
+}
+
+type route struct {
+	method  string
+	re      *regexp.Regexp
+	params  []string
+	literal int // number of literal characters in the path template
+	h       Handler
+}
+
+var templateParam = regexp.MustCompile(`\{\+?[^}]*\}`)
+
+// Handle registers h for requests with the given HTTP method and a path that
+// matches the discovery path template, such as "projects/{project}/items" or
+// "v1/{+name}:cancel". A {+name} parameter can contain slashes. When several
+// templates match a path, the one with the most literal characters wins.
+func (rt *Router) Handle(method, template string, h Handler) {
+	if !strings.HasPrefix(template, "/") {
+		template = "/" + template
+	}
+	r := &route{method: method, h: h}
+	var re strings.Builder
+	re.WriteString("^")
+	last := 0
+	for _, loc := range templateParam.FindAllStringIndex(template, -1) {
+		lit := template[last:loc[0]]
+		re.WriteString(regexp.QuoteMeta(lit))
+		r.literal += len(lit)
+		name := template[loc[0]+1 : loc[1]-1]
+		if strings.HasPrefix(name, "+") {
+			re.WriteString("(.+)")
+			name = name[1:]
+		} else {
+			re.WriteString("([^/]+)")
+		}
+		r.params = append(r.params, name)
+		last = loc[1]
+	}
+	re.WriteString(regexp.QuoteMeta(template[last:]))
+	r.literal += len(template) - last
+	re.WriteString("$")
+	r.re = regexp.MustCompile(re.String())
+	rt.routes = append(rt.routes, r)
+	sort.SliceStable(rt.routes, func(i, j int) bool {
+		return rt.routes[i].literal > rt.routes[j].literal
+	})
+}
+
+// ServeHTTP implements http.Handler.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := req.URL.EscapedPath()
+	for _, r := range rt.routes {
+		if r.method != req.Method {
+			continue
+		}
+		m := r.re.FindStringSubmatch(path)
+		if m == nil {
+			continue
+		}
+		fr, err := rt.newRequest(req, r.params, m[1:])
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		res, err := r.h(fr)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		rt.writeResult(w, res)
+		return
+	}
+	writeError(w, &googleapi.Error{
+		Code:    http.StatusNotFound,
+		Message: fmt.Sprintf("no method for %s %s", req.Method, req.URL.Path),
+	})
+}
+
+func (rt *Router) newRequest(req *http.Request, names, values []string) (*Request, error) {
+	r := &Request{
+		Request:    req,
+		Params:     make(map[string]string),
+		pathParams: names,
+	}
+	for k, vs := range req.URL.Query() {
+		r.Params[k] = vs[0]
+	}
+	for i, name := range names {
+		v, err := url.PathUnescape(values[i])
+		if err != nil {
+			return nil, &googleapi.Error{Code: http.StatusBadRequest, Message: err.Error()}
+		}
+		r.Params[name] = v
+	}
+	if req.Body != nil {
+		b, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			return nil, err
+		}
+		r.Body = b
+	}
+	if rt.DataWrapper && len(r.Body) > 0 {
+		var wrapper struct {
+			Data json.RawMessage `json:"data"`
+		}
+		if err := r.DecodeBody(&wrapper); err != nil {
+			return nil, err
+		}
+		r.Body = wrapper.Data
+	}
+	return r, nil
+}
+
+func (rt *Router) writeResult(w http.ResponseWriter, res interface{}) {
+	if v := reflect.ValueOf(res); !v.IsValid() || v.Kind() == reflect.Ptr && v.IsNil() {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	if rt.DataWrapper {
+		res = map[string]interface{}{"data": res}
+	}
+	b, err := json.Marshal(res)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(b)
+}
+
+// writeError writes err in the JSON error format of Google APIs, so that
+// clients see it as a *googleapi.Error. Errors other than *googleapi.Error
+// are written as 500 Internal Server Error.
+func writeError(w http.ResponseWriter, err error) {
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		apiErr = &googleapi.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+	type errorItem struct {
+		Reason  string `json:"reason,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+	var body struct {
+		Error struct {
+			Code    int         `json:"code"`
+			Message string      `json:"message,omitempty"`
+			Errors  []errorItem `json:"errors,omitempty"`
+		} `json:"error"`
+	}
+	body.Error.Code = apiErr.Code
+	body.Error.Message = apiErr.Message
+	for _, e := range apiErr.Errors {
+		body.Error.Errors = append(body.Error.Errors, errorItem{e.Reason, e.Message})
+	}
+	b, _ := json.Marshal(body)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(apiErr.Code)
+	w.Write(b)
+}
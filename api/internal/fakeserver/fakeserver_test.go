@@ -0,0 +1,182 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package fakeserver
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type item struct {
+	ID    string `json:"id,omitempty"`
+	Title string `json:"title,omitempty"`
+}
+
+type itemList struct {
+	Items         []*item `json:"items"`
+	NextPageToken string  `json:"nextPageToken,omitempty"`
+}
+
+// do sends a request to h and returns the status code and body of the
+// response.
+func do(t *testing.T, h http.Handler, method, path, body string) (int, string) {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	b, err := ioutil.ReadAll(w.Result().Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w.Code, string(b)
+}
+
+func TestRouter(t *testing.T) {
+	var rt Router
+	rt.Handle("GET", "v1/{+name}", func(r *Request) (interface{}, error) {
+		return map[string]string{"get": r.Params["name"]}, nil
+	})
+	rt.Handle("POST", "v1/{+name}:cancel", func(r *Request) (interface{}, error) {
+		return map[string]string{"cancel": r.Params["name"]}, nil
+	})
+	rt.Handle("POST", "v1/{+name}", func(r *Request) (interface{}, error) {
+		return nil, ErrNotImplemented
+	})
+	rt.Handle("GET", "projects/{project}/items/{item}", func(r *Request) (interface{}, error) {
+		return map[string]string{"project": r.Params["project"], "item": r.Params["item"], "q": r.Params["q"]}, nil
+	})
+	rt.Handle("DELETE", "projects/{project}/items/{item}", func(r *Request) (interface{}, error) {
+		return nil, nil
+	})
+
+	for _, test := range []struct {
+		method, path string
+		wantCode     int
+		wantBody     string
+	}{
+		{"GET", "/v1/operations/a/b", 200, `{"get":"operations/a/b"}`},
+		{"POST", "/v1/operations/a:cancel", 200, `{"cancel":"operations/a"}`},
+		{"POST", "/v1/operations/a", 501, `{"error":{"code":501,"message":"method not implemented by the fake server"}}`},
+		{"GET", "/projects/p/items/a%2Fb?q=x", 200, `{"item":"a/b","project":"p","q":"x"}`},
+		{"DELETE", "/projects/p/items/i", 204, ``},
+		{"GET", "/projects/p/items", 404, `{"error":{"code":404,"message":"no method for GET /projects/p/items"}}`},
+		{"PUT", "/projects/p/items/i", 404, `{"error":{"code":404,"message":"no method for PUT /projects/p/items/i"}}`},
+	} {
+		code, body := do(t, &rt, test.method, test.path, "")
+		if code != test.wantCode || body != test.wantBody {
+			t.Errorf("%s %s: got %d %s, want %d %s", test.method, test.path, code, body, test.wantCode, test.wantBody)
+		}
+	}
+}
+
+func TestDataWrapper(t *testing.T) {
+	rt := Router{DataWrapper: true}
+	rt.Handle("POST", "items", func(r *Request) (interface{}, error) {
+		var it item
+		if err := r.DecodeBody(&it); err != nil {
+			return nil, err
+		}
+		return &it, nil
+	})
+	rt.Handle("GET", "items/{item}", func(r *Request) (interface{}, error) {
+		return (*item)(nil), nil
+	})
+	code, body := do(t, &rt, "POST", "/items", `{"data":{"title":"t"}}`)
+	if want := `{"data":{"title":"t"}}`; code != 200 || body != want {
+		t.Errorf("POST: got %d %s, want 200 %s", code, body, want)
+	}
+	if code, body := do(t, &rt, "GET", "/items/x", ""); code != 204 || body != "" {
+		t.Errorf("GET: got %d %s, want 204 with no body", code, body)
+	}
+	if code, _ := do(t, &rt, "POST", "/items", `{`); code != 400 {
+		t.Errorf("POST with invalid body: got %d, want 400", code)
+	}
+}
+
+func newCollectionRouter() *Router {
+	c := &Collection{IDParam: "item", IDField: "id", ItemsField: "items"}
+	var rt Router
+	rt.Handle("GET", "projects/{project}/items", func(r *Request) (interface{}, error) {
+		ret := new(itemList)
+		return ret, c.List(r, ret)
+	})
+	rt.Handle("POST", "projects/{project}/items", func(r *Request) (interface{}, error) {
+		ret := new(item)
+		return ret, c.Insert(r, ret)
+	})
+	rt.Handle("GET", "projects/{project}/items/{item}", func(r *Request) (interface{}, error) {
+		ret := new(item)
+		return ret, c.Get(r, ret)
+	})
+	rt.Handle("PATCH", "projects/{project}/items/{item}", func(r *Request) (interface{}, error) {
+		ret := new(item)
+		return ret, c.Patch(r, ret)
+	})
+	rt.Handle("PUT", "projects/{project}/items/{item}", func(r *Request) (interface{}, error) {
+		ret := new(item)
+		return ret, c.Update(r, ret)
+	})
+	rt.Handle("DELETE", "projects/{project}/items/{item}", func(r *Request) (interface{}, error) {
+		return nil, c.Delete(r)
+	})
+	return &rt
+}
+
+func TestCollection(t *testing.T) {
+	rt := newCollectionRouter()
+	check := func(method, path, reqBody string, wantCode int, wantBody string) {
+		t.Helper()
+		code, body := do(t, rt, method, path, reqBody)
+		if code != wantCode || (wantBody != "" && body != wantBody) {
+			t.Errorf("%s %s: got %d %s, want %d %s", method, path, code, body, wantCode, wantBody)
+		}
+	}
+	check("POST", "/projects/p/items", `{"title":"a"}`, 200, `{"id":"1","title":"a"}`)
+	check("POST", "/projects/p/items", `{"id":"b","title":"b"}`, 200, `{"id":"b","title":"b"}`)
+	check("POST", "/projects/p/items", `{"id":"b"}`, 409, "")
+	check("POST", "/projects/q/items", `{"title":"c"}`, 200, `{"id":"2","title":"c"}`)
+
+	check("GET", "/projects/p/items/1", "", 200, `{"id":"1","title":"a"}`)
+	check("GET", "/projects/q/items/1", "", 404, "")
+
+	check("PATCH", "/projects/p/items/1", `{"title":"a2"}`, 200, `{"id":"1","title":"a2"}`)
+	check("PUT", "/projects/p/items/b", `{"id":"other"}`, 200, `{"id":"b"}`)
+	check("PATCH", "/projects/p/items/x", `{}`, 404, "")
+
+	check("GET", "/projects/p/items", "", 200, `{"items":[{"id":"1","title":"a2"},{"id":"b"}]}`)
+	check("GET", "/projects/p/items?maxResults=1", "", 200, `{"items":[{"id":"1","title":"a2"}],"nextPageToken":"1"}`)
+	check("GET", "/projects/p/items?pageSize=1&pageToken=1", "", 200, `{"items":[{"id":"b"}]}`)
+	check("GET", "/projects/p/items?pageToken=x", "", 400, "")
+	check("GET", "/projects/r/items", "", 200, `{"items":[]}`)
+
+	check("DELETE", "/projects/p/items/1", "", 204, "")
+	check("DELETE", "/projects/p/items/1", "", 404, "")
+	check("GET", "/projects/p/items", "", 200, `{"items":[{"id":"b"}]}`)
+}
+
+func TestErrorBody(t *testing.T) {
+	var rt Router
+	rt.Handle("GET", "x", func(r *Request) (interface{}, error) {
+		return nil, errors.New("test error")
+	})
+	code, body := do(t, &rt, "GET", "/x", "")
+	var got struct {
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal([]byte(body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if code != 500 || got.Error.Code != 500 || got.Error.Message != "test error" {
+		t.Errorf("got %d %s, want 500 with message %q", code, body, "test error")
+	}
+}
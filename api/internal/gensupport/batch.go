@@ -0,0 +1,325 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/api/googleapi"
+)
+
+// BatchCall makes one call of a generated API with the given context, and
+// returns a function that reports the result of the call.
+type BatchCall func(ctx context.Context) (report func())
+
+// Batch sends calls of a generated API together, as the parts of
+// multipart/mixed HTTP requests to the API's batch endpoint.
+//
+// Each call is made as usual, but with a context that makes SendRequest
+// hand the HTTP request to the batch instead of sending it. The call waits
+// for the batch to be sent and receives its own part of the response, so
+// it decodes the response and reports errors just as if it had been sent
+// alone.
+type Batch struct {
+	Client    *http.Client
+	URL       string // URL of the batch endpoint
+	UserAgent string
+	MaxSize   int // maximum number of calls in one HTTP request; 0 means no limit
+
+	calls []BatchCall
+}
+
+// Add adds a call to the batch.
+func (b *Batch) Add(c BatchCall) {
+	b.calls = append(b.calls, c)
+}
+
+// Len returns the number of calls in the batch that have not been sent.
+func (b *Batch) Len() int {
+	return len(b.calls)
+}
+
+// Do sends the calls in the batch, in HTTP requests of at most MaxSize calls
+// each, and then calls the report functions of the calls in the order the
+// calls were added. It returns the first error that made an HTTP request fail
+// as a whole; that error is also reported by each call in the request. The
+// batch is empty afterwards.
+func (b *Batch) Do(ctx context.Context) error {
+	calls := b.calls
+	b.calls = nil
+	var firstErr error
+	for len(calls) > 0 {
+		n := len(calls)
+		if b.MaxSize > 0 && n > b.MaxSize {
+			n = b.MaxSize
+		}
+		if err := b.doChunk(ctx, calls[:n]); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		calls = calls[n:]
+	}
+	return firstErr
+}
+
+type batchKey struct{}
+
+// batchSlot is where a batched call sends its HTTP request.
+type batchSlot struct {
+	i      int
+	events chan<- batchEvent
+	res    chan batchResult
+	used   bool
+}
+
+// batchEvent is sent by a batched call when it sends its request, and again
+// when it returns.
+type batchEvent struct {
+	i      int
+	req    *http.Request
+	report func()
+}
+
+type batchResult struct {
+	resp *http.Response
+	err  error
+}
+
+// batchSlotFrom returns the batch slot of ctx, or nil if the request is not
+// part of a batch.
+func batchSlotFrom(ctx context.Context) *batchSlot {
+	s, _ := ctx.Value(batchKey{}).(*batchSlot)
+	return s
+}
+
+// send hands req to the batch and waits for its response.
+func (s *batchSlot) send(req *http.Request) (*http.Response, error) {
+	if s.used {
+		return nil, errors.New("gensupport: a batched call can send only one request")
+	}
+	s.used = true
+	s.events <- batchEvent{i: s.i, req: req}
+	r := <-s.res
+	return r.resp, r.err
+}
+
+func (b *Batch) doChunk(ctx context.Context, calls []BatchCall) error {
+	events := make(chan batchEvent, 2*len(calls))
+	slots := make([]*batchSlot, len(calls))
+	for i, c := range calls {
+		slots[i] = &batchSlot{i: i, events: events, res: make(chan batchResult, 1)}
+		go func(c BatchCall, s *batchSlot) {
+			report := c(context.WithValue(ctx, batchKey{}, s))
+			events <- batchEvent{i: s.i, report: report}
+		}(c, slots[i])
+	}
+
+	// Wait until each call has either sent its request or returned without
+	// sending one.
+	reports := make([]func(), len(calls))
+	sent := make([]*http.Request, len(calls))
+	for pending := len(calls); pending > 0; {
+		ev := <-events
+		if ev.req != nil {
+			sent[ev.i] = ev.req
+			pending--
+			continue
+		}
+		reports[ev.i] = ev.report
+		if sent[ev.i] == nil {
+			pending--
+		}
+	}
+
+	// Send the requests in the order the calls were added.
+	var reqs []*http.Request
+	var senders []*batchSlot
+	for i, req := range sent {
+		if req != nil {
+			reqs = append(reqs, req)
+			senders = append(senders, slots[i])
+		}
+	}
+	var err error
+	if len(reqs) > 0 {
+		var results []batchResult
+		results, err = b.send(ctx, reqs)
+		for j, s := range senders {
+			if err != nil {
+				s.res <- batchResult{err: err}
+			} else {
+				s.res <- results[j]
+			}
+		}
+		for range senders {
+			ev := <-events
+			reports[ev.i] = ev.report
+		}
+	}
+	for _, report := range reports {
+		if report != nil {
+			report()
+		}
+	}
+	return err
+}
+
+// send sends reqs in one multipart/mixed HTTP request and returns their
+// responses. It returns an error if the request fails as a whole.
+func (b *Batch) send(ctx context.Context, reqs []*http.Request) ([]batchResult, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	for i, req := range reqs {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "application/http")
+		h.Set("Content-Transfer-Encoding", "binary")
+		h.Set("Content-ID", fmt.Sprintf("<item%d>", i+1))
+		pw, err := mw.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBatchPart(pw, req); err != nil {
+			return nil, err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", b.URL, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	if b.UserAgent != "" {
+		req.Header.Set("User-Agent", b.UserAgent)
+	}
+	res, err := SendRequest(ctx, b.Client, req)
+	if err != nil {
+		return nil, err
+	}
+	defer googleapi.CloseBody(res)
+	if err := googleapi.CheckResponse(res); err != nil {
+		return nil, err
+	}
+	return readBatchResponse(res, reqs)
+}
+
+// writeBatchPart writes req to w in HTTP/1.1 wire format, with a path
+// relative to the host.
+func writeBatchPart(w io.Writer, req *http.Request) error {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = ioutil.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%s %s HTTP/1.1\r\n", req.Method, req.URL.RequestURI()); err != nil {
+		return err
+	}
+	if err := req.Header.Write(w); err != nil {
+		return err
+	}
+	if body != nil {
+		if _, err := fmt.Fprintf(w, "Content-Length: %d\r\n", len(body)); err != nil {
+			return err
+		}
+	}
+	if _, err := io.WriteString(w, "\r\n"); err != nil {
+		return err
+	}
+	_, err := w.Write(body)
+	return err
+}
+
+// readBatchResponse splits the multipart/mixed response res into the
+// responses to reqs. Parts are matched to requests by their Content-ID, or
+// else by their position.
+func readBatchResponse(res *http.Response, reqs []*http.Request) ([]batchResult, error) {
+	mediaType, params, err := mime.ParseMediaType(res.Header.Get("Content-Type"))
+	if err != nil {
+		return nil, fmt.Errorf("gensupport: batch response: %v", err)
+	}
+	if !strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("gensupport: batch response has content type %q, want multipart/mixed", mediaType)
+	}
+	results := make([]batchResult, len(reqs))
+	got := make([]bool, len(reqs))
+	mr := multipart.NewReader(res.Body, params["boundary"])
+	for n := 0; ; n++ {
+		part, err := mr.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("gensupport: batch response: %v", err)
+		}
+		i := batchPartIndex(part.Header.Get("Content-ID"), n)
+		if i < 0 || i >= len(reqs) {
+			continue
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(part), reqs[i])
+		if err == nil {
+			// The body must be read before the next part.
+			var body []byte
+			body, err = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			resp.Body = ioutil.NopCloser(bytes.NewReader(body))
+		}
+		results[i] = batchResult{resp: resp, err: err}
+		got[i] = true
+	}
+	for i := range results {
+		if !got[i] {
+			results[i].err = errors.New("gensupport: no response to call in batch")
+		}
+	}
+	return results, nil
+}
+
+// batchPartIndex returns the index of the request that the response part with
+// the Content-ID cid answers, where n is the position of the part.
+func batchPartIndex(cid string, n int) int {
+	cid = strings.Trim(cid, "<>")
+	if i := strings.LastIndex(cid, "item"); i >= 0 {
+		if id, err := strconv.Atoi(cid[i+len("item"):]); err == nil {
+			return id - 1
+		}
+	}
+	return n
+}
+
+// BatchURL returns the URL of the batch endpoint batchPath of an API whose
+// methods are at basePath, where basePath normally ends in servicePath. The
+// batch endpoint is relative to the root of basePath, so that it follows the
+// API if it is served elsewhere.
+func BatchURL(basePath, servicePath, batchPath string) string {
+	root := strings.TrimSuffix(basePath, servicePath)
+	if root == basePath && servicePath != "" {
+		if u, err := url.Parse(basePath); err == nil {
+			u.Path, u.RawPath, u.RawQuery = "/", "", ""
+			root = u.String()
+		}
+	}
+	if !strings.HasSuffix(root, "/") {
+		root += "/"
+	}
+	return root + batchPath
+}
@@ -0,0 +1,186 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+// batchServer answers batch requests. A part for a path that starts with
+// /missing gets a 404 response; other parts get their path and body echoed
+// back as JSON. Responses are written in reverse order.
+type batchServer struct {
+	batches [][]string // paths of the calls in each batch request
+}
+
+func (s *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	type part struct {
+		cid    string
+		status int
+		body   string
+	}
+	var parts []part
+	var paths []string
+	mr := multipart.NewReader(r.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		req, err := http.ReadRequest(bufio.NewReader(p))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		body, _ := ioutil.ReadAll(req.Body)
+		paths = append(paths, req.URL.Path)
+		cid := strings.Replace(p.Header.Get("Content-ID"), "<", "<response-", 1)
+		if strings.HasPrefix(req.URL.Path, "/missing") {
+			parts = append(parts, part{cid, http.StatusNotFound, `{"error": {"code": 404, "message": "not found"}}`})
+			continue
+		}
+		echo, _ := json.Marshal(map[string]string{"path": req.URL.Path, "body": string(body)})
+		parts = append(parts, part{cid, http.StatusOK, string(echo)})
+	}
+	s.batches = append(s.batches, paths)
+
+	mw := multipart.NewWriter(w)
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+mw.Boundary())
+	for i := len(parts) - 1; i >= 0; i-- {
+		p := parts[i]
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "application/http")
+		h.Set("Content-ID", p.cid)
+		pw, _ := mw.CreatePart(h)
+		fmt.Fprintf(pw, "HTTP/1.1 %d %s\r\nContent-Type: application/json\r\n\r\n%s", p.status, http.StatusText(p.status), p.body)
+	}
+	mw.Close()
+}
+
+// batchEcho adds a call to b that sends a request for path, the way
+// generated calls do, and records its result in results.
+func batchEcho(b *Batch, baseURL, path, body string, results map[string]string) {
+	b.Add(func(ctx context.Context) func() {
+		var result string
+		req, err := http.NewRequest("POST", baseURL+path, strings.NewReader(body))
+		if err == nil {
+			var res *http.Response
+			res, err = SendRequest(ctx, nil, req)
+			if err == nil {
+				defer res.Body.Close()
+				err = googleapi.CheckResponse(res)
+			}
+			if err == nil {
+				var echo struct{ Path, Body string }
+				err = json.NewDecoder(res.Body).Decode(&echo)
+				result = echo.Path + " " + echo.Body
+			}
+		}
+		if err != nil {
+			result = "error: " + err.Error()
+		}
+		return func() { results[path] = result }
+	})
+}
+
+func TestBatch(t *testing.T) {
+	srv := &batchServer{}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	b := &Batch{URL: ts.URL + "/batch/api/v1", MaxSize: 2}
+	results := make(map[string]string)
+	batchEcho(b, "http://api.example.com", "/a", "1", results)
+	batchEcho(b, "http://api.example.com", "/missing", "2", results)
+	batchEcho(b, "http://api.example.com", "/c", "3", results)
+	failed := errors.New("bad call")
+	var reported []string
+	b.Add(func(ctx context.Context) func() {
+		// A call that fails before sending a request.
+		return func() { reported = append(reported, failed.Error()) }
+	})
+	if got, want := b.Len(), 4; got != want {
+		t.Fatalf("Len: got %d, want %d", got, want)
+	}
+
+	if err := b.Do(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if b.Len() != 0 {
+		t.Errorf("Len after Do: got %d, want 0", b.Len())
+	}
+	want := map[string]string{
+		"/a":       "/a 1",
+		"/missing": "error: googleapi: Error 404: not found",
+		"/c":       "/c 3",
+	}
+	for path, w := range want {
+		if got := results[path]; got != w {
+			t.Errorf("%s: got %q, want %q", path, got, w)
+		}
+	}
+	if len(reported) != 1 {
+		t.Errorf("call that failed before sending was reported %d times, want 1", len(reported))
+	}
+	if got := fmt.Sprint(srv.batches); got != "[[/a /missing] [/c]]" {
+		t.Errorf("got batches %s, want [[/a /missing] [/c]]", got)
+	}
+}
+
+func TestBatchRequestFails(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	b := &Batch{URL: ts.URL}
+	results := make(map[string]string)
+	batchEcho(b, "http://api.example.com", "/a", "", results)
+	batchEcho(b, "http://api.example.com", "/b", "", results)
+	err := b.Do(context.Background())
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok || apiErr.Code != http.StatusServiceUnavailable {
+		t.Fatalf("got error %v, want a 503 *googleapi.Error", err)
+	}
+	for _, path := range []string{"/a", "/b"} {
+		if got := results[path]; !strings.HasPrefix(got, "error: googleapi: got HTTP response code 503") {
+			t.Errorf("%s: got %q, want the batch error", path, got)
+		}
+	}
+}
+
+func TestBatchURL(t *testing.T) {
+	for _, test := range []struct {
+		basePath, want string
+	}{
+		{"https://storage.googleapis.com/storage/v1/", "https://storage.googleapis.com/batch/storage/v1"},
+		{"https://private.example.com/storage/v1/", "https://private.example.com/batch/storage/v1"},
+		{"https://private.example.com/proxy/storage/v1/", "https://private.example.com/proxy/batch/storage/v1"},
+		{"https://private.example.com/custom/", "https://private.example.com/batch/storage/v1"},
+	} {
+		if got := BatchURL(test.basePath, "storage/v1/", "batch/storage/v1"); got != test.want {
+			t.Errorf("BatchURL(%q): got %q, want %q", test.basePath, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"crypto/rand"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// randReader is the source of request IDs. Tests replace it.
+var randReader = rand.Reader
+
+// SetRequestID adds the query parameter key, such as "requestId", with a new
+// random UUID to the URL of req, unless req already has it. Servers that
+// support such a parameter ignore a request whose ID they have already seen,
+// so a request with an ID can be retried even if it is not idempotent. The ID
+// is only added to req: every attempt to send req uses the same ID, but a
+// later call of Do on the same call builds a new request and gets a new ID.
+// An ID set by the caller is kept.
+//
+// SendRequestWithRetry only retries a request whose body can be made again
+// with GetBody, so SetRequestID gives a request without a body a GetBody that
+// returns http.NoBody, making it retryable.
+func SetRequestID(req *http.Request, key string) error {
+	if req.Body == nil && req.GetBody == nil {
+		req.GetBody = func() (io.ReadCloser, error) { return http.NoBody, nil }
+	}
+	if req.URL.Query().Get(key) != "" {
+		return nil
+	}
+	id, err := newRequestID()
+	if err != nil {
+		return err
+	}
+	if req.URL.RawQuery != "" {
+		req.URL.RawQuery += "&"
+	}
+	req.URL.RawQuery += url.QueryEscape(key) + "=" + id
+	return nil
+}
+
+// newRequestID returns a random (version 4) UUID.
+func newRequestID() (string, error) {
+	var b [16]byte
+	if _, err := io.ReadFull(randReader, b[:]); err != nil {
+		return "", fmt.Errorf("gensupport: making request ID: %v", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
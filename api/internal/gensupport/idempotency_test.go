@@ -0,0 +1,113 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+var uuidRE = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestSetRequestID(t *testing.T) {
+	req, _ := http.NewRequest("POST", "https://example.com/v1/things?alt=json", nil)
+	if err := SetRequestID(req, "requestId"); err != nil {
+		t.Fatal(err)
+	}
+	id := req.URL.Query().Get("requestId")
+	if !uuidRE.MatchString(id) {
+		t.Fatalf("got request ID %q, want a version 4 UUID", id)
+	}
+	if got := req.URL.Query().Get("alt"); got != "json" {
+		t.Errorf("got alt=%q, want the other parameters kept", got)
+	}
+	// The ID is kept, so that retries use the same one.
+	if err := SetRequestID(req, "requestId"); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.URL.Query()["requestId"]; len(got) != 1 || got[0] != id {
+		t.Errorf("second SetRequestID changed the ID from %q to %q", id, got)
+	}
+	// A new request gets a new ID.
+	req2, _ := http.NewRequest("POST", "https://example.com/v1/things?alt=json", nil)
+	if err := SetRequestID(req2, "requestId"); err != nil {
+		t.Fatal(err)
+	}
+	if req2.URL.Query().Get("requestId") == id {
+		t.Errorf("two requests got the same ID %q", id)
+	}
+}
+
+func TestSetRequestIDCallerSet(t *testing.T) {
+	u := URLParams{}
+	u.Set("request_id", "mine")
+	req, _ := http.NewRequest("DELETE", "https://example.com/v1/things/1?"+u.Encode(), nil)
+	if err := SetRequestID(req, "request_id"); err != nil {
+		t.Fatal(err)
+	}
+	if got := req.URL.Query()["request_id"]; len(got) != 1 || got[0] != "mine" {
+		t.Errorf("got request IDs %q, want the caller's", got)
+	}
+}
+
+func TestSetRequestIDRandError(t *testing.T) {
+	defer func(r io.Reader) { randReader = r }(randReader)
+	randReader = &errReader{err: errors.New("no entropy")}
+	req, _ := http.NewRequest("DELETE", "https://example.com/v1/things/1", nil)
+	if err := SetRequestID(req, "requestId"); err == nil {
+		t.Fatal("got nil, want error")
+	}
+	if req.URL.RawQuery != "" {
+		t.Errorf("got query %q despite the error, want none", req.URL.RawQuery)
+	}
+}
+
+func TestSendAndRetryKeepsRequestID(t *testing.T) {
+	defer noPause()()
+	var ids []string
+	srv := &retryServer{fails: []http.HandlerFunc{
+		fail(http.StatusServiceUnavailable, "", "unavailable"),
+		fail(http.StatusBadGateway, "", "bad gateway"),
+	}}
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ids = append(ids, r.URL.Query().Get("requestId"))
+		srv.ServeHTTP(w, r)
+	}))
+	defer ts.Close()
+
+	for _, body := range []string{"", "body"} {
+		ids, srv.times = nil, nil
+		var req *http.Request
+		if body == "" {
+			req, _ = http.NewRequest("DELETE", ts.URL, nil)
+		} else {
+			req, _ = http.NewRequest("POST", ts.URL, bytes.NewBufferString(body))
+		}
+		if err := SetRequestID(req, "requestId"); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := SendRequestWithRetry(context.Background(), ts.Client(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("%s: got status %d, want 200", req.Method, resp.StatusCode)
+		}
+		if len(ids) != 3 {
+			t.Fatalf("%s: got %d requests, want 3", req.Method, len(ids))
+		}
+		if want := req.URL.Query().Get("requestId"); strings.Count(strings.Join(ids, ","), want) != 3 {
+			t.Errorf("%s: got request IDs %q, want %q each time", req.Method, ids, want)
+		}
+	}
+}
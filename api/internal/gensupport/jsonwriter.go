@@ -0,0 +1,322 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// A JSONWriter encodes a schema struct as a JSON object field by field, with
+// the same result as MarshalJSON but without reflection. Generated MarshalJSON
+// methods use it: for each field in order, they call Field, and if it returns
+// true, the method that writes the field's value.
+type JSONWriter struct {
+	forceSendFields []string
+	nullFields      []string
+	// sorted is true if either list is non-empty. MarshalJSON encodes the
+	// fields as a map then, so they are sorted by key.
+	sorted bool
+
+	buf    []byte      // the object, or the values of the fields if sorted
+	fields []jsonField // the fields in buf if sorted
+	goName string      // name of the current field
+	key    string      // key of the current field
+	err    error
+}
+
+type jsonField struct {
+	key        string
+	start, end int // value in buf
+}
+
+// NewJSONWriter returns a JSONWriter for a schema struct with the given
+// ForceSendFields and NullFields.
+func NewJSONWriter(forceSendFields, nullFields []string) *JSONWriter {
+	return &JSONWriter{
+		forceSendFields: forceSendFields,
+		nullFields:      nullFields,
+		sorted:          len(forceSendFields) > 0 || len(nullFields) > 0,
+	}
+}
+
+// Field starts the struct field named goName, whose JSON key is key, and
+// reports whether its value is to be written. empty reports whether the value
+// is empty, and isNil whether it is a nil pointer or interface. If the field
+// is in NullFields, Field writes null for it and returns false, or records an
+// error if the value is not empty.
+func (w *JSONWriter) Field(goName, key string, empty, isNil bool) bool {
+	if w.err != nil {
+		return false
+	}
+	w.goName, w.key = goName, key
+	if !w.sorted {
+		return !empty
+	}
+	if containsString(w.nullFields, goName) {
+		if !empty {
+			w.err = fmt.Errorf("field %q in NullFields has non-empty value", goName)
+			return false
+		}
+		w.raw("null")
+		return false
+	}
+	if isNil {
+		return false
+	}
+	return !empty || containsString(w.forceSendFields, goName)
+}
+
+func containsString(ss []string, s string) bool {
+	for _, x := range ss {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
+
+// begin starts writing the value of the current field, and returns where it
+// starts in buf. Fields are written to buf in order, unless they are to be
+// sorted; then their values are kept apart until Bytes.
+func (w *JSONWriter) begin() int {
+	if !w.sorted {
+		if len(w.buf) == 0 {
+			w.buf = append(w.buf, '{')
+		} else {
+			w.buf = append(w.buf, ',')
+		}
+		w.buf = appendJSONString(w.buf, w.key)
+		w.buf = append(w.buf, ':')
+	}
+	return len(w.buf)
+}
+
+// end ends the value of the current field, which started at start.
+func (w *JSONWriter) end(start int) {
+	if w.sorted {
+		w.fields = append(w.fields, jsonField{key: w.key, start: start, end: len(w.buf)})
+	}
+}
+
+func (w *JSONWriter) raw(s string) {
+	start := w.begin()
+	w.buf = append(w.buf, s...)
+	w.end(start)
+}
+
+// String writes v as the value of the current field.
+func (w *JSONWriter) String(v string) {
+	start := w.begin()
+	w.buf = appendJSONString(w.buf, v)
+	w.end(start)
+}
+
+// Bool writes v as the value of the current field.
+func (w *JSONWriter) Bool(v bool) {
+	start := w.begin()
+	w.buf = strconv.AppendBool(w.buf, v)
+	w.end(start)
+}
+
+// Int64 writes v as the value of the current field.
+func (w *JSONWriter) Int64(v int64) {
+	start := w.begin()
+	w.buf = strconv.AppendInt(w.buf, v, 10)
+	w.end(start)
+}
+
+// Int64String writes v as a string, as the value of the current field.
+func (w *JSONWriter) Int64String(v int64) {
+	start := w.begin()
+	w.buf = append(w.buf, '"')
+	w.buf = strconv.AppendInt(w.buf, v, 10)
+	w.buf = append(w.buf, '"')
+	w.end(start)
+}
+
+// Uint64 writes v as the value of the current field.
+func (w *JSONWriter) Uint64(v uint64) {
+	start := w.begin()
+	w.buf = strconv.AppendUint(w.buf, v, 10)
+	w.end(start)
+}
+
+// Uint64String writes v as a string, as the value of the current field.
+func (w *JSONWriter) Uint64String(v uint64) {
+	start := w.begin()
+	w.buf = append(w.buf, '"')
+	w.buf = strconv.AppendUint(w.buf, v, 10)
+	w.buf = append(w.buf, '"')
+	w.end(start)
+}
+
+// Float64 writes v as the value of the current field, as encoding/json does.
+// NaN and infinities are errors.
+func (w *JSONWriter) Float64(v float64) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		w.err = &json.UnsupportedValueError{Str: strconv.FormatFloat(v, 'g', -1, 64)}
+		return
+	}
+	start := w.begin()
+	format := byte('f')
+	if abs := math.Abs(v); abs != 0 && (abs < 1e-6 || abs >= 1e21) {
+		format = 'e'
+	}
+	w.buf = strconv.AppendFloat(w.buf, v, format, -1, 64)
+	if format == 'e' {
+		// Clean up e-09 to e-9.
+		n := len(w.buf)
+		if n-start >= 4 && w.buf[n-4] == 'e' && w.buf[n-3] == '-' && w.buf[n-2] == '0' {
+			w.buf[n-2] = w.buf[n-1]
+			w.buf = w.buf[:n-1]
+		}
+	}
+	w.end(start)
+}
+
+// Strings writes v as the value of the current field. A nil v is written as
+// an empty array.
+func (w *JSONWriter) Strings(v []string) {
+	start := w.begin()
+	w.buf = append(w.buf, '[')
+	for i, s := range v {
+		if i > 0 {
+			w.buf = append(w.buf, ',')
+		}
+		w.buf = appendJSONString(w.buf, s)
+	}
+	w.buf = append(w.buf, ']')
+	w.end(start)
+}
+
+// StringMap writes v as the value of the current field, with null for the
+// keys of the field that are in NullFields, like "Labels.key". A nil v is
+// written as an empty object.
+func (w *JSONWriter) StringMap(v map[string]string) {
+	var nulls []string
+	for _, nf := range w.nullFields {
+		if strings.HasPrefix(nf, w.goName+".") {
+			nulls = append(nulls, nf[len(w.goName)+1:])
+		}
+	}
+	keys := make([]string, 0, len(v)+len(nulls))
+	for k := range v {
+		keys = append(keys, k)
+	}
+	for _, k := range nulls {
+		if _, ok := v[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	start := w.begin()
+	w.buf = append(w.buf, '{')
+	for i, k := range keys {
+		if i > 0 && keys[i-1] == k {
+			continue // a key that is in NullFields twice
+		}
+		if i > 0 {
+			w.buf = append(w.buf, ',')
+		}
+		w.buf = appendJSONString(w.buf, k)
+		w.buf = append(w.buf, ':')
+		if containsString(nulls, k) {
+			w.buf = append(w.buf, "null"...)
+		} else {
+			w.buf = appendJSONString(w.buf, v[k])
+		}
+	}
+	w.buf = append(w.buf, '}')
+	w.end(start)
+}
+
+// Slice writes v, a slice, as the value of the current field. isNil reports
+// whether v is nil, in which case it is written as an empty array.
+func (w *JSONWriter) Slice(v interface{}, isNil bool) {
+	if isNil {
+		w.raw("[]")
+		return
+	}
+	w.Value(v)
+}
+
+// Map writes v, a map that is not a map[string]string, as the value of the
+// current field. isNil reports whether v is nil, in which case it is written
+// as an empty object. Keys of the field can't be in NullFields.
+func (w *JSONWriter) Map(v interface{}, isNil bool) {
+	for _, nf := range w.nullFields {
+		if strings.HasPrefix(nf, w.goName+".") {
+			w.err = fmt.Errorf("field %q has keys in NullFields but is not a map[string]string", w.goName)
+			return
+		}
+	}
+	if isNil {
+		w.raw("{}")
+		return
+	}
+	w.Value(v)
+}
+
+// Value writes the JSON encoding of v as the value of the current field.
+func (w *JSONWriter) Value(v interface{}) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		w.err = err
+		return
+	}
+	start := w.begin()
+	w.buf = append(w.buf, b...)
+	w.end(start)
+}
+
+// Bytes returns the JSON object, or the first error met.
+func (w *JSONWriter) Bytes() ([]byte, error) {
+	if w.err != nil {
+		return nil, w.err
+	}
+	if !w.sorted {
+		if len(w.buf) == 0 {
+			return []byte("{}"), nil
+		}
+		return append(w.buf, '}'), nil
+	}
+	sort.Slice(w.fields, func(i, j int) bool { return w.fields[i].key < w.fields[j].key })
+	n := 2
+	for _, f := range w.fields {
+		n += len(f.key) + 4 + f.end - f.start
+	}
+	b := make([]byte, 0, n)
+	b = append(b, '{')
+	for i, f := range w.fields {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		b = appendJSONString(b, f.key)
+		b = append(b, ':')
+		b = append(b, w.buf[f.start:f.end]...)
+	}
+	return append(b, '}'), nil
+}
+
+// appendJSONString appends the JSON encoding of s to dst. Strings that need
+// escaping are left to encoding/json, so that the result is always the same as
+// its own.
+func appendJSONString(dst []byte, s string) []byte {
+	for i := 0; i < len(s); i++ {
+		if b := s[i]; b < 0x20 || b >= utf8.RuneSelf || b == '"' || b == '\\' || b == '<' || b == '>' || b == '&' {
+			q, _ := json.Marshal(s) // never fails for a string
+			return append(dst, q...)
+		}
+	}
+	dst = append(dst, '"')
+	dst = append(dst, s...)
+	return append(dst, '"')
+}
@@ -0,0 +1,270 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+type writerChild struct {
+	Name string `json:"name,omitempty"`
+}
+
+// writerSchema has a field of each kind that generated schemas have.
+type writerSchema struct {
+	Str      string               `json:"str,omitempty"`
+	Bool     bool                 `json:"bool,omitempty"`
+	Int      int64                `json:"int,omitempty"`
+	IntStr   int64                `json:"intStr,omitempty,string"`
+	Uint     uint64               `json:"uint,omitempty,string"`
+	Float    float64              `json:"float,omitempty"`
+	PStr     *string              `json:"pStr,omitempty"`
+	PInt     *int64               `json:"pInt,omitempty,string"`
+	PFloat   *float64             `json:"pFloat,omitempty"`
+	Strs     []string             `json:"strs,omitempty"`
+	StrMap   map[string]string    `json:"strMap,omitempty"`
+	Children []*writerChild       `json:"children,omitempty"`
+	ChildMap map[string]int64     `json:"childMap,omitempty"`
+	Child    *writerChild         `json:"child,omitempty"`
+	Raw      googleapi.RawMessage `json:"raw,omitempty"`
+	Any      interface{}          `json:"any,omitempty"`
+
+	ForceSendFields []string `json:"-"`
+	NullFields      []string `json:"-"`
+}
+
+// marshal is what a generated MarshalJSON method does for writerSchema.
+func (s *writerSchema) marshal() ([]byte, error) {
+	w := NewJSONWriter(s.ForceSendFields, s.NullFields)
+	if w.Field("Str", "str", s.Str == "", false) {
+		w.String(s.Str)
+	}
+	if w.Field("Bool", "bool", !s.Bool, false) {
+		w.Bool(s.Bool)
+	}
+	if w.Field("Int", "int", s.Int == 0, false) {
+		w.Int64(s.Int)
+	}
+	if w.Field("IntStr", "intStr", s.IntStr == 0, false) {
+		w.Int64String(s.IntStr)
+	}
+	if w.Field("Uint", "uint", s.Uint == 0, false) {
+		w.Uint64String(s.Uint)
+	}
+	if w.Field("Float", "float", s.Float == 0, false) {
+		w.Float64(s.Float)
+	}
+	if w.Field("PStr", "pStr", s.PStr == nil, s.PStr == nil) {
+		w.String(*s.PStr)
+	}
+	if w.Field("PInt", "pInt", s.PInt == nil, s.PInt == nil) {
+		w.Int64String(*s.PInt)
+	}
+	if w.Field("PFloat", "pFloat", s.PFloat == nil, s.PFloat == nil) {
+		w.Float64(*s.PFloat)
+	}
+	if w.Field("Strs", "strs", len(s.Strs) == 0, false) {
+		w.Strings(s.Strs)
+	}
+	if w.Field("StrMap", "strMap", len(s.StrMap) == 0, false) {
+		w.StringMap(s.StrMap)
+	}
+	if w.Field("Children", "children", len(s.Children) == 0, false) {
+		w.Slice(s.Children, s.Children == nil)
+	}
+	if w.Field("ChildMap", "childMap", len(s.ChildMap) == 0, false) {
+		w.Map(s.ChildMap, s.ChildMap == nil)
+	}
+	if w.Field("Child", "child", s.Child == nil, s.Child == nil) {
+		w.Value(s.Child)
+	}
+	if w.Field("Raw", "raw", len(s.Raw) == 0, false) {
+		w.Slice(s.Raw, s.Raw == nil)
+	}
+	if w.Field("Any", "any", s.Any == nil, s.Any == nil) {
+		w.Value(s.Any)
+	}
+	return w.Bytes()
+}
+
+func (s *writerSchema) reflective() ([]byte, error) {
+	return MarshalJSON(*s, s.ForceSendFields, s.NullFields)
+}
+
+func strPtr(s string) *string     { return &s }
+func int64Ptr(i int64) *int64     { return &i }
+func floatPtr(f float64) *float64 { return &f }
+
+func TestJSONWriterCases(t *testing.T) {
+	for _, s := range []*writerSchema{
+		{},
+		{Str: "a", Bool: true, Int: -3, IntStr: 1 << 60, Uint: math.MaxUint64, Float: 1.5},
+		{Str: "<html> & \"quotes\" \\ \n\t\x01 \u2028 é \xff"},
+		{Float: 1e21}, {Float: 1e-7}, {Float: -0.000001}, {Float: 123456789.125},
+		{PStr: strPtr(""), PInt: int64Ptr(0), PFloat: floatPtr(0)},
+		{Strs: []string{"a", "<b>"}, StrMap: map[string]string{"z": "1", "a": "2"}},
+		{Children: []*writerChild{{Name: "c"}, nil}, ChildMap: map[string]int64{"b": 1, "a": 2}, Child: &writerChild{}},
+		{Raw: googleapi.RawMessage(`{"x": [1, 2]}`), Any: map[string]interface{}{"k": []int{1}}},
+		{ForceSendFields: []string{"Str", "Bool", "Int", "IntStr", "Uint", "Float", "PStr", "Strs", "StrMap", "Children", "ChildMap", "Child", "Raw", "Any"}},
+		{NullFields: []string{"Str", "Bool", "PStr", "Strs", "StrMap", "Child", "Any"}},
+		{NullFields: []string{"StrMap.a", "StrMap.b", "StrMap.a"}, StrMap: map[string]string{"a": "x", "c": "y"}},
+		{NullFields: []string{"StrMap.a"}, ForceSendFields: []string{"StrMap"}},
+		{Str: "x", NullFields: []string{"Str"}},
+		{ChildMap: map[string]int64{"a": 1}, NullFields: []string{"ChildMap.a"}},
+		{Float: math.NaN()},
+		{Float: math.Inf(1), ForceSendFields: []string{"Int"}},
+		{Raw: googleapi.RawMessage(`{`)},
+	} {
+		checkJSONWriter(t, s)
+	}
+}
+
+// randomValue returns a random value of type t, which may be empty.
+func randomValue(t reflect.Type, r *rand.Rand, depth int) reflect.Value {
+	v := reflect.New(t).Elem()
+	if r.Intn(4) == 0 || depth > 3 {
+		return v
+	}
+	switch t.Kind() {
+	case reflect.String:
+		v.SetString([]string{"", "a", "<b>&", "\u00e9\n", "\xff"}[r.Intn(5)])
+	case reflect.Bool:
+		v.SetBool(r.Intn(2) == 0)
+	case reflect.Int64:
+		v.SetInt(r.Int63() >> uint(r.Intn(64)) * int64(1-2*r.Intn(2)))
+	case reflect.Uint64:
+		v.SetUint(r.Uint64() >> uint(r.Intn(64)))
+	case reflect.Float64:
+		v.SetFloat([]float64{0, 1, -1.5, 1e21, 1e-7, r.NormFloat64() * 1e6, math.NaN()}[r.Intn(7)])
+	case reflect.Ptr:
+		v.Set(reflect.New(t.Elem()))
+		v.Elem().Set(randomValue(t.Elem(), r, depth+1))
+	case reflect.Slice:
+		if t == reflect.TypeOf(googleapi.RawMessage{}) {
+			v.SetBytes([]byte([]string{"", `"raw"`, `{"a": [1]}`, `{`}[r.Intn(4)]))
+			break
+		}
+		n := r.Intn(3)
+		v.Set(reflect.MakeSlice(t, n, n))
+		for i := 0; i < n; i++ {
+			v.Index(i).Set(randomValue(t.Elem(), r, depth+1))
+		}
+	case reflect.Map:
+		v.Set(reflect.MakeMap(t))
+		for i := r.Intn(3); i > 0; i-- {
+			v.SetMapIndex(randomValue(t.Key(), r, depth+1), randomValue(t.Elem(), r, depth+1))
+		}
+	case reflect.Interface:
+		v.Set(reflect.ValueOf([]interface{}{"x", 1.5, []interface{}{true, nil}}[r.Intn(3)]))
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath == "" && t.Field(i).Tag.Get("json") != "-" {
+				v.Field(i).Set(randomValue(t.Field(i).Type, r, depth+1))
+			}
+		}
+	}
+	return v
+}
+
+// randomFieldLists sets the ForceSendFields and NullFields of the struct s to
+// random field names, which may also name the keys of map fields. Fields in
+// NullFields are usually made empty.
+func randomFieldLists(s reflect.Value, r *rand.Rand) {
+	var force, null []string
+	for i := 0; i < s.NumField(); i++ {
+		f := s.Type().Field(i)
+		if f.PkgPath != "" || f.Tag.Get("json") == "-" {
+			continue
+		}
+		switch r.Intn(6) {
+		case 0:
+			force = append(force, f.Name)
+		case 1:
+			if r.Intn(8) > 0 {
+				s.Field(i).Set(reflect.Zero(f.Type))
+			}
+			null = append(null, f.Name)
+		case 2:
+			null = append(null, f.Name+".a")
+		}
+	}
+	s.FieldByName("ForceSendFields").Set(reflect.ValueOf(force))
+	s.FieldByName("NullFields").Set(reflect.ValueOf(null))
+}
+
+// randomWriterSchema returns a writerSchema with random fields, and random
+// ForceSendFields and NullFields.
+func randomWriterSchema(r *rand.Rand) *writerSchema {
+	s := new(writerSchema)
+	v := reflect.ValueOf(s).Elem()
+	v.Set(randomValue(v.Type(), r, -100))
+	randomFieldLists(v, r)
+	return s
+}
+
+func TestJSONWriterRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 2000; i++ {
+		checkJSONWriter(t, randomWriterSchema(r))
+	}
+}
+
+func checkJSONWriter(t *testing.T, s *writerSchema) {
+	t.Helper()
+	want, wantErr := s.reflective()
+	got, gotErr := s.marshal()
+	if (gotErr != nil) != (wantErr != nil) {
+		t.Errorf("%+v: got error %v, want %v", s, gotErr, wantErr)
+		return
+	}
+	if string(got) != string(want) {
+		t.Errorf("%+v:\ngot  %s\nwant %s", s, got, want)
+	}
+}
+
+// benchmarkWriterSchema returns a writerSchema with typical fields set.
+func benchmarkWriterSchema(force bool) *writerSchema {
+	s := &writerSchema{
+		Str:    "projects/my-project/instances/my-instance",
+		Int:    42,
+		IntStr: 1 << 40,
+		PStr:   strPtr("display name"),
+		Strs:   []string{"a", "b", "c"},
+		StrMap: map[string]string{"env": "prod", "team": "storage"},
+		Child:  &writerChild{Name: "child"},
+	}
+	if force {
+		s.ForceSendFields = []string{"Bool"}
+	}
+	return s
+}
+
+func BenchmarkMarshalJSON(b *testing.B) {
+	for _, force := range []bool{false, true} {
+		s := benchmarkWriterSchema(force)
+		b.Run(fmt.Sprintf("ForceSendFields=%t/reflective", force), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.reflective(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+		b.Run(fmt.Sprintf("ForceSendFields=%t/writer", force), func(b *testing.B) {
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				if _, err := s.marshal(); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+)
+
+// ReadModifyWriteAttempts is the number of times ReadModifyWrite tries to
+// update a resource before it gives up.
+const ReadModifyWriteAttempts = 5
+
+// modifyBackoff is declared as a global variable so that tests can overwrite
+// it.
+var modifyBackoff = func() Backoff {
+	return &gax.Backoff{Initial: 100 * time.Millisecond, Max: 2 * time.Second}
+}
+
+// ReadModifyWrite updates a resource that may be changed concurrently. It
+// calls read to fetch the resource, modify to change it, and write to send
+// the change on condition that the resource is unchanged since it was read,
+// for instance by its ETag. If write fails with 409 Conflict or 412
+// Precondition Failed, ReadModifyWrite starts over after a pause, up to
+// ReadModifyWriteAttempts times in all. Errors from read and modify, and
+// other errors from write, are returned at once.
+func ReadModifyWrite(ctx context.Context, read, modify, write func() error) error {
+	bo := modifyBackoff()
+	for attempt := 1; ; attempt++ {
+		if err := read(); err != nil {
+			return err
+		}
+		if err := modify(); err != nil {
+			return err
+		}
+		err := write()
+		if err == nil || attempt == ReadModifyWriteAttempts || !isConflict(err) {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t := time.NewTimer(bo.Pause())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// isConflict reports whether err means that a conditional update failed
+// because the resource was changed.
+func isConflict(err error) bool {
+	apiErr, ok := err.(*googleapi.Error)
+	return ok && (apiErr.Code == http.StatusConflict || apiErr.Code == http.StatusPreconditionFailed)
+}
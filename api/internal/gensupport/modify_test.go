@@ -0,0 +1,81 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func noModifyPause() func() {
+	old := modifyBackoff
+	modifyBackoff = func() Backoff { return new(NoPauseBackoff) }
+	return func() { modifyBackoff = old }
+}
+
+func TestReadModifyWrite(t *testing.T) {
+	defer noModifyPause()()
+	conflict := &googleapi.Error{Code: http.StatusConflict}
+	precondition := &googleapi.Error{Code: http.StatusPreconditionFailed}
+	forbidden := &googleapi.Error{Code: http.StatusForbidden}
+	errModify := errors.New("modify")
+	allConflicts := make([]error, ReadModifyWriteAttempts)
+	for i := range allConflicts {
+		allConflicts[i] = conflict
+	}
+	for _, test := range []struct {
+		desc       string
+		writes     []error
+		modifyErr  error
+		wantErr    error
+		wantWrites int
+	}{
+		{"success", []error{nil}, nil, nil, 1},
+		{"conflicts", []error{conflict, precondition, nil}, nil, nil, 3},
+		{"other error", []error{conflict, forbidden, nil}, nil, forbidden, 2},
+		{"too many conflicts", allConflicts, nil, conflict, ReadModifyWriteAttempts},
+		{"modify error", []error{nil}, errModify, errModify, 0},
+	} {
+		reads, writes := 0, 0
+		err := ReadModifyWrite(context.Background(),
+			func() error { reads++; return nil },
+			func() error { return test.modifyErr },
+			func() error {
+				err := test.writes[writes]
+				writes++
+				return err
+			})
+		if err != test.wantErr {
+			t.Errorf("%s: got error %v, want %v", test.desc, err, test.wantErr)
+		}
+		if writes != test.wantWrites {
+			t.Errorf("%s: wrote %d times, want %d", test.desc, writes, test.wantWrites)
+		}
+		if test.modifyErr == nil && reads != writes {
+			t.Errorf("%s: read %d times, want %d", test.desc, reads, writes)
+		}
+	}
+}
+
+func TestReadModifyWriteContextDone(t *testing.T) {
+	defer noModifyPause()()
+	ctx, cancel := context.WithCancel(context.Background())
+	writes := 0
+	err := ReadModifyWrite(ctx,
+		func() error { return nil },
+		func() error { return nil },
+		func() error {
+			writes++
+			cancel()
+			return &googleapi.Error{Code: http.StatusConflict}
+		})
+	if err != context.Canceled || writes != 1 {
+		t.Errorf("got error %v after %d writes, want %v after 1", err, writes, context.Canceled)
+	}
+}
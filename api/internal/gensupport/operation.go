@@ -0,0 +1,107 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	gax "github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/googleapi/grpcstatus"
+	"google.golang.org/grpc/codes"
+)
+
+// operationBackoff is declared as a global variable so that tests can
+// overwrite it.
+var operationBackoff = func() Backoff {
+	return &gax.Backoff{Initial: time.Second, Max: 30 * time.Second}
+}
+
+// WaitOperation calls poll until it reports that a long-running operation is
+// done, pausing with exponential backoff between calls. An error from poll is
+// returned, unless it is one that a request would be retried after. If ctx is
+// done first, WaitOperation returns ctx.Err().
+func WaitOperation(ctx context.Context, poll func() (done bool, err error)) error {
+	bo := operationBackoff()
+	for {
+		done, err := poll()
+		if err == nil && done {
+			return nil
+		}
+		if err != nil {
+			status := 0
+			if apiErr, ok := err.(*googleapi.Error); ok {
+				status = apiErr.Code
+			}
+			if !shouldRetry(status, err) {
+				return err
+			}
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		t := time.NewTimer(bo.Pause())
+		select {
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		case <-t.C:
+		}
+	}
+}
+
+// OperationError returns the error of a failed long-running operation, given
+// the code, message and details of its google.rpc.Status. The result is a
+// *googleapi.Error whose Code is the HTTP status code that corresponds to
+// code, and whose typed error details are decoded from details.
+func OperationError(code int64, message string, details []googleapi.RawMessage) error {
+	status := grpcstatus.HTTPStatus(codes.Code(code))
+	body, err := json.Marshal(map[string]interface{}{
+		"error": map[string]interface{}{
+			"code":    status,
+			"message": message,
+			"details": details,
+		},
+	})
+	if err != nil {
+		return &googleapi.Error{Code: status, Message: message}
+	}
+	res := &http.Response{
+		StatusCode: status,
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+	}
+	err = googleapi.CheckResponse(res)
+	if apiErr, ok := err.(*googleapi.Error); ok {
+		// The body was made up here, not returned by a server.
+		apiErr.Body = ""
+	}
+	return err
+}
+
+// PathSegmentAfter returns the path segment that follows the segment
+// collection in the URL or resource name u, or "" if there is none. For
+// example, the project ID of an operation can be found with
+// PathSegmentAfter(op.SelfLink, "projects").
+func PathSegmentAfter(u, collection string) string {
+	segs := strings.Split(u, "/")
+	for i := 0; i+1 < len(segs); i++ {
+		if segs[i] == collection {
+			return segs[i+1]
+		}
+	}
+	return ""
+}
+
+// LastPathSegment returns the last path segment of the URL or resource name
+// u. It returns u itself if u contains no slashes.
+func LastPathSegment(u string) string {
+	return u[strings.LastIndex(u, "/")+1:]
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func noOperationPause() func() {
+	old := operationBackoff
+	operationBackoff = func() Backoff { return new(NoPauseBackoff) }
+	return func() { operationBackoff = old }
+}
+
+// errDone stands for a poll that reports the operation is done.
+var errDone = errors.New("done")
+
+func TestWaitOperation(t *testing.T) {
+	defer noOperationPause()()
+	transient := &googleapi.Error{Code: http.StatusServiceUnavailable}
+	permanent := &googleapi.Error{Code: http.StatusForbidden}
+	for _, test := range []struct {
+		desc      string
+		results   []error // nil means not done yet, errDone means done
+		wantErr   error
+		wantPolls int
+	}{
+		{"done at once", []error{errDone}, nil, 1},
+		{"done later", []error{nil, nil, errDone}, nil, 3},
+		{"transient error", []error{nil, transient, errDone}, nil, 3},
+		{"permanent error", []error{nil, permanent, errDone}, permanent, 2},
+	} {
+		polls := 0
+		err := WaitOperation(context.Background(), func() (bool, error) {
+			r := test.results[polls]
+			polls++
+			if r == errDone {
+				return true, nil
+			}
+			return false, r
+		})
+		if err != test.wantErr {
+			t.Errorf("%s: got error %v, want %v", test.desc, err, test.wantErr)
+		}
+		if polls != test.wantPolls {
+			t.Errorf("%s: polled %d times, want %d", test.desc, polls, test.wantPolls)
+		}
+	}
+}
+
+func TestWaitOperationContextDone(t *testing.T) {
+	defer noOperationPause()()
+	ctx, cancel := context.WithCancel(context.Background())
+	polls := 0
+	err := WaitOperation(ctx, func() (bool, error) {
+		polls++
+		if polls == 2 {
+			cancel()
+		}
+		return false, nil
+	})
+	if err != context.Canceled {
+		t.Errorf("got error %v, want %v", err, context.Canceled)
+	}
+	if polls != 2 {
+		t.Errorf("polled %d times, want 2", polls)
+	}
+}
+
+func TestOperationError(t *testing.T) {
+	details := []googleapi.RawMessage{
+		googleapi.RawMessage(`{"@type": "type.googleapis.com/google.rpc.ErrorInfo", "reason": "QUOTA", "domain": "example.com"}`),
+	}
+	err := OperationError(8, "out of quota", details)
+	apiErr, ok := err.(*googleapi.Error)
+	if !ok {
+		t.Fatalf("got %T, want *googleapi.Error", err)
+	}
+	if apiErr.Code != http.StatusTooManyRequests {
+		t.Errorf("Code: got %d, want %d", apiErr.Code, http.StatusTooManyRequests)
+	}
+	if apiErr.Message != "out of quota" {
+		t.Errorf("Message: got %q, want %q", apiErr.Message, "out of quota")
+	}
+	if apiErr.Body != "" {
+		t.Errorf("Body: got %q, want empty", apiErr.Body)
+	}
+	if apiErr.Reason() != "QUOTA" || apiErr.Domain() != "example.com" {
+		t.Errorf("got reason %q and domain %q, want QUOTA and example.com", apiErr.Reason(), apiErr.Domain())
+	}
+}
+
+func TestPathSegments(t *testing.T) {
+	const selfLink = "https://www.googleapis.com/compute/v1/projects/p1/zones/us-central1-a/operations/op1"
+	for _, test := range []struct {
+		got, want string
+	}{
+		{PathSegmentAfter(selfLink, "projects"), "p1"},
+		{PathSegmentAfter(selfLink, "zones"), "us-central1-a"},
+		{PathSegmentAfter(selfLink, "regions"), ""},
+		{PathSegmentAfter("projects", "projects"), ""},
+		{LastPathSegment("https://www.googleapis.com/compute/v1/projects/p1/zones/us-central1-a"), "us-central1-a"},
+		{LastPathSegment("us-central1-a"), "us-central1-a"},
+		{LastPathSegment(""), ""},
+	} {
+		if test.got != test.want {
+			t.Errorf("got %q, want %q", test.got, test.want)
+		}
+	}
+}
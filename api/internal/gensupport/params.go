@@ -8,6 +8,7 @@ import (
 	"net/url"
 
 	"google.golang.org/api/googleapi"
+	"google.golang.org/api/internal/callopt"
 )
 
 // URLParams is a simplified replacement for url.Values
@@ -38,9 +39,17 @@ func (u URLParams) SetMulti(key string, values []string) {
 }
 
 // Encode encodes the values into ``URL encoded'' form
-// ("bar=baz&foo=quux") sorted by key.
+// ("bar=baz&foo=quux") sorted by key. Keys that hold call options for the
+// client, such as googleapi.SkipValidation, are left out, so u should only
+// be sent in this form.
 func (u URLParams) Encode() string {
-	return url.Values(u).Encode()
+	v := make(url.Values, len(u))
+	for k, vs := range u {
+		if !callopt.IsClientOnly(k) {
+			v[k] = vs
+		}
+	}
+	return v.Encode()
 }
 
 // SetOptions sets the URL params and any additional call options.
@@ -0,0 +1,30 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"testing"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/internal/callopt"
+)
+
+func TestEncodeClientOnlyOptions(t *testing.T) {
+	u := URLParams{}
+	SetOptions(u,
+		googleapi.QuotaUser("u"),
+		googleapi.SkipValidation(),
+		googleapi.RawDownload(),
+		googleapi.RetryBuffer(10),
+	)
+	for _, name := range []string{callopt.SkipValidation, callopt.RawDownload, callopt.RetryBuffer} {
+		if u.Get(name) == "" {
+			t.Errorf("option %s not set", name)
+		}
+	}
+	if got, want := u.Encode(), "quotaUser=u"; got != want {
+		t.Errorf("Encode() = %q, want %q", got, want)
+	}
+}
@@ -183,19 +183,25 @@ func (rx *ResumableUpload) Upload(ctx context.Context) (resp *http.Response, err
 
 		// Each chunk gets its own initialized-at-zero retry.
 		bo := backoff()
+		deadline := time.Now().Add(retryDeadline)
 		quitAfter := time.After(retryDeadline)
 
 		// Retry loop for a single chunk.
 		for {
+			t := time.NewTimer(pause)
 			select {
 			case <-ctx.Done():
+				t.Stop()
+			case <-t.C:
+			case <-quitAfter:
+				t.Stop()
+				return prepareReturn(resp, err)
+			}
+			if cerr := contextErr(ctx); cerr != nil {
 				if err == nil {
-					err = ctx.Err()
+					err = cerr
 				}
 				return prepareReturn(resp, err)
-			case <-time.After(pause):
-			case <-quitAfter:
-				return prepareReturn(resp, err)
 			}
 
 			resp, err = rx.transferChunk(ctx)
@@ -209,8 +215,15 @@ func (rx *ResumableUpload) Upload(ctx context.Context) (resp *http.Response, err
 			if !shouldRetry(status, err) {
 				break
 			}
+			// Wait at least as long as the server asked, but no longer
+			// than the deadline of ctx. If the wait would end after the
+			// retry deadline of the chunk, its response is returned.
+			var ok bool
+			if pause, ok = retryPause(ctx, bo, resp, deadline); !ok {
+				break
+			}
 
-			pause = bo.Pause()
+			callRetryHooks(ctx, rx.Client, resp, err, pause)
 			if resp != nil && resp.Body != nil {
 				resp.Body.Close()
 			}
@@ -0,0 +1,114 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"google.golang.org/api/googleapi"
+)
+
+// maxRetryInfoBody is the most of an error response body that is read to look
+// for a RetryInfo detail.
+const maxRetryInfoBody = 1 << 20
+
+// retryObserver is implemented by the http.RoundTripper of clients created
+// with option.WithRetryHook or option.WithRetryLogger.
+type retryObserver interface {
+	// ObserveRetry is called before a request is retried. resp and err are
+	// the result of the attempt that failed, and delay is how long the retry
+	// will be delayed. The body of resp is closed after ObserveRetry returns.
+	ObserveRetry(ctx context.Context, resp *http.Response, err error, delay time.Duration)
+}
+
+// retryPause returns how long to wait before retrying after resp: the next
+// backoff pause, or longer if the server asked for it with a Retry-After
+// header or a RetryInfo error detail. A delay asked for by the server is
+// clamped to the deadline of ctx: the wait then ends with the deadline, and
+// the call fails with the context's error. It returns false if the delay
+// ends after giveUp, a time past which the caller stops retrying anyway, so
+// that the last response can be returned as it is. A zero giveUp is ignored.
+func retryPause(ctx context.Context, bo Backoff, resp *http.Response, giveUp time.Time) (time.Duration, bool) {
+	pause := bo.Pause()
+	d := serverRetryDelay(resp)
+	if d <= pause {
+		return pause, true
+	}
+	end := time.Now().Add(d)
+	if dl, ok := ctx.Deadline(); ok && end.After(dl) && (giveUp.IsZero() || !dl.After(giveUp)) {
+		return time.Until(dl), true
+	}
+	if !giveUp.IsZero() && end.After(giveUp) {
+		return 0, false
+	}
+	return d, true
+}
+
+// contextErr returns the error of ctx, or context.DeadlineExceeded once the
+// deadline of ctx has passed, which may be just before ctx is done. A pause
+// clamped to the deadline by retryPause thus always ends the retry loop.
+func contextErr(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if dl, ok := ctx.Deadline(); ok && !time.Now().Before(dl) {
+		return context.DeadlineExceeded
+	}
+	return nil
+}
+
+// serverRetryDelay returns how long the server asked clients to wait before
+// retrying, or 0. The longer of the Retry-After header and the RetryInfo
+// detail of a JSON error body is used. The body of resp can still be read
+// afterwards.
+func serverRetryDelay(resp *http.Response) time.Duration {
+	if resp == nil {
+		return 0
+	}
+	var delay time.Duration
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if secs, err := strconv.Atoi(v); err == nil && secs > 0 {
+			delay = time.Duration(secs) * time.Second
+		} else if t, err := http.ParseTime(v); err == nil {
+			delay = time.Until(t)
+		}
+	}
+	if resp.Body == nil || resp.StatusCode < 400 {
+		return delay
+	}
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxRetryInfoBody))
+	resp.Body = readCloser{io.MultiReader(bytes.NewReader(b), resp.Body), resp.Body}
+	if err != nil {
+		return delay
+	}
+	errResp := &http.Response{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       ioutil.NopCloser(bytes.NewReader(b)),
+	}
+	if apiErr, ok := googleapi.CheckResponse(errResp).(*googleapi.Error); ok && apiErr.RetryDelay() > delay {
+		delay = apiErr.RetryDelay()
+	}
+	return delay
+}
+
+type readCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// callRetryHooks tells the transport of client, if it asks to be told, that a
+// request is about to be retried.
+func callRetryHooks(ctx context.Context, client *http.Client, resp *http.Response, err error, delay time.Duration) {
+	if o, ok := client.Transport.(retryObserver); ok {
+		o.ObserveRetry(ctx, resp, err, delay)
+	}
+}
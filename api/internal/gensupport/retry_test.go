@@ -0,0 +1,271 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func retryInfoBody(delay string) string {
+	return `{"error": {"code": 429, "message": "slow down", "details": [{"@type": "type.googleapis.com/google.rpc.RetryInfo", "retryDelay": "` + delay + `"}]}}`
+}
+
+func TestServerRetryDelay(t *testing.T) {
+	for _, tc := range []struct {
+		desc       string
+		status     int
+		retryAfter string
+		body       string
+		want       time.Duration
+	}{
+		{desc: "nothing", status: 503, body: "oops"},
+		{desc: "Retry-After seconds", status: 429, retryAfter: "3", want: 3 * time.Second},
+		{desc: "Retry-After date", status: 503, retryAfter: time.Now().Add(time.Hour).UTC().Format(http.TimeFormat), want: time.Hour},
+		{desc: "Retry-After invalid", status: 503, retryAfter: "soon"},
+		{desc: "RetryInfo", status: 429, body: retryInfoBody("1.5s"), want: 1500 * time.Millisecond},
+		{desc: "longer of both", status: 429, retryAfter: "2", body: retryInfoBody("1.5s"), want: 2 * time.Second},
+		{desc: "RetryInfo ignored on success", status: 200, body: retryInfoBody("1.5s")},
+	} {
+		resp := &http.Response{
+			StatusCode: tc.status,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(strings.NewReader(tc.body)),
+		}
+		if tc.retryAfter != "" {
+			resp.Header.Set("Retry-After", tc.retryAfter)
+		}
+		got := serverRetryDelay(resp)
+		// Allow for the time passing while an HTTP date is used.
+		if got > tc.want || got < tc.want-time.Minute || (tc.want == 0 && got != 0) {
+			t.Errorf("%s: got %v, want %v", tc.desc, got, tc.want)
+		}
+		if b, err := ioutil.ReadAll(resp.Body); err != nil || string(b) != tc.body {
+			t.Errorf("%s: body after serverRetryDelay: got %q, %v, want %q", tc.desc, b, err, tc.body)
+		}
+	}
+}
+
+// retryServer replies to the first len(fails) requests with the given
+// handlers and to the rest with 200 OK.
+type retryServer struct {
+	mu    sync.Mutex
+	fails []http.HandlerFunc
+	times []time.Time
+}
+
+func (s *retryServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	n := len(s.times)
+	s.times = append(s.times, time.Now())
+	s.mu.Unlock()
+	if n < len(s.fails) {
+		s.fails[n](w, r)
+		return
+	}
+	w.Write([]byte(`{}`))
+}
+
+func fail(status int, retryAfter, body string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if retryAfter != "" {
+			w.Header().Set("Retry-After", retryAfter)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(body))
+	}
+}
+
+// recordingTransport records the delays of the retries it is told about.
+type recordingTransport struct {
+	base   http.RoundTripper
+	delays []time.Duration
+}
+
+func (t *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req)
+}
+
+func (t *recordingTransport) ObserveRetry(ctx context.Context, resp *http.Response, err error, delay time.Duration) {
+	t.delays = append(t.delays, delay)
+}
+
+// recordingClient returns a client for ts that records retry delays.
+func recordingClient(ts *httptest.Server) (*http.Client, *recordingTransport) {
+	rt := &recordingTransport{base: ts.Client().Transport}
+	return &http.Client{Transport: rt}, rt
+}
+
+// noPause makes the backoff pauses zero, and returns a function that restores
+// them.
+func noPause() func() {
+	old := backoff
+	backoff = func() Backoff { return new(NoPauseBackoff) }
+	return func() { backoff = old }
+}
+
+func TestSendAndRetryHonorsRetryInfo(t *testing.T) {
+	defer noPause()()
+	srv := &retryServer{fails: []http.HandlerFunc{
+		fail(http.StatusTooManyRequests, "", retryInfoBody("0.2s")),
+		fail(http.StatusServiceUnavailable, "", "unavailable"),
+	}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	client, rt := recordingClient(ts)
+
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader("body"))
+	resp, err := SendRequestWithRetry(context.Background(), client, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if len(srv.times) != 3 {
+		t.Fatalf("got %d requests, want 3", len(srv.times))
+	}
+	if got := srv.times[1].Sub(srv.times[0]); got < 200*time.Millisecond {
+		t.Errorf("retried after %v, want at least 200ms", got)
+	}
+	if want := []time.Duration{200 * time.Millisecond, 0}; len(rt.delays) != 2 || rt.delays[0] != want[0] || rt.delays[1] != want[1] {
+		t.Errorf("retry hook got delays %v, want %v", rt.delays, want)
+	}
+}
+
+func TestSendAndRetryRetryAfterPastDeadline(t *testing.T) {
+	defer noPause()()
+	srv := &retryServer{fails: []http.HandlerFunc{
+		fail(http.StatusTooManyRequests, "30", "rate limited"),
+	}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	client, rt := recordingClient(ts)
+
+	const timeout = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	req, _ := http.NewRequest("POST", ts.URL, strings.NewReader("body"))
+	start := time.Now()
+	_, err := SendRequestWithRetry(ctx, client, req)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	// The delay is clamped to the deadline, which is waited for.
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > 10*time.Second {
+		t.Errorf("took %v, want about %v", elapsed, timeout)
+	}
+	if len(rt.delays) != 1 || rt.delays[0] <= 0 || rt.delays[0] > timeout {
+		t.Errorf("got retry delays %v, want one of at most %v", rt.delays, timeout)
+	}
+	if len(srv.times) != 1 {
+		t.Errorf("got %d requests, want 1", len(srv.times))
+	}
+}
+
+func TestUploadHonorsRetryInfo(t *testing.T) {
+	defer noPause()()
+	srv := &retryServer{fails: []http.HandlerFunc{
+		fail(http.StatusServiceUnavailable, "", retryInfoBody("0.2s")),
+	}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	client, rt := recordingClient(ts)
+
+	rx := &ResumableUpload{
+		Client:    client,
+		URI:       ts.URL,
+		Media:     NewMediaBuffer(strings.NewReader("data"), 10),
+		MediaType: "text/plain",
+	}
+	resp, err := rx.Upload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("got status %d, want 200", resp.StatusCode)
+	}
+	if len(srv.times) != 2 {
+		t.Fatalf("got %d requests, want 2", len(srv.times))
+	}
+	if got := srv.times[1].Sub(srv.times[0]); got < 200*time.Millisecond {
+		t.Errorf("retried after %v, want at least 200ms", got)
+	}
+	if len(rt.delays) != 1 || rt.delays[0] != 200*time.Millisecond {
+		t.Errorf("retry hook got delays %v, want [200ms]", rt.delays)
+	}
+}
+
+func TestUploadRetryAfterPastContextDeadline(t *testing.T) {
+	defer noPause()()
+	srv := &retryServer{fails: []http.HandlerFunc{
+		fail(http.StatusTooManyRequests, "30", "rate limited"),
+	}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+	client, rt := recordingClient(ts)
+
+	rx := &ResumableUpload{
+		Client:    client,
+		URI:       ts.URL,
+		Media:     NewMediaBuffer(strings.NewReader("data"), 10),
+		MediaType: "text/plain",
+	}
+	const timeout = 300 * time.Millisecond
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	start := time.Now()
+	_, err := rx.Upload(ctx)
+	if err != context.DeadlineExceeded {
+		t.Errorf("got error %v, want %v", err, context.DeadlineExceeded)
+	}
+	if elapsed := time.Since(start); elapsed < timeout || elapsed > 10*time.Second {
+		t.Errorf("took %v, want about %v", elapsed, timeout)
+	}
+	if len(rt.delays) != 1 || rt.delays[0] <= 0 || rt.delays[0] > timeout {
+		t.Errorf("got retry delays %v, want one of at most %v", rt.delays, timeout)
+	}
+	if len(srv.times) != 1 {
+		t.Errorf("got %d requests, want 1", len(srv.times))
+	}
+}
+
+func TestUploadRetryAfterPastRetryDeadline(t *testing.T) {
+	defer noPause()()
+	old := retryDeadline
+	retryDeadline = time.Second
+	defer func() { retryDeadline = old }()
+	srv := &retryServer{fails: []http.HandlerFunc{
+		fail(http.StatusTooManyRequests, "10", "rate limited"),
+	}}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	rx := &ResumableUpload{
+		Client:    ts.Client(),
+		URI:       ts.URL,
+		Media:     NewMediaBuffer(strings.NewReader("data"), 10),
+		MediaType: "text/plain",
+	}
+	resp, err := rx.Upload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Errorf("got status %d, want 429", resp.StatusCode)
+	}
+	if len(srv.times) != 1 {
+		t.Errorf("got %d requests, want 1", len(srv.times))
+	}
+}
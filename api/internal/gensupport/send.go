@@ -10,6 +10,8 @@ import (
 	"errors"
 	"net/http"
 	"time"
+
+	"google.golang.org/api/internal/callopt"
 )
 
 // Hook is the type of a function that is called once before each HTTP request
@@ -29,15 +31,52 @@ func RegisterHook(h Hook) {
 	hooks = append(hooks, h)
 }
 
+// rawDownloadEncoding is the Accept-Encoding of raw downloads.
+const rawDownloadEncoding = "gzip"
+
+// rawDownloadKey is the context key that marks a raw download request.
+type rawDownloadKey struct{}
+
+// checkAcceptEncoding disallows Accept-Encoding because it interferes with the
+// automatic gzip handling done by the default http.Transport. See
+// https://github.com/google/google-api-go-client/issues/219.
+func checkAcceptEncoding(req *http.Request) error {
+	if _, ok := req.Header["Accept-Encoding"]; ok {
+		return errors.New("google api: custom Accept-Encoding headers not allowed")
+	}
+	return nil
+}
+
+// SetRawDownload returns req marked as a raw download if the call option
+// googleapi.RawDownload is in u, else req. SendRequest asks for a compressed
+// body for such a request, and the http.Transport then returns the body as
+// sent instead of decompressing it.
+func SetRawDownload(req *http.Request, u URLParams) *http.Request {
+	if u.Get(callopt.RawDownload) == "" {
+		return req
+	}
+	return req.WithContext(context.WithValue(req.Context(), rawDownloadKey{}, true))
+}
+
+// prepareRequest checks the headers of req, and sets the Accept-Encoding
+// header of a raw download.
+func prepareRequest(req *http.Request) error {
+	if err := checkAcceptEncoding(req); err != nil {
+		return err
+	}
+	if req.Context().Value(rawDownloadKey{}) != nil {
+		req.Header.Set("Accept-Encoding", rawDownloadEncoding)
+	}
+	return nil
+}
+
 // SendRequest sends a single HTTP request using the given client.
 // If ctx is non-nil, it calls all hooks, then sends the request with
 // req.WithContext, then calls any functions returned by the hooks in
 // reverse order.
 func SendRequest(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
-	// Disallow Accept-Encoding because it interferes with the automatic gzip handling
-	// done by the default http.Transport. See https://github.com/google/google-api-go-client/issues/219.
-	if _, ok := req.Header["Accept-Encoding"]; ok {
-		return nil, errors.New("google api: custom Accept-Encoding headers not allowed")
+	if err := prepareRequest(req); err != nil {
+		return nil, err
 	}
 	if ctx == nil {
 		return client.Do(req)
@@ -62,6 +101,9 @@ func SendRequest(ctx context.Context, client *http.Client, req *http.Request) (*
 }
 
 func send(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	if s := batchSlotFrom(ctx); s != nil {
+		return s.send(req)
+	}
 	if client == nil {
 		client = http.DefaultClient
 	}
@@ -84,10 +126,8 @@ func send(ctx context.Context, client *http.Client, req *http.Request) (*http.Re
 // req.WithContext, then calls any functions returned by the hooks in
 // reverse order.
 func SendRequestWithRetry(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
-	// Disallow Accept-Encoding because it interferes with the automatic gzip handling
-	// done by the default http.Transport. See https://github.com/google/google-api-go-client/issues/219.
-	if _, ok := req.Header["Accept-Encoding"]; ok {
-		return nil, errors.New("google api: custom Accept-Encoding headers not allowed")
+	if err := prepareRequest(req); err != nil {
+		return nil, err
 	}
 	if ctx == nil {
 		return client.Do(req)
@@ -112,6 +152,10 @@ func SendRequestWithRetry(ctx context.Context, client *http.Client, req *http.Re
 }
 
 func sendAndRetry(ctx context.Context, client *http.Client, req *http.Request) (*http.Response, error) {
+	if s := batchSlotFrom(ctx); s != nil {
+		// The batch as a whole is not retried.
+		return s.send(req)
+	}
 	if client == nil {
 		client = http.DefaultClient
 	}
@@ -124,15 +168,19 @@ func sendAndRetry(ctx context.Context, client *http.Client, req *http.Request) (
 	bo := backoff()
 
 	for {
+		t := time.NewTimer(pause)
 		select {
 		case <-ctx.Done():
+			t.Stop()
+		case <-t.C:
+		}
+		if cerr := contextErr(ctx); cerr != nil {
 			// If we got an error, and the context has been canceled,
 			// the context's error is probably more useful.
 			if err == nil {
-				err = ctx.Err()
+				err = cerr
 			}
 			return resp, err
-		case <-time.After(pause):
 		}
 
 		resp, err = client.Do(req.WithContext(ctx))
@@ -148,13 +196,16 @@ func sendAndRetry(ctx context.Context, client *http.Client, req *http.Request) (
 		if req.GetBody == nil || !shouldRetry(status, err) {
 			break
 		}
+		// Wait at least as long as the server asked, but no longer than
+		// the deadline of ctx.
+		pause, _ = retryPause(ctx, bo, resp, time.Time{})
 		var errBody error
 		req.Body, errBody = req.GetBody()
 		if errBody != nil {
 			break
 		}
 
-		pause = bo.Pause()
+		callRetryHooks(ctx, client, resp, err, pause)
 		if resp != nil && resp.Body != nil {
 			resp.Body.Close()
 		}
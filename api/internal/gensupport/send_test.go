@@ -5,9 +5,17 @@
 package gensupport
 
 import (
+	"compress/gzip"
 	"context"
+	"io"
+	"io/ioutil"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+
+	"google.golang.org/api/googleapi"
 )
 
 func TestSendRequest(t *testing.T) {
@@ -29,3 +37,195 @@ func TestSendRequestWithRetry(t *testing.T) {
 		t.Error("got nil, want error")
 	}
 }
+
+func TestSendRequestWithRetryBodies(t *testing.T) {
+	defer noPause()()
+	for _, test := range []struct {
+		desc      string
+		method    string
+		body      io.Reader
+		requestID bool
+		wantCalls int
+	}{
+		// A request without a body is only retried if it has a request
+		// ID, as SetRequestID makes it retryable.
+		{"no body", "GET", nil, false, 1},
+		{"no body with request ID", "DELETE", nil, true, 2},
+		// http.NewRequest sets GetBody for a strings.Reader.
+		{"rereadable body", "POST", strings.NewReader("body"), false, 2},
+		// A plain io.Reader can't be sent again.
+		{"one-shot body", "POST", io.MultiReader(strings.NewReader("body")), false, 1},
+	} {
+		srv := &retryServer{fails: []http.HandlerFunc{
+			fail(http.StatusServiceUnavailable, "", ""),
+		}}
+		ts := httptest.NewServer(srv)
+		req, _ := http.NewRequest(test.method, ts.URL, test.body)
+		if test.requestID {
+			if err := SetRequestID(req, "requestId"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp, err := SendRequestWithRetry(context.Background(), ts.Client(), req)
+		ts.Close()
+		if err != nil {
+			t.Errorf("%s: %v", test.desc, err)
+			continue
+		}
+		resp.Body.Close()
+		if got := len(srv.times); got != test.wantCalls {
+			t.Errorf("%s: got %d requests, want %d", test.desc, got, test.wantCalls)
+		}
+	}
+}
+
+func TestSendRequestRawDownload(t *testing.T) {
+	const content = "some media content"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+			w.Write([]byte(content))
+			return
+		}
+		w.Header().Set("Content-Encoding", "gzip")
+		zw := gzip.NewWriter(w)
+		zw.Write([]byte(content))
+		zw.Close()
+	}))
+	defer ts.Close()
+
+	for _, raw := range []bool{false, true} {
+		u := URLParams{}
+		if raw {
+			SetOptions(u, googleapi.RawDownload())
+		}
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		req = SetRawDownload(req, u)
+		resp, err := SendRequest(context.Background(), ts.Client(), req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var body io.Reader = resp.Body
+		if got := resp.Header.Get("Content-Encoding"); raw != (got == "gzip") {
+			t.Errorf("raw=%t: got Content-Encoding %q", raw, got)
+		} else if raw {
+			if body, err = gzip.NewReader(resp.Body); err != nil {
+				t.Fatal(err)
+			}
+		}
+		b, err := ioutil.ReadAll(body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != content {
+			t.Errorf("raw=%t: got body %q, want %q", raw, b, content)
+		}
+	}
+
+	// Callers still can't set Accept-Encoding, not even to what
+	// SetRawDownload asks for.
+	for _, enc := range []string{"br", rawDownloadEncoding} {
+		req, _ := http.NewRequest("GET", ts.URL, nil)
+		req.Header.Set("Accept-Encoding", enc)
+		if _, err := SendRequest(context.Background(), ts.Client(), req); err == nil {
+			t.Errorf("Accept-Encoding %q: got nil, want error", enc)
+		}
+		if _, err := SendRequestWithRetry(context.Background(), ts.Client(), req); err == nil {
+			t.Errorf("Accept-Encoding %q with retry: got nil, want error", enc)
+		}
+	}
+}
+
+func TestSendRequestWithRetryBuffer(t *testing.T) {
+	defer noPause()()
+	dir, err := ioutil.TempDir("", "gensupport-test-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	defer os.Setenv("TMPDIR", os.Getenv("TMPDIR"))
+	os.Setenv("TMPDIR", dir)
+
+	content := strings.Repeat("some media content ", 100)
+	for _, buffer := range []bool{false, true} {
+		var bodies []string
+		srv := &retryServer{fails: []http.HandlerFunc{
+			fail(http.StatusServiceUnavailable, "", ""),
+		}}
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			bodies = append(bodies, string(b))
+			srv.ServeHTTP(w, r)
+		}))
+		u := URLParams{}
+		if buffer {
+			// Keep part of the body in memory and the rest in a file.
+			SetOptions(u, googleapi.RetryBuffer(int64(len(content)/3)))
+		}
+		// A plain io.Reader, which http.NewRequest can't read again.
+		body := io.MultiReader(strings.NewReader(content))
+		req, _ := http.NewRequest("POST", ts.URL, body)
+		release := BufferBody(req, u)
+		resp, err := SendRequestWithRetry(context.Background(), ts.Client(), req)
+		ts.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if files, _ := ioutil.ReadDir(dir); buffer != (len(files) == 1) {
+			t.Errorf("buffer=%t: got %d temporary files before release", buffer, len(files))
+		}
+		release()
+		if files, _ := ioutil.ReadDir(dir); len(files) != 0 {
+			t.Errorf("buffer=%t: got %d temporary files after release, want 0", buffer, len(files))
+		}
+
+		want := []string{content}
+		wantStatus := http.StatusServiceUnavailable
+		if buffer {
+			want = append(want, content)
+			wantStatus = http.StatusOK
+		}
+		if resp.StatusCode != wantStatus {
+			t.Errorf("buffer=%t: got status %d, want %d", buffer, resp.StatusCode, wantStatus)
+		}
+		if len(bodies) != len(want) {
+			t.Errorf("buffer=%t: got %d requests, want %d", buffer, len(bodies), len(want))
+			continue
+		}
+		for i := range want {
+			if bodies[i] != want[i] {
+				t.Errorf("buffer=%t: request %d: got body of %d bytes, want %d", buffer, i, len(bodies[i]), len(want[i]))
+			}
+		}
+	}
+}
+
+func TestBufferBodyPartlyRead(t *testing.T) {
+	// A retry may be sent when the body of the failed request was only
+	// read in part.
+	const content = "0123456789"
+	u := URLParams{}
+	SetOptions(u, googleapi.RetryBuffer(4))
+	req, _ := http.NewRequest("POST", "url", io.MultiReader(strings.NewReader(content)))
+	release := BufferBody(req, u)
+	defer release()
+	if _, err := io.ReadFull(req.Body, make([]byte, 6)); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		r, err := req.GetBody()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(r)
+		if err != nil || string(b) != content {
+			t.Errorf("read %d: got %q, %v, want %q", i, b, err, content)
+		}
+	}
+	release()
+	r, _ := req.GetBody()
+	if _, err := r.Read(make([]byte, 1)); err != errBodyReleased {
+		t.Errorf("after release: got %v, want %v", err, errBodyReleased)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+
+	"google.golang.org/api/internal/callopt"
+)
+
+var errBodyReleased = errors.New("gensupport: request body read after its call was done")
+
+// BufferBody makes the body of req retryable if it can't be read again and
+// the call option googleapi.RetryBuffer is in u: the body is kept as it is
+// read, up to the given size in memory and the rest in a temporary file, and
+// req.GetBody reads it again from the start. The returned function releases
+// the copy, and must be called when the call is done.
+func BufferBody(req *http.Request, u URLParams) (release func()) {
+	maxMemory, err := strconv.ParseInt(u.Get(callopt.RetryBuffer), 10, 64)
+	if err != nil || req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
+		return func() {}
+	}
+	b := &spillBuffer{src: req.Body, maxMemory: maxMemory}
+	req.Body = b.reader()
+	req.GetBody = func() (io.ReadCloser, error) { return b.reader(), nil }
+	return b.release
+}
+
+// A spillBuffer keeps what is read from src, up to maxMemory bytes in memory
+// and the rest in a temporary file, so that it can be read many times.
+type spillBuffer struct {
+	src       io.ReadCloser
+	maxMemory int64
+
+	// mu guards the fields below. A transport may still be reading the
+	// body of a request when the body of its retry is read.
+	mu       sync.Mutex
+	mem      []byte
+	file     *os.File
+	n        int64 // bytes read from src
+	err      error // error from src, or io.EOF
+	released bool
+}
+
+// reader returns a reader of the whole of src.
+func (b *spillBuffer) reader() io.ReadCloser {
+	return &spillReader{b: b}
+}
+
+type spillReader struct {
+	b   *spillBuffer
+	off int64
+}
+
+func (r *spillReader) Read(p []byte) (int, error) {
+	n, err := r.b.readAt(p, r.off)
+	r.off += int64(n)
+	return n, err
+}
+
+// Close does nothing: the copy is shared by the readers of the body, and
+// released by the function returned by BufferBody.
+func (r *spillReader) Close() error { return nil }
+
+// readAt reads from offset off of src: from the copy if that has been read
+// already, else from src itself.
+func (b *spillBuffer) readAt(p []byte, off int64) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.released {
+		return 0, errBodyReleased
+	}
+	if off < b.n {
+		if off < int64(len(b.mem)) {
+			return copy(p, b.mem[off:]), nil
+		}
+		if int64(len(p)) > b.n-off {
+			p = p[:b.n-off]
+		}
+		return b.file.ReadAt(p, off-int64(len(b.mem)))
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	n, err := b.src.Read(p)
+	if n > 0 {
+		if werr := b.keep(p[:n]); werr != nil {
+			return 0, werr
+		}
+	}
+	if err != nil {
+		b.err = err
+	}
+	return n, err
+}
+
+// keep adds p to the copy.
+func (b *spillBuffer) keep(p []byte) error {
+	if room := b.maxMemory - int64(len(b.mem)); b.file == nil && room > 0 {
+		m := p
+		if int64(len(m)) > room {
+			m = m[:room]
+		}
+		b.mem = append(b.mem, m...)
+		b.n += int64(len(m))
+		p = p[len(m):]
+	}
+	if len(p) == 0 {
+		return nil
+	}
+	if b.file == nil {
+		f, err := ioutil.TempFile("", "gensupport-body-")
+		if err != nil {
+			return err
+		}
+		b.file = f
+	}
+	n, err := b.file.WriteAt(p, b.n-int64(len(b.mem)))
+	b.n += int64(n)
+	return err
+}
+
+func (b *spillBuffer) release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.released {
+		return
+	}
+	b.released = true
+	b.mem = nil
+	if b.file != nil {
+		b.file.Close()
+		os.Remove(b.file.Name())
+	}
+	b.src.Close()
+}
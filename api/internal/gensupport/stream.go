@@ -0,0 +1,86 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// DecodeResponseItems is like DecodeResponse, but decodes the elements of
+// the array in the field of the body of res one at a time, by calling
+// decodeItem with a decoder positioned at each of them in turn, instead of
+// storing them in target. The other fields of the body are decoded into
+// target as usual. Only one element is held in memory at a time, so that
+// large pages of list responses can be processed in constant memory. A
+// non-nil error returned by decodeItem stops the decoding and is returned.
+func DecodeResponseItems(target interface{}, res *http.Response, field string, decodeItem func(*json.Decoder) error) error {
+	if res.StatusCode == http.StatusNoContent {
+		return nil
+	}
+	dec := json.NewDecoder(res.Body)
+	if err := expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	rest := make(map[string]json.RawMessage)
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key, ok := t.(string)
+		if !ok {
+			return fmt.Errorf("gensupport: got %v, want object key", t)
+		}
+		if key != field {
+			var v json.RawMessage
+			if err := dec.Decode(&v); err != nil {
+				return err
+			}
+			rest[key] = v
+			continue
+		}
+		if t, err = dec.Token(); err != nil {
+			return err
+		}
+		if t == nil {
+			continue // null
+		}
+		if t != json.Delim('[') {
+			return fmt.Errorf("gensupport: got %v for %q, want array", t, field)
+		}
+		for dec.More() {
+			if err := decodeItem(dec); err != nil {
+				return err
+			}
+		}
+		if err := expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	if err := expectDelim(dec, '}'); err != nil {
+		return err
+	}
+	// The other fields are few and small, so decoding them again is cheap,
+	// and keeps any custom UnmarshalJSON method of target working.
+	b, err := json.Marshal(rest)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, target)
+}
+
+// expectDelim reads the next token of dec, which must be d.
+func expectDelim(dec *json.Decoder, d json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return err
+	}
+	if t != d {
+		return fmt.Errorf("gensupport: got %v, want %v", t, d)
+	}
+	return nil
+}
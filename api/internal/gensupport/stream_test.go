@@ -0,0 +1,178 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+type streamItem struct {
+	Name  string        `json:"name"`
+	Items []*streamItem `json:"items"`
+}
+
+type streamList struct {
+	Kind          string        `json:"kind"`
+	Items         []*streamItem `json:"items"`
+	NextPageToken string        `json:"nextPageToken"`
+}
+
+func jsonResponse(body string) *http.Response {
+	return &http.Response{StatusCode: http.StatusOK, Body: ioutil.NopCloser(strings.NewReader(body))}
+}
+
+// decodeItems decodes body with DecodeResponseItems, and returns the target
+// and the items.
+func decodeItems(body string, f func(*streamItem) error) (*streamList, []*streamItem, error) {
+	var list streamList
+	var items []*streamItem
+	err := DecodeResponseItems(&list, jsonResponse(body), "items", func(dec *json.Decoder) error {
+		var item *streamItem
+		if err := dec.Decode(&item); err != nil {
+			return err
+		}
+		items = append(items, item)
+		if f != nil {
+			return f(item)
+		}
+		return nil
+	})
+	return &list, items, err
+}
+
+func TestDecodeResponseItems(t *testing.T) {
+	for _, test := range []struct {
+		body      string
+		wantList  *streamList
+		wantItems []*streamItem
+	}{
+		{
+			body:     `{"kind": "list", "items": [{"name": "a", "items": [{"name": "a1"}]}, {"name": "b"}], "nextPageToken": "tok"}`,
+			wantList: &streamList{Kind: "list", NextPageToken: "tok"},
+			wantItems: []*streamItem{
+				{Name: "a", Items: []*streamItem{{Name: "a1"}}},
+				{Name: "b"},
+			},
+		},
+		{
+			body:     `{"nextPageToken": "tok", "items": [], "unknown": {"x": [1, 2]}}`,
+			wantList: &streamList{NextPageToken: "tok"},
+		},
+		{
+			body:     `{"items": null}`,
+			wantList: &streamList{},
+		},
+		{
+			body:     `{}`,
+			wantList: &streamList{},
+		},
+	} {
+		list, items, err := decodeItems(test.body, nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.body, err)
+			continue
+		}
+		if diff := cmp.Diff(test.wantList, list); diff != "" {
+			t.Errorf("%s: target mismatch (-want +got):\n%s", test.body, diff)
+		}
+		if diff := cmp.Diff(test.wantItems, items); diff != "" {
+			t.Errorf("%s: items mismatch (-want +got):\n%s", test.body, diff)
+		}
+	}
+}
+
+func TestDecodeResponseItemsErrors(t *testing.T) {
+	for _, body := range []string{
+		``,
+		`[]`,
+		`{"items": {}}`,
+		`{"items": [{"name": 1}]}`,
+		`{"items": [{"name": "a"}`,
+		`{"kind": "list"`,
+	} {
+		if _, _, err := decodeItems(body, nil); err == nil {
+			t.Errorf("%q: got nil, want error", body)
+		}
+	}
+
+	stop := errors.New("stop")
+	n := 0
+	_, _, err := decodeItems(`{"items": [{"name": "a"}, {"name": "b"}]}`, func(*streamItem) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		t.Errorf("got %v after %d items, want %v after 1", err, n, stop)
+	}
+}
+
+func TestDecodeResponseItemsNoContent(t *testing.T) {
+	res := &http.Response{StatusCode: http.StatusNoContent, Body: ioutil.NopCloser(strings.NewReader(""))}
+	list := &streamList{Kind: "unchanged"}
+	err := DecodeResponseItems(list, res, "items", func(*json.Decoder) error {
+		return errors.New("unexpected item")
+	})
+	if err != nil || list.Kind != "unchanged" {
+		t.Errorf("got %v, %+v; want nil, unchanged target", err, list)
+	}
+}
+
+// benchmarkListBody returns a list response of n items of about size bytes.
+func benchmarkListBody(n, size int) string {
+	var b strings.Builder
+	b.WriteString(`{"kind": "list", "items": [`)
+	for i := 0; i < n; i++ {
+		if i > 0 {
+			b.WriteString(",")
+		}
+		fmt.Fprintf(&b, `{"name": "item-%d-%s"}`, i, strings.Repeat("x", size))
+	}
+	b.WriteString(`], "nextPageToken": "tok"}`)
+	return b.String()
+}
+
+func BenchmarkDecodeResponse(b *testing.B) {
+	body := benchmarkListBody(1000, 1000)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var list streamList
+		if err := DecodeResponse(&list, jsonResponse(body)); err != nil {
+			b.Fatal(err)
+		}
+		if len(list.Items) != 1000 {
+			b.Fatalf("got %d items", len(list.Items))
+		}
+	}
+}
+
+func BenchmarkDecodeResponseItems(b *testing.B) {
+	body := benchmarkListBody(1000, 1000)
+	b.SetBytes(int64(len(body)))
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var list streamList
+		n := 0
+		err := DecodeResponseItems(&list, jsonResponse(body), "items", func(dec *json.Decoder) error {
+			var item streamItem
+			n++
+			return dec.Decode(&item)
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if n != 1000 || list.NextPageToken != "tok" {
+			b.Fatalf("got %d items, page token %q", n, list.NextPageToken)
+		}
+	}
+}
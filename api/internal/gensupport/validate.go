@@ -0,0 +1,84 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"fmt"
+	"regexp"
+	"sync"
+
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/internal/callopt"
+)
+
+// Validate calls validate, the validate method of a call, unless u holds the
+// googleapi.SkipValidation call option.
+func Validate(u URLParams, validate func() error) error {
+	if u.Get(callopt.SkipValidation) != "" {
+		return nil
+	}
+	return validate()
+}
+
+// A Validator collects the problems that the validate method of a call finds
+// in its parameters and request body.
+type Validator struct {
+	method     string
+	violations []googleapi.FieldViolation
+}
+
+// NewValidator returns a Validator for a call to the method with the given
+// ID.
+func NewValidator(method string) *Validator {
+	return &Validator{method: method}
+}
+
+// Required records a violation if the required field is not set.
+func (v *Validator) Required(field string, set bool) {
+	if !set {
+		v.add(field, "required but not set")
+	}
+}
+
+// Pattern records a violation if value is not empty and doesn't match the
+// regular expression pattern. Patterns that are not valid Go regular
+// expressions match every value.
+func (v *Validator) Pattern(field, value, pattern string) {
+	if value == "" {
+		return
+	}
+	if re := compilePattern(pattern); re != nil && !re.MatchString(value) {
+		v.add(field, fmt.Sprintf("%q does not match the pattern %q", value, pattern))
+	}
+}
+
+func (v *Validator) add(field, description string) {
+	v.violations = append(v.violations, googleapi.FieldViolation{Field: field, Description: description})
+}
+
+// Err returns a *googleapi.ValidationError if any violations were recorded,
+// and nil otherwise.
+func (v *Validator) Err() error {
+	if len(v.violations) == 0 {
+		return nil
+	}
+	return &googleapi.ValidationError{Method: v.method, Violations: v.violations}
+}
+
+var (
+	patternsMu sync.Mutex
+	patterns   = make(map[string]*regexp.Regexp) // nil for invalid patterns
+)
+
+func compilePattern(pattern string) *regexp.Regexp {
+	patternsMu.Lock()
+	defer patternsMu.Unlock()
+	re, ok := patterns[pattern]
+	if !ok {
+		re, _ = regexp.Compile(pattern)
+		patterns[pattern] = re
+	}
+	return re
+}
@@ -0,0 +1,57 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package gensupport
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"google.golang.org/api/googleapi"
+)
+
+func TestValidator(t *testing.T) {
+	v := NewValidator("tasks.tasks.get")
+	if err := v.Err(); err != nil {
+		t.Fatalf("no violations: got %v, want nil", err)
+	}
+	v.Required("tasklist", true)
+	v.Required("task", false)
+	v.Pattern("name", "projects/p/locations/l", "^projects/[^/]+/locations/[^/]+$")
+	v.Pattern("parent", "projects/p/", "^projects/[^/]+$")
+	v.Pattern("empty", "", "^projects/[^/]+$")
+	v.Pattern("invalid", "x", "^(?!x)") // Not valid in Go: never a violation.
+	err := v.Err()
+	want := &googleapi.ValidationError{
+		Method: "tasks.tasks.get",
+		Violations: []googleapi.FieldViolation{
+			{Field: "task", Description: "required but not set"},
+			{Field: "parent", Description: `"projects/p/" does not match the pattern "^projects/[^/]+$"`},
+		},
+	}
+	if !reflect.DeepEqual(err, want) {
+		t.Fatalf("got %#v, want %#v", err, want)
+	}
+	wantMsg := `googleapi: invalid call to tasks.tasks.get: task: required but not set; parent: "projects/p/" does not match the pattern "^projects/[^/]+$"`
+	if got := err.Error(); got != wantMsg {
+		t.Errorf("Error() = %q, want %q", got, wantMsg)
+	}
+}
+
+func TestValidateSkip(t *testing.T) {
+	errInvalid := errors.New("invalid")
+	validate := func() error { return errInvalid }
+	u := URLParams{}
+	if err := Validate(u, validate); err != errInvalid {
+		t.Errorf("got %v, want %v", err, errInvalid)
+	}
+	SetOptions(u, googleapi.SkipValidation(), googleapi.QuotaUser("u"))
+	if err := Validate(u, validate); err != nil {
+		t.Errorf("with SkipValidation: got %v, want nil", err)
+	}
+	if got, want := u.Encode(), "quotaUser=u"; got != want {
+		t.Errorf("Encode() = %q, want %q", got, want)
+	}
+}
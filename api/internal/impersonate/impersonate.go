@@ -13,11 +13,30 @@ import (
 	"io"
 	"io/ioutil"
 	"net/http"
+	"sync"
 	"time"
 
+	"github.com/googleapis/gax-go/v2"
 	"golang.org/x/oauth2"
 )
 
+// defaultLifetime is the longest acceptable value of one hour as the token
+// will be refreshed automatically.
+const defaultLifetime = time.Hour
+
+// defaultEarlyRefresh matches the expiry delta used by oauth2.ReuseTokenSource.
+const defaultEarlyRefresh = 10 * time.Second
+
+// maxAttempts is the number of times a call to the IAM Credentials API is
+// attempted when it fails with a server error.
+const maxAttempts = 3
+
+// iamCredentialsEndpoint is overridden in tests.
+var iamCredentialsEndpoint = "https://iamcredentials.googleapis.com"
+
+// sleep is overridden in tests.
+var sleep = gax.Sleep
+
 // Config for generating impersonated credentials.
 type Config struct {
 	// Target is the service account to impersonate. Required.
@@ -28,6 +47,25 @@ type Config struct {
 	// account must be granted roles/iam.serviceAccountTokenCreator on the next
 	// service account in the chain. Optional.
 	Delegates []string
+	// Lifetime is the amount of time until the impersonated token expires.
+	// Values over one hour require the organization policy
+	// constraints/iam.allowServiceAccountCredentialLifetimeExtension. Defaults
+	// to one hour. Optional.
+	Lifetime time.Duration
+	// EarlyRefresh is how long before the token expires that a new token is
+	// fetched. Defaults to 10 seconds. Optional.
+	EarlyRefresh time.Duration
+	// Endpoint is the URL of the IAM Credentials API, without a path.
+	// Defaults to https://iamcredentials.googleapis.com. Optional.
+	Endpoint string
+}
+
+// endpoint returns the IAM Credentials endpoint of c.
+func (c *Config) endpoint() string {
+	if c.Endpoint != "" {
+		return c.Endpoint
+	}
+	return iamCredentialsEndpoint
 }
 
 // TokenSource returns an impersonated TokenSource configured with the provided
@@ -36,29 +74,47 @@ func TokenSource(ctx context.Context, ts oauth2.TokenSource, config *Config) (oa
 	if len(config.Scopes) == 0 {
 		return nil, fmt.Errorf("impersonate: scopes must be provided")
 	}
+	if config.Lifetime < 0 {
+		return nil, fmt.Errorf("impersonate: lifetime must not be negative")
+	}
+	lifetime := defaultLifetime
+	if config.Lifetime > 0 {
+		lifetime = config.Lifetime
+	}
+	earlyRefresh := defaultEarlyRefresh
+	if config.EarlyRefresh > 0 {
+		earlyRefresh = config.EarlyRefresh
+	}
+	if earlyRefresh >= lifetime {
+		return nil, fmt.Errorf("impersonate: early refresh %v must be less than the lifetime %v", earlyRefresh, lifetime)
+	}
 	its := impersonatedTokenSource{
-		ctx:  ctx,
-		ts:   ts,
-		name: formatIAMServiceAccountName(config.Target),
-		// Default to the longest acceptable value of one hour as the token will
-		// be refreshed automatically.
-		lifetime: "3600s",
+		ctx:      ctx,
+		ts:       ts,
+		endpoint: config.endpoint(),
+		name:     formatIAMServiceAccountName(config.Target),
+		lifetime: fmt.Sprintf("%.fs", lifetime.Seconds()),
 	}
 
-	its.delegates = make([]string, len(config.Delegates))
-	for i, v := range config.Delegates {
-		its.delegates[i] = formatIAMServiceAccountName(v)
-	}
+	its.delegates = formatDelegates(config.Delegates)
 	its.scopes = make([]string, len(config.Scopes))
 	copy(its.scopes, config.Scopes)
 
-	return oauth2.ReuseTokenSource(nil, its), nil
+	return &reuseTokenSource{new: its, earlyRefresh: earlyRefresh}, nil
 }
 
 func formatIAMServiceAccountName(name string) string {
 	return fmt.Sprintf("projects/-/serviceAccounts/%s", name)
 }
 
+func formatDelegates(delegates []string) []string {
+	formatted := make([]string, len(delegates))
+	for i, v := range delegates {
+		formatted[i] = formatIAMServiceAccountName(v)
+	}
+	return formatted
+}
+
 type generateAccessTokenReq struct {
 	Delegates []string `json:"delegates,omitempty"`
 	Lifetime  string   `json:"lifetime,omitempty"`
@@ -74,6 +130,7 @@ type impersonatedTokenSource struct {
 	ctx context.Context
 	ts  oauth2.TokenSource
 
+	endpoint  string
 	name      string
 	lifetime  string
 	scopes    []string
@@ -82,47 +139,120 @@ type impersonatedTokenSource struct {
 
 // Token returns an impersonated Token.
 func (i impersonatedTokenSource) Token() (*oauth2.Token, error) {
-	hc := oauth2.NewClient(i.ctx, i.ts)
 	reqBody := generateAccessTokenReq{
 		Delegates: i.delegates,
 		Lifetime:  i.lifetime,
 		Scope:     i.scopes,
 	}
+	var accessTokenResp generateAccessTokenResp
+	if err := doIAMRequest(i.ctx, i.ts, i.endpoint, i.name, "generateAccessToken", reqBody, &accessTokenResp); err != nil {
+		return nil, fmt.Errorf("impersonate: unable to generate access token: %v", err)
+	}
+	expiry, err := time.Parse(time.RFC3339, accessTokenResp.ExpireTime)
+	if err != nil {
+		return nil, fmt.Errorf("impersonate: unable to parse expiry: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: accessTokenResp.AccessToken,
+		Expiry:      expiry,
+	}, nil
+}
+
+// reuseTokenSource is like the TokenSource returned by
+// oauth2.ReuseTokenSource, but refreshes the token earlyRefresh before it
+// expires instead of using a fixed delta.
+type reuseTokenSource struct {
+	new          oauth2.TokenSource
+	earlyRefresh time.Duration
+
+	mu sync.Mutex
+	t  *oauth2.Token
+}
+
+// Token returns the current token if it is not about to expire, otherwise it
+// fetches a new one.
+func (s *reuseTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.t != nil && (s.t.Expiry.IsZero() || time.Now().Add(s.earlyRefresh).Before(s.t.Expiry)) {
+		return s.t, nil
+	}
+	t, err := s.new.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.t = t
+	return t, nil
+}
+
+// Refresh fetches a new token even if the current one is still valid, and
+// caches it. It lets a background refresh get past the cache.
+func (s *reuseTokenSource) Refresh() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, err := s.new.Token()
+	if err != nil {
+		return nil, err
+	}
+	s.t = t
+	return t, nil
+}
+
+// doIAMRequest calls the IAM Credentials method on the service account name
+// at endpoint, authenticating with ts. The request body is marshaled from reqBody and the
+// response is unmarshaled into respBody. Calls that fail with a server error
+// are retried with backoff.
+func doIAMRequest(ctx context.Context, ts oauth2.TokenSource, endpoint, name, method string, reqBody, respBody interface{}) error {
+	hc := oauth2.NewClient(ctx, ts)
 	b, err := json.Marshal(reqBody)
 	if err != nil {
-		return nil, fmt.Errorf("impersonate: unable to marshal request: %v", err)
+		return fmt.Errorf("unable to marshal request: %v", err)
+	}
+	url := fmt.Sprintf("%s/v1/%s:%s", endpoint, name, method)
+	bo := gax.Backoff{
+		Initial:    100 * time.Millisecond,
+		Max:        2 * time.Second,
+		Multiplier: 2,
 	}
-	url := fmt.Sprintf("https://iamcredentials.googleapis.com/v1/%s:generateAccessToken", i.name)
+	var body []byte
+	for attempt := 1; ; attempt++ {
+		var code int
+		body, code, err = postJSON(ctx, hc, url, b)
+		if err != nil {
+			return err
+		}
+		if code >= 200 && code <= 299 {
+			break
+		}
+		if code < 500 || attempt == maxAttempts {
+			return fmt.Errorf("status code %d: %s", code, body)
+		}
+		if err := sleep(ctx, bo.Pause()); err != nil {
+			return fmt.Errorf("status code %d: %s", code, body)
+		}
+	}
+	if err := json.Unmarshal(body, respBody); err != nil {
+		return fmt.Errorf("unable to parse response: %v", err)
+	}
+	return nil
+}
+
+func postJSON(ctx context.Context, hc *http.Client, url string, b []byte) ([]byte, int, error) {
 	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
 	if err != nil {
-		return nil, fmt.Errorf("impersonate: unable to create request: %v", err)
+		return nil, 0, fmt.Errorf("unable to create request: %v", err)
 	}
-	req = req.WithContext(i.ctx)
+	req = req.WithContext(ctx)
 	req.Header.Set("Content-Type", "application/json")
 
 	resp, err := hc.Do(req)
 	if err != nil {
-		return nil, fmt.Errorf("impersonate: unable to generate access token: %v", err)
+		return nil, 0, err
 	}
 	defer resp.Body.Close()
 	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
 	if err != nil {
-		return nil, fmt.Errorf("impersonate: unable to read body: %v", err)
-	}
-	if c := resp.StatusCode; c < 200 || c > 299 {
-		return nil, fmt.Errorf("impersonate: status code %d: %s", c, body)
-	}
-
-	var accessTokenResp generateAccessTokenResp
-	if err := json.Unmarshal(body, &accessTokenResp); err != nil {
-		return nil, fmt.Errorf("impersonate: unable to parse response: %v", err)
-	}
-	expiry, err := time.Parse(time.RFC3339, accessTokenResp.ExpireTime)
-	if err != nil {
-		return nil, fmt.Errorf("impersonate: unable to parse expiry: %v", err)
+		return nil, 0, fmt.Errorf("unable to read body: %v", err)
 	}
-	return &oauth2.Token{
-		AccessToken: accessTokenResp.AccessToken,
-		Expiry:      expiry,
-	}, nil
+	return body, resp.StatusCode, nil
 }
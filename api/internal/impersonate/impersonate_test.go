@@ -0,0 +1,227 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package impersonate
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+// fakeIAM is an IAM Credentials server that fails the first failures calls
+// with a 503.
+type fakeIAM struct {
+	t        *testing.T
+	failures int
+	expiry   time.Time
+
+	calls   int
+	gotPath string
+	gotReq  map[string]interface{}
+}
+
+func (f *fakeIAM) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.calls++
+	if f.calls <= f.failures {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+	f.gotPath = r.URL.Path
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		f.t.Errorf("ReadAll: %v", err)
+	}
+	f.gotReq = nil
+	if err := json.Unmarshal(b, &f.gotReq); err != nil {
+		f.t.Errorf("Unmarshal: %v", err)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case strings.HasSuffix(r.URL.Path, ":generateAccessToken"):
+		fmt.Fprintf(w, `{"accessToken": "token-%d", "expireTime": %q}`, f.calls, f.expiry.Format(time.RFC3339))
+	case strings.HasSuffix(r.URL.Path, ":signBlob"):
+		fmt.Fprintf(w, `{"keyId": "key", "signedBlob": %q}`, base64.StdEncoding.EncodeToString([]byte("signature")))
+	case strings.HasSuffix(r.URL.Path, ":signJwt"):
+		fmt.Fprint(w, `{"keyId": "key", "signedJwt": "a.b.c"}`)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func setup(t *testing.T, f *fakeIAM) func() {
+	server := httptest.NewServer(f)
+	oldEndpoint, oldSleep := iamCredentialsEndpoint, sleep
+	iamCredentialsEndpoint = server.URL
+	sleep = func(context.Context, time.Duration) error { return nil }
+	return func() {
+		iamCredentialsEndpoint, sleep = oldEndpoint, oldSleep
+		server.Close()
+	}
+}
+
+var baseTS = oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "base"})
+
+func TestTokenSource_Lifetime(t *testing.T) {
+	f := &fakeIAM{t: t, expiry: time.Now().Add(time.Hour)}
+	defer setup(t, f)()
+
+	ts, err := TokenSource(context.Background(), baseTS, &Config{
+		Target:   "sa@example.com",
+		Scopes:   []string{"scope"},
+		Lifetime: 30 * time.Minute,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := f.gotReq["lifetime"], "1800s"; got != want {
+		t.Errorf("got lifetime %v, want %q", got, want)
+	}
+}
+
+func TestTokenSource_Endpoint(t *testing.T) {
+	f := &fakeIAM{t: t, expiry: time.Now().Add(time.Hour)}
+	defer setup(t, f)()
+	other := &fakeIAM{t: t, expiry: time.Now().Add(time.Hour)}
+	server := httptest.NewServer(other)
+	defer server.Close()
+
+	ts, err := TokenSource(context.Background(), baseTS, &Config{
+		Target:   "sa@example.com",
+		Scopes:   []string{"scope"},
+		Endpoint: server.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Token(); err != nil {
+		t.Fatal(err)
+	}
+	if f.calls != 0 || other.calls != 1 {
+		t.Errorf("got %d calls to the default endpoint and %d to Endpoint, want 0 and 1", f.calls, other.calls)
+	}
+	if want := "/v1/projects/-/serviceAccounts/sa@example.com:generateAccessToken"; other.gotPath != want {
+		t.Errorf("got path %q, want %q", other.gotPath, want)
+	}
+}
+
+func TestTokenSource_EarlyRefresh(t *testing.T) {
+	f := &fakeIAM{t: t, expiry: time.Now().Add(5 * time.Minute)}
+	defer setup(t, f)()
+
+	for _, tc := range []struct {
+		earlyRefresh time.Duration
+		wantCalls    int
+	}{
+		{earlyRefresh: 0, wantCalls: 1},
+		{earlyRefresh: 10 * time.Minute, wantCalls: 2},
+	} {
+		f.calls = 0
+		ts, err := TokenSource(context.Background(), baseTS, &Config{
+			Target:       "sa@example.com",
+			Scopes:       []string{"scope"},
+			EarlyRefresh: tc.earlyRefresh,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < 2; i++ {
+			if _, err := ts.Token(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if f.calls != tc.wantCalls {
+			t.Errorf("EarlyRefresh %v: got %d calls, want %d", tc.earlyRefresh, f.calls, tc.wantCalls)
+		}
+	}
+}
+
+func TestTokenSource_InvalidConfig(t *testing.T) {
+	for _, config := range []*Config{
+		{Target: "sa@example.com"},
+		{Target: "sa@example.com", Scopes: []string{"scope"}, Lifetime: -time.Second},
+		{Target: "sa@example.com", Scopes: []string{"scope"}, Lifetime: time.Minute, EarlyRefresh: time.Minute},
+	} {
+		if _, err := TokenSource(context.Background(), baseTS, config); err == nil {
+			t.Errorf("%+v: got nil error, want error", config)
+		}
+	}
+}
+
+func TestTokenSource_Retry(t *testing.T) {
+	for _, tc := range []struct {
+		failures  int
+		wantErr   bool
+		wantCalls int
+	}{
+		{failures: 0, wantCalls: 1},
+		{failures: maxAttempts - 1, wantCalls: maxAttempts},
+		{failures: maxAttempts, wantErr: true, wantCalls: maxAttempts},
+	} {
+		f := &fakeIAM{t: t, failures: tc.failures, expiry: time.Now().Add(time.Hour)}
+		teardown := setup(t, f)
+		ts, err := TokenSource(context.Background(), baseTS, &Config{Target: "sa@example.com", Scopes: []string{"scope"}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ts.Token()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%d failures: got err %v, want error %t", tc.failures, err, tc.wantErr)
+		}
+		if f.calls != tc.wantCalls {
+			t.Errorf("%d failures: got %d calls, want %d", tc.failures, f.calls, tc.wantCalls)
+		}
+		teardown()
+	}
+}
+
+func TestSignBlob(t *testing.T) {
+	f := &fakeIAM{t: t, failures: 1}
+	defer setup(t, f)()
+
+	keyID, sig, err := SignBlob(context.Background(), baseTS, &Config{
+		Target:    "sa@example.com",
+		Delegates: []string{"d@example.com"},
+	}, []byte("payload"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "key" || string(sig) != "signature" {
+		t.Errorf("got (%q, %q), want (key, signature)", keyID, sig)
+	}
+	if want := "/v1/projects/-/serviceAccounts/sa@example.com:signBlob"; f.gotPath != want {
+		t.Errorf("got path %q, want %q", f.gotPath, want)
+	}
+	if got, want := f.gotReq["payload"], base64.StdEncoding.EncodeToString([]byte("payload")); got != want {
+		t.Errorf("got payload %v, want %q", got, want)
+	}
+}
+
+func TestSignJWT(t *testing.T) {
+	f := &fakeIAM{t: t}
+	defer setup(t, f)()
+
+	keyID, jwt, err := SignJWT(context.Background(), baseTS, &Config{Target: "sa@example.com"}, `{"sub":"x"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keyID != "key" || jwt != "a.b.c" {
+		t.Errorf("got (%q, %q), want (key, a.b.c)", keyID, jwt)
+	}
+	if got, want := f.gotReq["payload"], `{"sub":"x"}`; got != want {
+		t.Errorf("got payload %v, want %q", got, want)
+	}
+}
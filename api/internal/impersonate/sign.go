@@ -0,0 +1,79 @@
+// Copyright 2020 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package impersonate
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+
+	"golang.org/x/oauth2"
+)
+
+type signBlobReq struct {
+	Delegates []string `json:"delegates,omitempty"`
+	Payload   string   `json:"payload"`
+}
+
+type signBlobResp struct {
+	KeyID      string `json:"keyId"`
+	SignedBlob string `json:"signedBlob"`
+}
+
+// SignBlob signs payload with a system-managed private key of the target
+// service account in config, using ts as the base credential provider for
+// making requests. It returns the ID of the key used and the signature. The
+// Scopes, Lifetime and EarlyRefresh fields of config are ignored.
+//
+// SignBlob can be used as the SignBytes function when generating Cloud Storage
+// signed URLs without access to a private key.
+func SignBlob(ctx context.Context, ts oauth2.TokenSource, config *Config, payload []byte) (keyID string, signature []byte, err error) {
+	if config.Target == "" {
+		return "", nil, fmt.Errorf("impersonate: a target service account must be provided")
+	}
+	reqBody := signBlobReq{
+		Delegates: formatDelegates(config.Delegates),
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+	}
+	var resp signBlobResp
+	if err := doIAMRequest(ctx, ts, config.endpoint(), formatIAMServiceAccountName(config.Target), "signBlob", reqBody, &resp); err != nil {
+		return "", nil, fmt.Errorf("impersonate: unable to sign blob: %v", err)
+	}
+	signature, err = base64.StdEncoding.DecodeString(resp.SignedBlob)
+	if err != nil {
+		return "", nil, fmt.Errorf("impersonate: unable to decode signature: %v", err)
+	}
+	return resp.KeyID, signature, nil
+}
+
+type signJWTReq struct {
+	Delegates []string `json:"delegates,omitempty"`
+	Payload   string   `json:"payload"`
+}
+
+type signJWTResp struct {
+	KeyID     string `json:"keyId"`
+	SignedJWT string `json:"signedJwt"`
+}
+
+// SignJWT signs the JSON-encoded JWT claim set in payload with a
+// system-managed private key of the target service account in config, using
+// ts as the base credential provider for making requests. It returns the ID of
+// the key used and the signed JWT. The Scopes, Lifetime and EarlyRefresh
+// fields of config are ignored.
+func SignJWT(ctx context.Context, ts oauth2.TokenSource, config *Config, payload string) (keyID string, jwt string, err error) {
+	if config.Target == "" {
+		return "", "", fmt.Errorf("impersonate: a target service account must be provided")
+	}
+	reqBody := signJWTReq{
+		Delegates: formatDelegates(config.Delegates),
+		Payload:   payload,
+	}
+	var resp signJWTResp
+	if err := doIAMRequest(ctx, ts, config.endpoint(), formatIAMServiceAccountName(config.Target), "signJwt", reqBody, &resp); err != nil {
+		return "", "", fmt.Errorf("impersonate: unable to sign JWT: %v", err)
+	}
+	return resp.KeyID, resp.SignedJWT, nil
+}
@@ -0,0 +1,219 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package installedapp
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/oauth2"
+)
+
+// sleep is overridden in tests.
+var sleep = gax.Sleep
+
+// loopbackFlow asks the user to authorize the application in a browser,
+// which is redirected to a server on the loopback interface with the
+// authorization code. The code is bound to this flow with PKCE (RFC 7636),
+// so that another application that sees it can't use it.
+func (c *Config) loopbackFlow(ctx context.Context, oc *oauth2.Config) (*oauth2.Token, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return nil, fmt.Errorf("installedapp: unable to listen for the redirect: %v", err)
+	}
+	defer l.Close()
+	cfg := *oc
+	cfg.RedirectURL = "http://" + l.Addr().String() + "/"
+
+	state, err := randomString(16)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(32)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256([]byte(verifier))
+	authURL := cfg.AuthCodeURL(state, oauth2.AccessTypeOffline,
+		oauth2.SetAuthURLParam("code_challenge", base64.RawURLEncoding.EncodeToString(h[:])),
+		oauth2.SetAuthURLParam("code_challenge_method", "S256"))
+
+	type result struct {
+		code string
+		err  error
+	}
+	results := make(chan result, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Requests without the state, like ones for /favicon.ico, are not
+		// from the authorization server.
+		if r.URL.Path != "/" || r.FormValue("state") != state {
+			http.NotFound(w, r)
+			return
+		}
+		var res result
+		if res.code = r.FormValue("code"); res.code == "" {
+			res.err = fmt.Errorf("installedapp: authorization failed: %s", r.FormValue("error"))
+			http.Error(w, "Authorization failed. You may close this window.", http.StatusBadRequest)
+		} else {
+			fmt.Fprint(w, "Authorization succeeded. You may close this window.")
+		}
+		select {
+		case results <- res:
+		default: // Not the first redirect.
+		}
+	})}
+	go srv.Serve(l)
+	defer srv.Close()
+
+	fmt.Fprintf(c.output(), "Authorize this application in your browser. If it doesn't open, visit:\n\n%s\n\n", authURL)
+	open := c.openURL
+	if open == nil {
+		open = openBrowser
+	}
+	open(authURL) // The URL is printed anyway.
+
+	var res result
+	select {
+	case res = <-results:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if res.err != nil {
+		return nil, res.err
+	}
+	tok, err := cfg.Exchange(ctx, res.code, oauth2.SetAuthURLParam("code_verifier", verifier))
+	if err != nil {
+		return nil, fmt.Errorf("installedapp: unable to exchange authorization code: %v", err)
+	}
+	return tok, nil
+}
+
+type deviceAuthResponse struct {
+	DeviceCode      string `json:"device_code"`
+	UserCode        string `json:"user_code"`
+	VerificationURL string `json:"verification_url"`
+	VerificationURI string `json:"verification_uri"` // The name in RFC 8628.
+	ExpiresIn       int64  `json:"expires_in"`
+	Interval        int64  `json:"interval"`
+}
+
+type tokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int64  `json:"expires_in"`
+	Error        string `json:"error"`
+}
+
+// deviceFlow asks the user to authorize the application on another device,
+// by visiting a URL and entering a code there, and polls the token endpoint
+// until they do (RFC 8628).
+func (c *Config) deviceFlow(ctx context.Context, oc *oauth2.Config) (*oauth2.Token, error) {
+	deviceAuthURL := c.deviceAuthURL
+	if deviceAuthURL == "" {
+		deviceAuthURL = defaultDeviceAuthURL
+	}
+	var auth deviceAuthResponse
+	status, err := postForm(ctx, deviceAuthURL, url.Values{
+		"client_id": {oc.ClientID},
+		"scope":     {strings.Join(oc.Scopes, " ")},
+	}, &auth)
+	if err == nil && status != http.StatusOK {
+		err = fmt.Errorf("status code %d", status)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("installedapp: unable to start device authorization: %v", err)
+	}
+	verificationURL := auth.VerificationURL
+	if verificationURL == "" {
+		verificationURL = auth.VerificationURI
+	}
+	if auth.DeviceCode == "" || auth.UserCode == "" || verificationURL == "" {
+		return nil, errors.New("installedapp: incomplete device authorization response")
+	}
+	fmt.Fprintf(c.output(), "To authorize this application, visit:\n\n%s\n\nand enter the code: %s\n\n", verificationURL, auth.UserCode)
+
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	for {
+		if err := sleep(ctx, interval); err != nil {
+			return nil, err
+		}
+		if auth.ExpiresIn > 0 && time.Now().After(deadline) {
+			return nil, errors.New("installedapp: device authorization expired")
+		}
+		var resp tokenResponse
+		now := time.Now()
+		_, err := postForm(ctx, oc.Endpoint.TokenURL, url.Values{
+			"client_id":     {oc.ClientID},
+			"client_secret": {oc.ClientSecret},
+			"device_code":   {auth.DeviceCode},
+			"grant_type":    {"urn:ietf:params:oauth:grant-type:device_code"},
+		}, &resp)
+		if err != nil {
+			return nil, fmt.Errorf("installedapp: unable to get token: %v", err)
+		}
+		switch resp.Error {
+		case "":
+			if resp.AccessToken == "" {
+				return nil, errors.New("installedapp: token response has no access token")
+			}
+			tok := &oauth2.Token{
+				AccessToken:  resp.AccessToken,
+				TokenType:    resp.TokenType,
+				RefreshToken: resp.RefreshToken,
+			}
+			if resp.ExpiresIn > 0 {
+				tok.Expiry = now.Add(time.Duration(resp.ExpiresIn) * time.Second)
+			}
+			return tok, nil
+		case "authorization_pending":
+		case "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, fmt.Errorf("installedapp: device authorization failed: %s", resp.Error)
+		}
+	}
+}
+
+// postForm posts form to endpoint with the HTTP client of ctx, as oauth2 does,
+// and unmarshals the JSON response into resp whatever its status, which it
+// returns.
+func postForm(ctx context.Context, endpoint string, form url.Values, resp interface{}) (int, error) {
+	req, err := http.NewRequest("POST", endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return 0, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r, err := oauth2.NewClient(ctx, nil).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		return 0, fmt.Errorf("unable to read body: %v", err)
+	}
+	if err := json.Unmarshal(body, resp); err != nil {
+		return 0, fmt.Errorf("status code %d: %s", r.StatusCode, body)
+	}
+	return r.StatusCode, nil
+}
@@ -0,0 +1,226 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package installedapp provides the credentials of a user of an installed
+// application, like a command-line tool: the user authorizes the application
+// once, in a browser, and the refresh token obtained is cached in a file for
+// later runs.
+package installedapp
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+// Config for getting the credentials of a user of an installed application.
+type Config struct {
+	// ClientSecretJSON is the client secret JSON file of the application, as
+	// downloaded from the Google Cloud Console. Required.
+	ClientSecretJSON []byte
+	// CacheDir is the directory of the token cache. Defaults to a
+	// directory in the user's cache directory. Optional.
+	CacheDir string
+	// DeviceCode selects the device authorization flow, in which the user
+	// authorizes the application on another device, in place of the flow
+	// that opens a browser on this one. It suits machines without a
+	// browser, like remote servers. Optional.
+	DeviceCode bool
+
+	// out is where the user is told what to do. Defaults to os.Stderr.
+	out io.Writer
+	// openURL opens a URL in a browser. Defaults to openBrowser.
+	openURL func(url string) error
+	// deviceAuthURL is the device authorization endpoint. Defaults to
+	// defaultDeviceAuthURL.
+	deviceAuthURL string
+}
+
+const defaultDeviceAuthURL = "https://oauth2.googleapis.com/device/code"
+
+// TokenSource returns a TokenSource of access tokens with the scopes for the
+// user of the application in c. If the token cache has no token for the
+// application and scopes, the user is asked to authorize the application,
+// and TokenSource waits until they do or ctx is done. The tokens are
+// refreshed as needed, and the refreshed tokens cached.
+func TokenSource(ctx context.Context, c *Config, scopes []string) (oauth2.TokenSource, error) {
+	if len(scopes) == 0 {
+		return nil, errors.New("installedapp: scopes must be provided")
+	}
+	oc, err := google.ConfigFromJSON(c.ClientSecretJSON, scopes...)
+	if err != nil {
+		return nil, fmt.Errorf("installedapp: %v", err)
+	}
+	file, err := c.cacheFile(oc.ClientID, scopes)
+	if err != nil {
+		return nil, err
+	}
+	tok, err := readToken(file)
+	if err != nil {
+		if c.DeviceCode {
+			tok, err = c.deviceFlow(ctx, oc)
+		} else {
+			tok, err = c.loopbackFlow(ctx, oc)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if err := writeToken(file, tok); err != nil {
+			return nil, err
+		}
+	}
+	return &cachingTokenSource{base: oc.TokenSource(ctx, tok), file: file, last: tok}, nil
+}
+
+func (c *Config) output() io.Writer {
+	if c.out != nil {
+		return c.out
+	}
+	return os.Stderr
+}
+
+// cacheFile returns the path of the file that caches the token of the
+// client for the scopes, in any order.
+func (c *Config) cacheFile(clientID string, scopes []string) (string, error) {
+	dir := c.CacheDir
+	if dir == "" {
+		d, err := os.UserCacheDir()
+		if err != nil {
+			return "", fmt.Errorf("installedapp: no cache directory: %v", err)
+		}
+		dir = filepath.Join(d, "google-api-go-client", "installedapp")
+	}
+	sorted := append([]string(nil), scopes...)
+	sort.Strings(sorted)
+	h := sha256.New()
+	io.WriteString(h, clientID)
+	for _, s := range sorted {
+		io.WriteString(h, "\x00"+s)
+	}
+	return filepath.Join(dir, "token-"+hex.EncodeToString(h.Sum(nil)[:16])+".json"), nil
+}
+
+// readToken returns the cached token in file. It returns an error if there
+// is none, or no refresh token in it.
+func readToken(file string) (*oauth2.Token, error) {
+	b, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var tok oauth2.Token
+	if err := json.Unmarshal(b, &tok); err != nil {
+		return nil, err
+	}
+	if tok.RefreshToken == "" {
+		return nil, errors.New("installedapp: cached token has no refresh token")
+	}
+	return &tok, nil
+}
+
+// writeToken caches tok in file, which only the user can read. The file is
+// replaced at once, so that a reader never sees a partial token.
+func writeToken(file string, tok *oauth2.Token) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return fmt.Errorf("installedapp: unable to create cache directory: %v", err)
+	}
+	b, err := json.Marshal(tok)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(file), ".token-")
+	if err != nil {
+		return fmt.Errorf("installedapp: unable to cache token: %v", err)
+	}
+	defer os.Remove(f.Name()) // Fails after the rename.
+	// TempFile creates files with mode 0600, but umask can't loosen a chmod.
+	if err := f.Chmod(0600); err != nil {
+		f.Close()
+		return fmt.Errorf("installedapp: unable to cache token: %v", err)
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return fmt.Errorf("installedapp: unable to cache token: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		return fmt.Errorf("installedapp: unable to cache token: %v", err)
+	}
+	if err := os.Rename(f.Name(), file); err != nil {
+		return fmt.Errorf("installedapp: unable to cache token: %v", err)
+	}
+	return nil
+}
+
+// cachingTokenSource caches the tokens of base in file when they change.
+type cachingTokenSource struct {
+	base oauth2.TokenSource
+	file string
+
+	mu   sync.Mutex
+	last *oauth2.Token
+}
+
+// Token returns a token from the base TokenSource. If the refresh token was
+// rejected, the cached token is removed, so that the user is asked to
+// authorize the application again on the next run.
+func (s *cachingTokenSource) Token() (*oauth2.Token, error) {
+	tok, err := s.base.Token()
+	if err != nil {
+		if re, ok := err.(*oauth2.RetrieveError); ok && re.Response.StatusCode == http.StatusBadRequest &&
+			strings.Contains(string(re.Body), "invalid_grant") {
+			os.Remove(s.file)
+		}
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if tok.AccessToken != s.last.AccessToken || tok.RefreshToken != s.last.RefreshToken {
+		// Failing to cache the token is not fatal: the next run gets
+		// another one with the old refresh token.
+		if err := writeToken(s.file, tok); err == nil {
+			s.last = tok
+		}
+	}
+	return tok, nil
+}
+
+// randomString returns a random URL-safe string of n bytes of entropy.
+func randomString(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// openBrowser opens url in the user's browser.
+func openBrowser(url string) error {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", url)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", url)
+	default:
+		cmd = exec.Command("xdg-open", url)
+	}
+	return cmd.Start()
+}
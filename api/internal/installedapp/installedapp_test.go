@@ -0,0 +1,335 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package installedapp
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+var testScopes = []string{"https://www.googleapis.com/auth/a", "https://www.googleapis.com/auth/b"}
+
+// fakeOAuth is an OAuth 2.0 server for the client "cid".
+type fakeOAuth struct {
+	t *testing.T
+
+	mu          sync.Mutex
+	challenge   string   // code_challenge of the authorization request
+	redirectURL string   // redirect_uri of the authorization request
+	device      []string // errors returned to device code polls before the token
+	revoked     bool     // whether the refresh token is revoked
+	refreshes   int
+}
+
+func (f *fakeOAuth) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w.Header().Set("Content-Type", "application/json")
+	if r.FormValue("client_id") != "cid" {
+		if id, secret, _ := r.BasicAuth(); id != "cid" || secret != "csecret" {
+			http.Error(w, `{"error": "invalid_client"}`, http.StatusUnauthorized)
+			return
+		}
+	}
+	switch r.URL.Path {
+	case "/device/code":
+		if got, want := r.FormValue("scope"), strings.Join(testScopes, " "); got != want {
+			f.t.Errorf("got device scope %q, want %q", got, want)
+		}
+		fmt.Fprint(w, `{"device_code": "dcode", "user_code": "ABC-DEF", "verification_url": "https://example.com/device",
+			"expires_in": 1800, "interval": 1}`)
+	case "/token":
+		switch r.FormValue("grant_type") {
+		case "authorization_code":
+			h := sha256.Sum256([]byte(r.FormValue("code_verifier")))
+			if r.FormValue("code") != "acode" || base64.RawURLEncoding.EncodeToString(h[:]) != f.challenge ||
+				r.FormValue("redirect_uri") != f.redirectURL {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "access-0", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600}`)
+		case "urn:ietf:params:oauth:grant-type:device_code":
+			if r.FormValue("device_code") != "dcode" || r.FormValue("client_secret") != "csecret" {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			if len(f.device) > 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				fmt.Fprintf(w, `{"error": %q}`, f.device[0])
+				f.device = f.device[1:]
+				return
+			}
+			fmt.Fprint(w, `{"access_token": "access-0", "refresh_token": "refresh", "token_type": "Bearer", "expires_in": 3600}`)
+		case "refresh_token":
+			if f.revoked || r.FormValue("refresh_token") != "refresh" {
+				http.Error(w, `{"error": "invalid_grant"}`, http.StatusBadRequest)
+				return
+			}
+			f.refreshes++
+			fmt.Fprintf(w, `{"access_token": "access-%d", "token_type": "Bearer", "expires_in": 3600}`, f.refreshes)
+		default:
+			http.Error(w, `{"error": "unsupported_grant_type"}`, http.StatusBadRequest)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// browser returns a function that follows the authorization URL like a
+// browser whose user authorizes the application, or denies it access if
+// errParam is not "".
+func (f *fakeOAuth) browser(errParam string) func(string) error {
+	return func(authURL string) error {
+		u, err := url.Parse(authURL)
+		if err != nil {
+			f.t.Fatal(err)
+		}
+		q := u.Query()
+		if q.Get("client_id") != "cid" || q.Get("code_challenge_method") != "S256" || q.Get("access_type") != "offline" {
+			f.t.Errorf("bad authorization URL %s", authURL)
+		}
+		f.mu.Lock()
+		f.challenge, f.redirectURL = q.Get("code_challenge"), q.Get("redirect_uri")
+		f.mu.Unlock()
+		get := func(params string) {
+			resp, err := http.Get(q.Get("redirect_uri") + params)
+			if err != nil {
+				f.t.Fatal(err)
+			}
+			resp.Body.Close()
+		}
+		// Requests that are not redirects are ignored.
+		get("favicon.ico")
+		get("?state=other&code=bad")
+		if errParam != "" {
+			get("?state=" + url.QueryEscape(q.Get("state")) + "&error=" + errParam)
+		} else {
+			get("?state=" + url.QueryEscape(q.Get("state")) + "&code=acode")
+		}
+		return nil
+	}
+}
+
+// setup returns a Config for the client "cid" of the server f, with a new
+// cache directory, and a function that cleans up.
+func setup(t *testing.T, f *fakeOAuth) (*Config, func()) {
+	server := httptest.NewServer(f)
+	dir, err := ioutil.TempDir("", "installedapp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldSleep := sleep
+	sleep = func(context.Context, time.Duration) error { return nil }
+	c := &Config{
+		ClientSecretJSON: []byte(fmt.Sprintf(`{"installed": {"client_id": "cid", "client_secret": "csecret",
+			"auth_uri": "%[1]s/auth", "token_uri": "%[1]s/token", "redirect_uris": ["http://localhost"]}}`, server.URL)),
+		CacheDir:      filepath.Join(dir, "cache"),
+		out:           ioutil.Discard,
+		openURL:       f.browser(""),
+		deviceAuthURL: server.URL + "/device/code",
+	}
+	return c, func() {
+		sleep = oldSleep
+		server.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func token(t *testing.T, ts oauth2.TokenSource) string {
+	t.Helper()
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok.AccessToken
+}
+
+// cachedToken returns the access token in the cache of c.
+func cachedToken(t *testing.T, c *Config) string {
+	t.Helper()
+	file, err := c.cacheFile("cid", testScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := readToken(file)
+	if err != nil {
+		return ""
+	}
+	return tok.AccessToken
+}
+
+func TestLoopbackFlow(t *testing.T) {
+	f := &fakeOAuth{t: t}
+	c, cleanup := setup(t, f)
+	defer cleanup()
+
+	ts, err := TokenSource(context.Background(), c, testScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := token(t, ts); got != "access-0" {
+		t.Errorf("got token %q, want access-0", got)
+	}
+	if got := cachedToken(t, c); got != "access-0" {
+		t.Errorf("got cached token %q, want access-0", got)
+	}
+	if runtime.GOOS != "windows" {
+		file, _ := c.cacheFile("cid", testScopes)
+		for path, want := range map[string]os.FileMode{file: 0600, c.CacheDir: 0700} {
+			if fi, err := os.Stat(path); err != nil || fi.Mode().Perm() != want {
+				t.Errorf("%s: got mode %v, %v, want %v", path, fi.Mode().Perm(), err, want)
+			}
+		}
+	}
+
+	// The cached token is used, whatever the order of the scopes.
+	c.openURL = func(string) error {
+		t.Error("got a new authorization, want the cached token")
+		return nil
+	}
+	ts, err = TokenSource(context.Background(), c, []string{testScopes[1], testScopes[0]})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := token(t, ts); got != "access-0" {
+		t.Errorf("got token %q, want access-0", got)
+	}
+}
+
+func TestLoopbackFlowDenied(t *testing.T) {
+	f := &fakeOAuth{t: t}
+	c, cleanup := setup(t, f)
+	defer cleanup()
+	c.openURL = f.browser("access_denied")
+
+	_, err := TokenSource(context.Background(), c, testScopes)
+	if err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Errorf("got %v, want an error about access_denied", err)
+	}
+	if got := cachedToken(t, c); got != "" {
+		t.Errorf("got cached token %q, want none", got)
+	}
+}
+
+func TestLoopbackFlowCanceled(t *testing.T) {
+	f := &fakeOAuth{t: t}
+	c, cleanup := setup(t, f)
+	defer cleanup()
+	ctx, cancel := context.WithCancel(context.Background())
+	c.openURL = func(string) error {
+		cancel() // The user never comes back.
+		return nil
+	}
+
+	if _, err := TokenSource(ctx, c, testScopes); err != context.Canceled {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+}
+
+func TestDeviceFlow(t *testing.T) {
+	f := &fakeOAuth{t: t, device: []string{"authorization_pending", "slow_down", "authorization_pending"}}
+	c, cleanup := setup(t, f)
+	defer cleanup()
+	var out bytes.Buffer
+	c.out = &out
+	c.DeviceCode = true
+	var pauses []time.Duration
+	sleep = func(ctx context.Context, d time.Duration) error {
+		pauses = append(pauses, d)
+		return nil
+	}
+
+	ts, err := TokenSource(context.Background(), c, testScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := token(t, ts); got != "access-0" {
+		t.Errorf("got token %q, want access-0", got)
+	}
+	if got := cachedToken(t, c); got != "access-0" {
+		t.Errorf("got cached token %q, want access-0", got)
+	}
+	if !strings.Contains(out.String(), "https://example.com/device") || !strings.Contains(out.String(), "ABC-DEF") {
+		t.Errorf("got output %q, want the verification URL and user code", out.String())
+	}
+	want := []time.Duration{time.Second, time.Second, 6 * time.Second, 6 * time.Second}
+	if fmt.Sprint(pauses) != fmt.Sprint(want) {
+		t.Errorf("got pauses %v, want %v", pauses, want)
+	}
+}
+
+func TestDeviceFlowDenied(t *testing.T) {
+	f := &fakeOAuth{t: t, device: []string{"authorization_pending", "access_denied"}}
+	c, cleanup := setup(t, f)
+	defer cleanup()
+	c.DeviceCode = true
+
+	_, err := TokenSource(context.Background(), c, testScopes)
+	if err == nil || !strings.Contains(err.Error(), "access_denied") {
+		t.Errorf("got %v, want an error about access_denied", err)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	f := &fakeOAuth{t: t}
+	c, cleanup := setup(t, f)
+	defer cleanup()
+	file, err := c.cacheFile("cid", testScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expired := &oauth2.Token{AccessToken: "expired", RefreshToken: "refresh", Expiry: time.Now().Add(-time.Minute)}
+	if err := writeToken(file, expired); err != nil {
+		t.Fatal(err)
+	}
+
+	ts, err := TokenSource(context.Background(), c, testScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := token(t, ts); got != "access-1" {
+		t.Errorf("got token %q, want access-1", got)
+	}
+	// The refreshed token is cached, with the refresh token.
+	tok, err := readToken(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != "access-1" || tok.RefreshToken != "refresh" {
+		t.Errorf("got cached token %q with refresh token %q, want access-1 with refresh", tok.AccessToken, tok.RefreshToken)
+	}
+
+	// A revoked refresh token is removed from the cache.
+	if err := writeToken(file, expired); err != nil {
+		t.Fatal(err)
+	}
+	f.revoked = true
+	ts, err = TokenSource(context.Background(), c, testScopes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ts.Token(); err == nil {
+		t.Error("got nil, want an error")
+	}
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("got %v, want the cached token removed", err)
+	}
+}
@@ -0,0 +1,213 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+	"golang.org/x/oauth2/google"
+)
+
+const (
+	// jwtLifetime is how long a self-signed JWT is valid.
+	jwtLifetime = time.Hour
+
+	// jwtRenewBefore is how long before it expires a cached self-signed JWT
+	// is replaced by a new one.
+	jwtRenewBefore = 5 * time.Minute
+)
+
+// jwtAudiences returns the audiences of the self-signed JWTs used with ds:
+// those given with option.WithAudiences, else the default audience of the
+// service, else one derived from its default endpoint. It returns nil if
+// none is known.
+func jwtAudiences(ds *DialSettings) []string {
+	if len(ds.Audiences) > 0 {
+		return ds.Audiences
+	}
+	if ds.DefaultAudience != "" {
+		return []string{ds.DefaultAudience}
+	}
+	// The default endpoint comes first: a user-provided endpoint may be a
+	// regional or private one, which the service doesn't know itself by.
+	for _, e := range []string{ds.DefaultEndpoint, ds.Endpoint} {
+		if aud := audienceFromEndpoint(e); aud != "" {
+			return []string{aud}
+		}
+	}
+	return nil
+}
+
+// audienceFromEndpoint returns the audience of self-signed JWTs for a service
+// at endpoint, which is a URL for HTTP, like
+// "https://pubsub.googleapis.com/v1/", or a host[:port] for gRPC, like
+// "pubsub.googleapis.com:443". Both give "https://pubsub.googleapis.com/".
+// It returns "" if endpoint has no host.
+func audienceFromEndpoint(endpoint string) string {
+	host := strings.TrimPrefix(endpoint, "dns:///")
+	if strings.Contains(host, "://") {
+		u, err := url.Parse(host)
+		if err != nil {
+			return ""
+		}
+		host = u.Host
+	}
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	if host == "" {
+		return ""
+	}
+	return "https://" + host + "/"
+}
+
+// selfSignedJWTSource is a TokenSource of JWTs signed with the key of a
+// service account, which some services accept in place of OAuth 2.0 access
+// tokens. A JWT is cached until shortly before it expires.
+type selfSignedJWTSource struct {
+	email     string
+	keyID     string
+	key       *rsa.PrivateKey
+	audiences []string // the "aud" claim, if any
+	scopes    []string // the "scope" claim, if any
+	now       func() time.Time
+
+	mu  sync.Mutex
+	tok *oauth2.Token
+}
+
+// newSelfSignedJWTSource returns a source of JWTs signed with the service
+// account key in data, with the given audiences or scopes.
+func newSelfSignedJWTSource(data []byte, audiences, scopes []string) (*selfSignedJWTSource, error) {
+	cfg, err := google.JWTConfigFromJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse JSON key: %v", err)
+	}
+	key, err := parseRSAKey(cfg.PrivateKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse key: %v", err)
+	}
+	return &selfSignedJWTSource{
+		email:     cfg.Email,
+		keyID:     cfg.PrivateKeyID,
+		key:       key,
+		audiences: audiences,
+		scopes:    scopes,
+		now:       time.Now,
+	}, nil
+}
+
+// parseRSAKey parses a PEM-encoded RSA private key, in PKCS #8 or PKCS #1
+// form. It is the same as ParseKey in golang.org/x/oauth2/internal, which
+// can't be imported.
+func parseRSAKey(data []byte) (*rsa.PrivateKey, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	k, err := x509.ParsePKCS8PrivateKey(data)
+	if err != nil {
+		if k, err = x509.ParsePKCS1PrivateKey(data); err != nil {
+			return nil, err
+		}
+	}
+	key, ok := k.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// Token returns the cached JWT, or signs a new one if it is about to expire.
+func (s *selfSignedJWTSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.tok != nil && now.Add(jwtRenewBefore).Before(s.tok.Expiry) {
+		return s.tok, nil
+	}
+	tok, err := s.sign(now)
+	if err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+// Refresh signs a new JWT even if the cached one is still valid, and caches
+// it. It lets a background refresh get past the cache.
+func (s *selfSignedJWTSource) Refresh() (*oauth2.Token, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tok, err := s.sign(s.now())
+	if err != nil {
+		return nil, err
+	}
+	s.tok = tok
+	return tok, nil
+}
+
+// sign returns a new JWT issued at iat.
+//
+// It doesn't use jws.EncodeWithSigner from golang.org/x/oauth2/jws: the "aud"
+// claim of a jws.ClaimSet is a string that is always encoded, so it can hold
+// neither several audiences nor none, as JWTs with scopes need. Putting "aud"
+// in PrivateClaims instead would encode the key twice.
+func (s *selfSignedJWTSource) sign(iat time.Time) (*oauth2.Token, error) {
+	exp := iat.Add(jwtLifetime)
+	header := map[string]string{"alg": "RS256", "typ": "JWT"}
+	if s.keyID != "" {
+		header["kid"] = s.keyID
+	}
+	claims := map[string]interface{}{
+		"iss": s.email,
+		"sub": s.email,
+		"iat": iat.Unix(),
+		"exp": exp.Unix(),
+	}
+	switch len(s.audiences) {
+	case 0:
+	case 1:
+		claims["aud"] = s.audiences[0]
+	default:
+		claims["aud"] = s.audiences
+	}
+	if len(s.scopes) > 0 {
+		claims["scope"] = strings.Join(s.scopes, " ")
+	}
+	var parts []string
+	for _, v := range []interface{}{header, claims} {
+		b, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, base64.RawURLEncoding.EncodeToString(b))
+	}
+	msg := strings.Join(parts, ".")
+	h := sha256.Sum256([]byte(msg))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, s.key, crypto.SHA256, h[:])
+	if err != nil {
+		return nil, fmt.Errorf("could not sign JWT: %v", err)
+	}
+	return &oauth2.Token{
+		AccessToken: msg + "." + base64.RawURLEncoding.EncodeToString(sig),
+		TokenType:   "Bearer",
+		Expiry:      exp,
+	}, nil
+}
@@ -0,0 +1,211 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestAudienceFromEndpoint(t *testing.T) {
+	for _, tc := range []struct {
+		endpoint, want string
+	}{
+		{"https://foo.googleapis.com/v1/", "https://foo.googleapis.com/"},
+		{"https://foo.googleapis.com:443/storage/v1/", "https://foo.googleapis.com/"},
+		{"foo.googleapis.com:443", "https://foo.googleapis.com/"},
+		{"dns:///foo.googleapis.com:443", "https://foo.googleapis.com/"},
+		{"foo.googleapis.com", "https://foo.googleapis.com/"},
+		{"", ""},
+		{"https:///v1/", ""},
+	} {
+		if got := audienceFromEndpoint(tc.endpoint); got != tc.want {
+			t.Errorf("audienceFromEndpoint(%q) = %q, want %q", tc.endpoint, got, tc.want)
+		}
+	}
+}
+
+func TestJWTAudiences(t *testing.T) {
+	for _, tc := range []struct {
+		desc string
+		ds   *DialSettings
+		want []string
+	}{
+		{"none", &DialSettings{}, nil},
+		{
+			"default endpoint",
+			&DialSettings{DefaultEndpoint: "foo.googleapis.com:443", Endpoint: "foo.example.com:443"},
+			[]string{"https://foo.googleapis.com/"},
+		},
+		{
+			"endpoint",
+			&DialSettings{Endpoint: "https://foo.example.com/v1/"},
+			[]string{"https://foo.example.com/"},
+		},
+		{
+			"default audience",
+			&DialSettings{DefaultAudience: "https://bar.googleapis.com/", DefaultEndpoint: "foo.googleapis.com:443"},
+			[]string{"https://bar.googleapis.com/"},
+		},
+		{
+			"audiences",
+			&DialSettings{Audiences: []string{"a", "b"}, DefaultAudience: "c", DefaultEndpoint: "foo.googleapis.com:443"},
+			[]string{"a", "b"},
+		},
+	} {
+		if got := jwtAudiences(tc.ds); !cmp.Equal(got, tc.want) {
+			t.Errorf("%s: got %q, want %q", tc.desc, got, tc.want)
+		}
+	}
+}
+
+func TestSelfSignedJWT(t *testing.T) {
+	ctx := context.Background()
+	for _, tc := range []struct {
+		desc string
+		ds   *DialSettings
+		want map[string]interface{} // nil for an OAuth 2.0 flow
+	}{
+		{
+			"gRPC default endpoint",
+			&DialSettings{DefaultEndpoint: "foo.googleapis.com:443"},
+			map[string]interface{}{"aud": "https://foo.googleapis.com/"},
+		},
+		{
+			"HTTP default endpoint",
+			&DialSettings{DefaultEndpoint: "https://foo.googleapis.com/foo/v1/"},
+			map[string]interface{}{"aud": "https://foo.googleapis.com/"},
+		},
+		{
+			"multiple audiences",
+			&DialSettings{Audiences: []string{"a", "b"}},
+			map[string]interface{}{"aud": []interface{}{"a", "b"}},
+		},
+		{
+			"scopes",
+			&DialSettings{Scopes: []string{"s1", "s2"}, EnableJWTWithScope: true, DefaultEndpoint: "foo.googleapis.com:443"},
+			map[string]interface{}{"scope": "s1 s2"},
+		},
+		{
+			"scopes not enabled",
+			&DialSettings{Scopes: []string{"s1"}, DefaultEndpoint: "foo.googleapis.com:443"},
+			nil,
+		},
+		{
+			"no audience",
+			&DialSettings{},
+			nil,
+		},
+	} {
+		cred, err := credentialsFromJSON(ctx, []byte(validServiceAccountJSON), tc.ds)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		ts, ok := cred.TokenSource.(*selfSignedJWTSource)
+		if tc.want == nil {
+			if ok {
+				t.Errorf("%s: got a self-signed JWT, want OAuth 2.0", tc.desc)
+			}
+			continue
+		}
+		if !ok {
+			t.Errorf("%s: got token source %T, want a self-signed JWT", tc.desc, cred.TokenSource)
+			continue
+		}
+		tok, err := ts.Token()
+		if err != nil {
+			t.Fatalf("%s: %v", tc.desc, err)
+		}
+		claims := verifyJWT(t, tok.AccessToken, &ts.key.PublicKey)
+		const email = "dumba-504@appspot.gserviceaccount.com"
+		if claims["iss"] != email || claims["sub"] != email {
+			t.Errorf("%s: got iss %v, sub %v, want %s", tc.desc, claims["iss"], claims["sub"], email)
+		}
+		if exp, iat := claims["exp"].(float64), claims["iat"].(float64); exp-iat != jwtLifetime.Seconds() {
+			t.Errorf("%s: got lifetime %vs, want %v", tc.desc, exp-iat, jwtLifetime)
+		}
+		for _, k := range []string{"aud", "scope"} {
+			if !cmp.Equal(claims[k], tc.want[k]) {
+				t.Errorf("%s: got %s %v, want %v", tc.desc, k, claims[k], tc.want[k])
+			}
+		}
+	}
+}
+
+// verifyJWT checks the signature of the JWT tok, and returns its claims.
+func verifyJWT(t *testing.T, tok string, key *rsa.PublicKey) map[string]interface{} {
+	t.Helper()
+	parts := strings.Split(tok, ".")
+	if len(parts) != 3 {
+		t.Fatalf("got a JWT of %d parts, want 3", len(parts))
+	}
+	var header map[string]string
+	var claims map[string]interface{}
+	for i, v := range []interface{}{&header, &claims} {
+		b, err := base64.RawURLEncoding.DecodeString(parts[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(b, v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if header["alg"] != "RS256" || header["kid"] != "adsfsdd" {
+		t.Errorf("got header %v", header)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, h[:], sig); err != nil {
+		t.Errorf("bad signature: %v", err)
+	}
+	return claims
+}
+
+func TestSelfSignedJWTCache(t *testing.T) {
+	ts, err := newSelfSignedJWTSource([]byte(validServiceAccountJSON), []string{"a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	ts.now = func() time.Time { return now }
+	first, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The JWT is cached until shortly before it expires.
+	now = now.Add(jwtLifetime - jwtRenewBefore - time.Second)
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken != first.AccessToken {
+		t.Error("got a new JWT, want the cached one")
+	}
+
+	now = now.Add(2 * time.Second)
+	tok, err = ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tok.AccessToken == first.AccessToken {
+		t.Error("got the cached JWT, want a new one")
+	}
+	if want := now.Add(jwtLifetime); !tok.Expiry.Equal(want) {
+		t.Errorf("got expiry %v, want %v", tok.Expiry, want)
+	}
+}
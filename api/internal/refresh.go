@@ -0,0 +1,150 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"sync"
+	"time"
+
+	"golang.org/x/oauth2"
+)
+
+const (
+	// minTokenValidity is the least remaining lifetime a cached token must
+	// have to be returned while a refresh happens in the background.
+	minTokenValidity = 3 * time.Second
+
+	// refreshRetryDelay is how long to wait before retrying a failed
+	// background refresh.
+	refreshRetryDelay = 5 * time.Second
+)
+
+// expiredToken is handed to oauth2.ReuseTokenSource to force a refresh, see
+// refreshToken.
+var expiredToken = &oauth2.Token{AccessToken: "expired", Expiry: time.Unix(1, 0)}
+
+// AsyncRefreshTokenSource returns a TokenSource that caches tokens from ts and
+// fetches a new token in the background once fraction of the cached token's
+// lifetime has elapsed. Callers keep receiving the cached token while the
+// refresh is in flight, so requests made around token expiry don't stall on
+// the token endpoint. Concurrent refreshes are coalesced into a single call to
+// ts. Callers only block when there is no cached token or it is about to
+// expire.
+//
+// A fraction outside of (0, 1] disables background refresh, and ts is
+// returned unchanged.
+func AsyncRefreshTokenSource(ts oauth2.TokenSource, fraction float64) oauth2.TokenSource {
+	if fraction <= 0 || fraction > 1 {
+		return ts
+	}
+	return &asyncRefreshTokenSource{
+		new:      ts,
+		fraction: fraction,
+		now:      time.Now,
+	}
+}
+
+// refresher is implemented by token sources with a cache of their own, such
+// as impersonated credentials and self-signed JWTs. Refresh fetches a new
+// token even if the cached one is still valid, and caches it.
+type refresher interface {
+	Refresh() (*oauth2.Token, error)
+}
+
+// refreshToken fetches a new token from ts. Most token sources, including the
+// ones in google.Credentials, are an oauth2.ReuseTokenSource that would hand
+// back its cached token until shortly before it expires. Wrapping ts with
+// oauth2.ReuseTokenSource builds a new cache around the source it wraps, and
+// seeding that cache with an expired token makes it fetch a new one. That
+// doesn't get past other caches, which are refreshed through refresher.
+func refreshToken(ts oauth2.TokenSource) (*oauth2.Token, error) {
+	if r, ok := ts.(refresher); ok {
+		return r.Refresh()
+	}
+	return oauth2.ReuseTokenSource(expiredToken, ts).Token()
+}
+
+type asyncRefreshTokenSource struct {
+	new      oauth2.TokenSource
+	fraction float64
+	now      func() time.Time
+
+	mu        sync.Mutex
+	t         *oauth2.Token
+	refreshAt time.Time    // zero if no background refresh is needed
+	inflight  *tokenResult // non-nil while a refresh is in flight
+}
+
+// tokenResult is the outcome of a single call to the underlying TokenSource.
+// done is closed once t and err are set.
+type tokenResult struct {
+	done chan struct{}
+	t    *oauth2.Token
+	err  error
+}
+
+// Token returns the cached token if it is still valid, starting a background
+// refresh if it is due. Otherwise it waits for a new token.
+func (s *asyncRefreshTokenSource) Token() (*oauth2.Token, error) {
+	s.mu.Lock()
+	now := s.now()
+	if s.t != nil && (s.t.Expiry.IsZero() || now.Add(minTokenValidity).Before(s.t.Expiry)) {
+		if !s.refreshAt.IsZero() && !now.Before(s.refreshAt) && s.inflight == nil {
+			s.startRefresh()
+		}
+		t := s.t
+		s.mu.Unlock()
+		return t, nil
+	}
+	if s.inflight == nil {
+		s.startRefresh()
+	}
+	r := s.inflight
+	s.mu.Unlock()
+
+	<-r.done
+	return r.t, r.err
+}
+
+// startRefresh fetches a new token in a new goroutine. s.mu must be held.
+func (s *asyncRefreshTokenSource) startRefresh() {
+	r := &tokenResult{done: make(chan struct{})}
+	s.inflight = r
+	go func() {
+		t, err := refreshToken(s.new)
+
+		s.mu.Lock()
+		now := s.now()
+		if err != nil {
+			s.refreshAt = now.Add(refreshRetryDelay)
+		} else {
+			s.setTokenLocked(t, now)
+		}
+		s.inflight = nil
+		s.mu.Unlock()
+
+		r.t, r.err = t, err
+		close(r.done)
+	}()
+}
+
+// setTokenLocked caches t and computes when it should be refreshed. s.mu must
+// be held.
+func (s *asyncRefreshTokenSource) setTokenLocked(t *oauth2.Token, now time.Time) {
+	prev := s.t
+	s.t = t
+	switch {
+	case t.Expiry.IsZero():
+		s.refreshAt = time.Time{}
+	case prev != nil && prev.AccessToken == t.AccessToken:
+		// s.new caches tokens in a way refreshToken can't get past. Leave
+		// the refresh to the blocking path once the token is about to
+		// expire rather than asking again for the same token.
+		s.refreshAt = time.Time{}
+	default:
+		lifetime := t.Expiry.Sub(now)
+		s.refreshAt = now.Add(time.Duration(float64(lifetime) * s.fraction))
+	}
+}
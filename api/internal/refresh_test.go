@@ -0,0 +1,326 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/oauth2"
+	"google.golang.org/api/internal/impersonate"
+)
+
+// countingTokenSource returns a new token valid for lifetime on every call.
+// If block is non-nil, calls wait for it to be closed.
+type countingTokenSource struct {
+	now      func() time.Time
+	lifetime time.Duration
+	block    chan struct{}
+	err      error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (ts *countingTokenSource) Token() (*oauth2.Token, error) {
+	if ts.block != nil {
+		<-ts.block
+	}
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	ts.calls++
+	if ts.err != nil {
+		return nil, ts.err
+	}
+	return &oauth2.Token{
+		AccessToken: fmt.Sprintf("token-%d", ts.calls),
+		Expiry:      ts.now().Add(ts.lifetime),
+	}, nil
+}
+
+func (ts *countingTokenSource) numCalls() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return ts.calls
+}
+
+type testClock struct {
+	mu sync.Mutex
+	t  time.Time
+}
+
+func (c *testClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.t
+}
+
+func (c *testClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.t = c.t.Add(d)
+}
+
+func newTestAsyncSource(inner *countingTokenSource, clock *testClock) *asyncRefreshTokenSource {
+	s := AsyncRefreshTokenSource(inner, 0.5).(*asyncRefreshTokenSource)
+	s.now = clock.Now
+	return s
+}
+
+// waitRefresh waits for any in-flight background refresh to finish.
+func waitRefresh(s *asyncRefreshTokenSource) {
+	s.mu.Lock()
+	r := s.inflight
+	s.mu.Unlock()
+	if r != nil {
+		<-r.done
+	}
+}
+
+func mustToken(t *testing.T, ts oauth2.TokenSource) string {
+	t.Helper()
+	tok, err := ts.Token()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok.AccessToken
+}
+
+func TestAsyncRefresh(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	inner := &countingTokenSource{now: clock.Now, lifetime: time.Hour}
+	s := newTestAsyncSource(inner, clock)
+
+	if got, want := mustToken(t, s), "token-1"; got != want {
+		t.Fatalf("got %q, want %q", got, want)
+	}
+	clock.Advance(20 * time.Minute)
+	if got, want := mustToken(t, s), "token-1"; got != want {
+		t.Fatalf("before refresh point: got %q, want %q", got, want)
+	}
+	if got := inner.numCalls(); got != 1 {
+		t.Fatalf("before refresh point: got %d calls, want 1", got)
+	}
+
+	// Past the refresh point the cached token is still returned while a new
+	// one is fetched in the background.
+	inner.block = make(chan struct{})
+	clock.Advance(20 * time.Minute)
+	for i := 0; i < 3; i++ {
+		if got, want := mustToken(t, s), "token-1"; got != want {
+			t.Fatalf("during refresh: got %q, want %q", got, want)
+		}
+	}
+	close(inner.block)
+	waitRefresh(s)
+	if got, want := mustToken(t, s), "token-2"; got != want {
+		t.Errorf("after refresh: got %q, want %q", got, want)
+	}
+	if got := inner.numCalls(); got != 2 {
+		t.Errorf("got %d calls, want 2", got)
+	}
+}
+
+func TestAsyncRefresh_CoalescesBlockingCalls(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	inner := &countingTokenSource{now: clock.Now, lifetime: time.Hour, block: make(chan struct{})}
+	s := newTestAsyncSource(inner, clock)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got, want := mustToken(t, s), "token-1"; got != want {
+				t.Errorf("got %q, want %q", got, want)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(inner.block)
+	wg.Wait()
+	if got := inner.numCalls(); got != 1 {
+		t.Errorf("got %d calls, want 1", got)
+	}
+}
+
+func TestAsyncRefresh_ExpiredTokenBlocks(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	inner := &countingTokenSource{now: clock.Now, lifetime: time.Hour}
+	s := newTestAsyncSource(inner, clock)
+
+	mustToken(t, s)
+	clock.Advance(time.Hour)
+	if got, want := mustToken(t, s), "token-2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAsyncRefresh_BackgroundError(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	inner := &countingTokenSource{now: clock.Now, lifetime: time.Hour}
+	s := newTestAsyncSource(inner, clock)
+
+	mustToken(t, s)
+	inner.err = errors.New("boom")
+	clock.Advance(40 * time.Minute)
+	mustToken(t, s)
+	waitRefresh(s)
+	// The failed refresh must not discard the cached, still valid token.
+	if got, want := mustToken(t, s), "token-1"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	clock.Advance(time.Hour)
+	if _, err := s.Token(); err == nil {
+		t.Error("got nil error for expired token and failing source, want error")
+	}
+}
+
+func TestAsyncRefresh_Disabled(t *testing.T) {
+	inner := &countingTokenSource{now: time.Now, lifetime: time.Hour}
+	if got := AsyncRefreshTokenSource(inner, 0); got != inner {
+		t.Errorf("got %T for fraction 0, want the TokenSource unchanged", got)
+	}
+}
+
+func TestAsyncRefresh_OAuth2ReuseTokenSource(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	inner := &countingTokenSource{now: clock.Now, lifetime: time.Hour}
+	s := AsyncRefreshTokenSource(oauth2.ReuseTokenSource(nil, inner), 0.5).(*asyncRefreshTokenSource)
+	s.now = clock.Now
+
+	mustToken(t, s)
+	clock.Advance(40 * time.Minute)
+	mustToken(t, s)
+	waitRefresh(s)
+	// The token cached by oauth2.ReuseTokenSource is still valid, but the
+	// background refresh must fetch a new one anyway.
+	if got, want := mustToken(t, s), "token-2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got := inner.numCalls(); got != 2 {
+		t.Errorf("got %d calls, want 2", got)
+	}
+}
+
+// cachingTokenSource hands back the same token until it expires, like a
+// cache that refreshToken can't get past.
+type cachingTokenSource struct {
+	countingTokenSource
+	t *oauth2.Token
+}
+
+func (ts *cachingTokenSource) Token() (*oauth2.Token, error) {
+	if ts.t == nil || !ts.now().Before(ts.t.Expiry) {
+		t, err := ts.countingTokenSource.Token()
+		if err != nil {
+			return nil, err
+		}
+		ts.t = t
+	}
+	return ts.t, nil
+}
+
+func TestAsyncRefresh_CachingInnerSource(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	inner := &cachingTokenSource{countingTokenSource: countingTokenSource{now: clock.Now, lifetime: time.Hour}}
+	s := AsyncRefreshTokenSource(inner, 0.5).(*asyncRefreshTokenSource)
+	s.now = clock.Now
+
+	mustToken(t, s)
+	clock.Advance(40 * time.Minute)
+	mustToken(t, s)
+	waitRefresh(s)
+	s.mu.Lock()
+	refreshAt := s.refreshAt
+	s.mu.Unlock()
+	if !refreshAt.IsZero() {
+		t.Errorf("got refreshAt %v after the same token was returned, want no further background refresh", refreshAt)
+	}
+
+	// Near expiry the blocking path still fetches a new token.
+	clock.Advance(20 * time.Minute)
+	if got, want := mustToken(t, s), "token-2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestAsyncRefresh_ImpersonatedSource(t *testing.T) {
+	var mu sync.Mutex
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		calls++
+		n := calls
+		mu.Unlock()
+		json.NewEncoder(w).Encode(map[string]string{
+			"accessToken": fmt.Sprintf("token-%d", n),
+			"expireTime":  time.Now().Add(time.Hour).Format(time.RFC3339),
+		})
+	}))
+	defer srv.Close()
+	its, err := impersonate.TokenSource(context.Background(), oauth2.StaticTokenSource(&oauth2.Token{AccessToken: "base"}), &impersonate.Config{
+		Target:   "sa@example.com",
+		Scopes:   []string{"scope"},
+		Endpoint: srv.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := &testClock{t: time.Now()}
+	s := AsyncRefreshTokenSource(its, 0.5).(*asyncRefreshTokenSource)
+	s.now = clock.Now
+
+	mustToken(t, s)
+	clock.Advance(40 * time.Minute)
+	mustToken(t, s)
+	waitRefresh(s)
+	// The impersonated token is cached, and still valid, but the background
+	// refresh must fetch a new one anyway.
+	if got, want := mustToken(t, s), "token-2"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	s.mu.Lock()
+	refreshAt := s.refreshAt
+	s.mu.Unlock()
+	if refreshAt.IsZero() {
+		t.Error("got no further background refresh, want one")
+	}
+}
+
+func TestAsyncRefresh_SelfSignedJWT(t *testing.T) {
+	clock := &testClock{t: time.Now()}
+	jwt, err := newSelfSignedJWTSource([]byte(validServiceAccountJSON), []string{"a"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwt.now = clock.Now
+	s := AsyncRefreshTokenSource(jwt, 0.5).(*asyncRefreshTokenSource)
+	s.now = clock.Now
+
+	first := mustToken(t, s)
+	clock.Advance(40 * time.Minute)
+	mustToken(t, s)
+	waitRefresh(s)
+	// The cached JWT is still valid, but the background refresh must sign a
+	// new one anyway.
+	if got := mustToken(t, s); got == first {
+		t.Error("got the first JWT after a background refresh, want a new one")
+	}
+	s.mu.Lock()
+	refreshAt := s.refreshAt
+	s.mu.Unlock()
+	if refreshAt.IsZero() {
+		t.Error("got no further background refresh, want one")
+	}
+}
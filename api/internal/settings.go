@@ -6,13 +6,18 @@
 package internal
 
 import (
+	"context"
 	"crypto/tls"
 	"errors"
+	"fmt"
+	"log"
 	"net/http"
+	"time"
 
 	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/internal/impersonate"
+	"google.golang.org/api/internal/installedapp"
 	"google.golang.org/grpc"
 )
 
@@ -30,6 +35,7 @@ type DialSettings struct {
 	UserAgent           string
 	APIKey              string
 	Audiences           []string
+	DefaultAudience     string
 	HTTPClient          *http.Client
 	GRPCDialOpts        []grpc.DialOption
 	GRPCConn            *grpc.ClientConn
@@ -39,8 +45,26 @@ type DialSettings struct {
 	TelemetryDisabled   bool
 	ClientCertSource    func(*tls.CertificateRequestInfo) (*tls.Certificate, error)
 	CustomClaims        map[string]interface{}
+	IncludeEmail        bool
 	SkipValidation      bool
 	ImpersonationConfig *impersonate.Config
+	// EnableJWTWithScope makes service account credentials use self-signed
+	// JWTs with the scopes in place of OAuth 2.0 access tokens.
+	EnableJWTWithScope bool
+	// InstalledAppConfig gets the credentials of a user of an installed
+	// application.
+	InstalledAppConfig *installedapp.Config
+	// TokenRefreshFraction is passed to AsyncRefreshTokenSource. Zero
+	// disables background refresh.
+	TokenRefreshFraction float64
+	// RequestCompression is the Content-Encoding of HTTP request bodies, or
+	// "" for none.
+	RequestCompression string
+	// RetryHooks are called before HTTP requests are retried.
+	RetryHooks []func(ctx context.Context, resp *http.Response, err error, delay time.Duration)
+	// RetryLogger logs HTTP request retries if LogRetries is set.
+	RetryLogger *log.Logger
+	LogRetries  bool
 
 	// Google API system parameters. For more information please read:
 	// https://cloud.google.com/apis/docs/system-parameters
@@ -48,12 +72,24 @@ type DialSettings struct {
 	RequestReason string
 }
 
+// iamCredentialsScope is the scope needed to call the IAM Credentials API.
+const iamCredentialsScope = "https://www.googleapis.com/auth/cloud-platform"
+
+// SetImpersonationScopes sets the scopes of ds, if it has none, for base
+// credentials that are used to impersonate a service account. Such
+// credentials only need to be able to call the IAM Credentials API.
+func (ds *DialSettings) SetImpersonationScopes() {
+	if len(ds.Scopes) == 0 {
+		ds.Scopes = []string{iamCredentialsScope}
+	}
+}
+
 // Validate reports an error if ds is invalid.
 func (ds *DialSettings) Validate() error {
 	if ds.SkipValidation {
 		return nil
 	}
-	hasCreds := ds.APIKey != "" || ds.TokenSource != nil || ds.CredentialsFile != "" || ds.Credentials != nil
+	hasCreds := ds.APIKey != "" || ds.TokenSource != nil || ds.CredentialsFile != "" || ds.Credentials != nil || ds.InstalledAppConfig != nil
 	if ds.NoAuth && hasCreds {
 		return errors.New("options.WithoutAuthentication is incompatible with any option that provides credentials")
 	}
@@ -76,6 +112,9 @@ func (ds *DialSettings) Validate() error {
 	if ds.TokenSource != nil {
 		nCreds++
 	}
+	if ds.InstalledAppConfig != nil {
+		nCreds++
+	}
 	if len(ds.Scopes) > 0 && len(ds.Audiences) > 0 {
 		return errors.New("WithScopes is incompatible with WithAudience")
 	}
@@ -107,6 +146,21 @@ func (ds *DialSettings) Validate() error {
 	if ds.ClientCertSource != nil && (ds.GRPCConn != nil || ds.GRPCConnPool != nil || ds.GRPCConnPoolSize != 0 || ds.GRPCDialOpts != nil) {
 		return errors.New("WithClientCertSource is currently only supported for HTTP. gRPC settings are incompatible")
 	}
+	if ds.TokenRefreshFraction < 0 || ds.TokenRefreshFraction > 1 {
+		return errors.New("WithTokenRefreshFraction requires a fraction between 0 and 1")
+	}
+	if ds.RequestCompression != "" && ds.RequestCompression != "gzip" {
+		return fmt.Errorf("WithRequestCompression: unsupported encoding %q", ds.RequestCompression)
+	}
+	if ds.HTTPClient != nil && ds.RequestCompression != "" {
+		return errors.New("WithHTTPClient is incompatible with WithRequestCompression")
+	}
+	if ds.HTTPClient != nil && (len(ds.RetryHooks) > 0 || ds.LogRetries) {
+		return errors.New("WithHTTPClient is incompatible with WithRetryHook and WithRetryLogger")
+	}
+	if ds.InstalledAppConfig != nil && len(ds.Scopes) == 0 {
+		return errors.New("WithInstalledAppFlow requires scopes being provided")
+	}
 	if ds.ImpersonationConfig != nil && len(ds.ImpersonationConfig.Scopes) == 0 && len(ds.Scopes) == 0 {
 		return errors.New("WithImpersonatedCredentials requires scopes being provided")
 	}
@@ -11,6 +11,7 @@ import (
 	"testing"
 
 	"google.golang.org/api/internal/impersonate"
+	"google.golang.org/api/internal/installedapp"
 	"google.golang.org/grpc"
 
 	"golang.org/x/oauth2"
@@ -38,6 +39,9 @@ func TestSettingsValidate(t *testing.T) {
 		{ClientCertSource: dummyGetClientCertificate},
 		{ImpersonationConfig: &impersonate.Config{Scopes: []string{"x"}}},
 		{ImpersonationConfig: &impersonate.Config{}, Scopes: []string{"x"}},
+		{TokenRefreshFraction: 0.5},
+		{RequestCompression: "gzip"},
+		{InstalledAppConfig: &installedapp.Config{}, Scopes: []string{"x"}},
 	} {
 		err := ds.Validate()
 		if err != nil {
@@ -67,6 +71,14 @@ func TestSettingsValidate(t *testing.T) {
 		{ClientCertSource: dummyGetClientCertificate, GRPCDialOpts: []grpc.DialOption{grpc.WithInsecure()}},
 		{ClientCertSource: dummyGetClientCertificate, GRPCConnPoolSize: 1},
 		{ImpersonationConfig: &impersonate.Config{}},
+		{TokenRefreshFraction: -1},
+		{TokenRefreshFraction: 2},
+		{RequestCompression: "br"},
+		{HTTPClient: &http.Client{}, RequestCompression: "gzip"},
+		{HTTPClient: &http.Client{}, LogRetries: true},
+		{InstalledAppConfig: &installedapp.Config{}},
+		{InstalledAppConfig: &installedapp.Config{}, Scopes: []string{"x"}, CredentialsFile: "f"},
+		{InstalledAppConfig: &installedapp.Config{}, Scopes: []string{"x"}, NoAuth: true},
 	} {
 		err := ds.Validate()
 		if err == nil {
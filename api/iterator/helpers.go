@@ -0,0 +1,226 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package iterator
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+// Nexter is implemented by standard iterators, whose Next method returns the
+// next item or Done.
+type Nexter[T any] interface {
+	Next() (T, error)
+}
+
+// PagesFunc has the signature of the Pages method of generated list calls,
+// such as (*storage.ObjectsListCall).Pages. It calls f for each page of
+// results until there are no more pages, f returns an error, or ctx is done.
+type PagesFunc[P any] func(ctx context.Context, f func(P) error) error
+
+// Collect returns up to limit items from it. If limit is zero or negative,
+// all remaining items are returned. ctx is checked between items, so a
+// cancelled ctx stops the iteration even while items are buffered.
+func Collect[T any](ctx context.Context, it Nexter[T], limit int) ([]T, error) {
+	var items []T
+	for limit <= 0 || len(items) < limit {
+		if err := ctx.Err(); err != nil {
+			return items, err
+		}
+		item, err := it.Next()
+		if err == Done {
+			break
+		}
+		if err != nil {
+			return items, err
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// ForEach calls f for each item of it, with up to concurrency calls running at
+// once. Items are read from it sequentially; only the calls to f run
+// concurrently. If concurrency is less than one, one is used.
+//
+// The first error returned by it or f stops the iteration, cancels the context
+// passed to in-flight calls of f, and is returned once they have finished.
+func ForEach[T any](ctx context.Context, it Nexter[T], concurrency int, f func(context.Context, T) error) error {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg       sync.WaitGroup
+		errOnce  sync.Once
+		firstErr error
+	)
+	setErr := func(err error) {
+		errOnce.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+	sem := make(chan struct{}, concurrency)
+	for {
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			setErr(ctx.Err())
+		}
+		if ctx.Err() != nil {
+			break
+		}
+		item, err := it.Next()
+		if err == Done {
+			break
+		}
+		if err != nil {
+			setErr(err)
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			if err := f(ctx, item); err != nil {
+				setErr(err)
+			}
+		}()
+	}
+	wg.Wait()
+	return firstErr
+}
+
+// Prefetch returns an iterator over the items of it that reads up to n items
+// ahead in a separate goroutine. Since standard iterators fetch a page when
+// their buffer runs out, this overlaps fetching the next page with the
+// processing of the current one. n should usually be the page size. If n is
+// less than one, one is used.
+//
+// The returned iterator must only be used from one goroutine at a time, and it
+// must not be used concurrently with it. Cancel ctx to stop the read-ahead
+// goroutine when abandoning the iteration early.
+func Prefetch[T any](ctx context.Context, it Nexter[T], n int) Nexter[T] {
+	if n < 1 {
+		n = 1
+	}
+	p := &prefetcher[T]{items: make(chan prefetched[T], n)}
+	go p.run(ctx, it)
+	return p
+}
+
+type prefetched[T any] struct {
+	item T
+	err  error
+}
+
+type prefetcher[T any] struct {
+	items chan prefetched[T]
+	err   error // latched once Next returns an error
+}
+
+func (p *prefetcher[T]) run(ctx context.Context, it Nexter[T]) {
+	defer close(p.items)
+	for {
+		item, err := it.Next()
+		select {
+		case p.items <- prefetched[T]{item, err}:
+		case <-ctx.Done():
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Next returns the next item or Done.
+func (p *prefetcher[T]) Next() (T, error) {
+	var zero T
+	if p.err != nil {
+		return zero, p.err
+	}
+	r, ok := <-p.items
+	if !ok {
+		// The read-ahead goroutine stopped because its context was done.
+		p.err = errors.New("iterator: prefetch context done")
+		return zero, p.err
+	}
+	if r.err != nil {
+		p.err = r.err
+		return zero, p.err
+	}
+	return r.item, nil
+}
+
+// PrefetchPages is like pages(ctx, f), except that the next page is fetched
+// while f processes the current one. f is always called from a single
+// goroutine, in page order.
+func PrefetchPages[P any](ctx context.Context, pages PagesFunc[P], f func(P) error) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// The buffer of one lets pages fetch the next page as soon as it has
+	// handed over the current one.
+	ch := make(chan P, 1)
+	done := make(chan error, 1)
+	go func() {
+		defer close(ch)
+		done <- pages(ctx, func(page P) error {
+			select {
+			case ch <- page:
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		})
+	}()
+	for page := range ch {
+		if err := f(page); err != nil {
+			cancel()
+			for range ch {
+				// Drain so the producer can exit.
+			}
+			<-done
+			return err
+		}
+	}
+	return <-done
+}
+
+// Scan retrieves the items of it a page at a time, using a Pager with the
+// given pageSize, and calls f with each page. The scan starts at resumeToken,
+// which is empty to start at the beginning.
+//
+// Along with each page, f receives the token at which to resume the scan
+// after that page. A caller that records the token once f has processed a
+// page can resume an interrupted scan by passing the last recorded token to a
+// later call to Scan with a new iterator. The token is empty for the last
+// page.
+func Scan[T any](it Pageable, pageSize int, resumeToken string, f func(page []T, resumeToken string) error) error {
+	p := NewPager(it, pageSize, resumeToken)
+	for {
+		var page []T
+		token, err := p.NextPage(&page)
+		if err != nil {
+			return err
+		}
+		if err := f(page, token); err != nil {
+			return err
+		}
+		if token == "" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,226 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package iterator_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/api/iterator"
+	itest "google.golang.org/api/iterator/testing"
+)
+
+var testServices = []service{
+	{end: 0},
+	{end: 5},
+	{end: 5, max: 1},
+	{end: 5, max: 2},
+	{end: 5, zeroes: true},
+	{end: 5, max: 2, zeroes: true},
+}
+
+func TestPrefetch(t *testing.T) {
+	for _, svc := range testServices {
+		svc := svc
+		client := &Client{&svc}
+		ctx, cancel := context.WithCancel(context.Background())
+		msg, ok := itest.TestIterator(
+			seq(0, svc.end),
+			func() interface{} { return iterator.Prefetch[int](ctx, client.Items(ctx), 2) },
+			func(it interface{}) (interface{}, error) { return it.(iterator.Nexter[int]).Next() })
+		cancel()
+		if !ok {
+			t.Errorf("%+v: %s", svc, msg)
+		}
+	}
+}
+
+func TestPrefetch_Cancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	client := &Client{&service{end: 100, max: 1}}
+	it := iterator.Prefetch[int](ctx, client.Items(ctx), 1)
+	if _, err := it.Next(); err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	var err error
+	for err == nil {
+		_, err = it.Next()
+	}
+	if err == iterator.Done {
+		t.Error("got Done after cancel, want error")
+	}
+}
+
+func TestCollect(t *testing.T) {
+	ctx := context.Background()
+	for _, svc := range testServices {
+		svc := svc
+		client := &Client{&svc}
+		got, err := iterator.Collect[int](ctx, client.Items(ctx), 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := seq(0, svc.end); !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: got %v, want %v", svc, got, want)
+		}
+
+		got, err = iterator.Collect[int](ctx, client.Items(ctx), 3)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := seq(0, minInt(3, svc.end)); !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v, limit 3: got %v, want %v", svc, got, want)
+		}
+	}
+
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, err := iterator.Collect[int](cctx, (&Client{&service{end: 5}}).Items(cctx), 0); err != context.Canceled {
+		t.Errorf("cancelled context: got %v, want context.Canceled", err)
+	}
+}
+
+func TestForEach(t *testing.T) {
+	ctx := context.Background()
+	client := &Client{&service{end: 50, max: 7}}
+
+	var (
+		mu      sync.Mutex
+		seen    = map[int]bool{}
+		running int32
+		maxRun  int32
+	)
+	err := iterator.ForEach[int](ctx, client.Items(ctx), 4, func(ctx context.Context, i int) error {
+		n := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		mu.Lock()
+		defer mu.Unlock()
+		if n > maxRun {
+			maxRun = n
+		}
+		seen[i] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(seen) != 50 {
+		t.Errorf("saw %d items, want 50", len(seen))
+	}
+	if maxRun > 4 {
+		t.Errorf("got %d concurrent calls, want at most 4", maxRun)
+	}
+
+	wantErr := errors.New("stop")
+	var calls int32
+	err = iterator.ForEach[int](ctx, client.Items(ctx), 2, func(ctx context.Context, i int) error {
+		atomic.AddInt32(&calls, 1)
+		if i == 3 {
+			return wantErr
+		}
+		return nil
+	})
+	if err != wantErr {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+	if calls >= 50 {
+		t.Errorf("got %d calls, want the iteration to stop early", calls)
+	}
+}
+
+// pagesOf returns a PagesFunc over the pages of svc.
+func pagesOf(svc *service) iterator.PagesFunc[[]int] {
+	return func(ctx context.Context, f func([]int) error) error {
+		token := ""
+		for {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			items, next, err := svc.List(0, token)
+			if err != nil {
+				return err
+			}
+			if err := f(items); err != nil {
+				return err
+			}
+			if next == "" {
+				return nil
+			}
+			token = next
+		}
+	}
+}
+
+func TestPrefetchPages(t *testing.T) {
+	ctx := context.Background()
+	svc := &service{end: 10, max: 3}
+	var got []int
+	err := iterator.PrefetchPages(ctx, pagesOf(svc), func(page []int) error {
+		got = append(got, page...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := seq(0, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+
+	wantErr := errors.New("stop")
+	err = iterator.PrefetchPages(ctx, pagesOf(svc), func(page []int) error { return wantErr })
+	if err != wantErr {
+		t.Errorf("got %v, want %v", err, wantErr)
+	}
+}
+
+func TestScan_Resume(t *testing.T) {
+	ctx := context.Background()
+	client := &Client{&service{end: 10, max: 4}}
+
+	// Interrupt the scan after the second page, recording the checkpoint.
+	var got []int
+	var checkpoint string
+	errStop := errors.New("interrupted")
+	pages := 0
+	err := iterator.Scan(client.Items(ctx), 3, "", func(page []int, token string) error {
+		got = append(got, page...)
+		checkpoint = token
+		pages++
+		if pages == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop {
+		t.Fatalf("got %v, want %v", err, errStop)
+	}
+
+	// Resume with a new iterator.
+	err = iterator.Scan(client.Items(ctx), 3, checkpoint, func(page []int, token string) error {
+		got = append(got, page...)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := seq(0, 10); !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -50,3 +50,34 @@ type skipDialSettingsValidation struct{}
 func (s skipDialSettingsValidation) Apply(settings *internal.DialSettings) {
 	settings.SkipValidation = true
 }
+
+// WithDefaultAudience is an option that indicates the audience of
+// self-signed JWTs, if it is not the one derived from the default endpoint.
+// Generated clients set it to the root URL of the service.
+//
+// It should only be used internally by generated clients.
+func WithDefaultAudience(audience string) option.ClientOption {
+	return withDefaultAudience(audience)
+}
+
+type withDefaultAudience string
+
+func (w withDefaultAudience) Apply(o *internal.DialSettings) {
+	o.DefaultAudience = string(w)
+}
+
+// EnableJWTWithScope makes service account credentials use self-signed JWTs
+// with the scopes of the client, in place of OAuth 2.0 access tokens, for
+// services that accept them. The generator only sets it for the APIs named
+// by its -jwt_with_scope flag.
+//
+// It should only be used internally by generated clients.
+func EnableJWTWithScope() option.ClientOption {
+	return enableJWTWithScope{}
+}
+
+type enableJWTWithScope struct{}
+
+func (enableJWTWithScope) Apply(o *internal.DialSettings) {
+	o.EnableJWTWithScope = true
+}
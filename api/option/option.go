@@ -6,12 +6,16 @@
 package option
 
 import (
+	"context"
 	"crypto/tls"
+	"log"
 	"net/http"
+	"time"
 
 	"golang.org/x/oauth2"
 	"google.golang.org/api/internal"
 	"google.golang.org/api/internal/impersonate"
+	"google.golang.org/api/internal/installedapp"
 	"google.golang.org/grpc"
 )
 
@@ -170,7 +174,10 @@ type withAPIKey string
 func (w withAPIKey) Apply(o *internal.DialSettings) { o.APIKey = string(w) }
 
 // WithAudiences returns a ClientOption that specifies an audience to be used
-// as the audience field ("aud") for the JWT token authentication.
+// as the audience field ("aud") for the JWT token authentication. If more
+// than one audience is given, the JWT is valid for all of them. Without
+// WithAudiences, the audience is derived from the default endpoint of the
+// service.
 func WithAudiences(audience ...string) ClientOption {
 	return withAudiences(audience)
 }
@@ -239,6 +246,85 @@ func (w withTelemetryDisabled) Apply(o *internal.DialSettings) {
 	o.TelemetryDisabled = true
 }
 
+// WithTokenRefreshFraction returns a ClientOption that specifies the fraction
+// of an OAuth2 token's lifetime after which a new token is fetched in the
+// background. Requests keep using the current token while the new one is being
+// fetched, so they are not delayed by the token endpoint when the token
+// expires. The fraction must be in (0, 1]; for example, 0.75 refreshes a one
+// hour token after 45 minutes. By default tokens are only refreshed shortly
+// before they expire, by the request that finds them expiring.
+//
+// This is an EXPERIMENTAL API and may be changed or removed in the future.
+func WithTokenRefreshFraction(fraction float64) ClientOption {
+	return withTokenRefreshFraction(fraction)
+}
+
+type withTokenRefreshFraction float64
+
+func (w withTokenRefreshFraction) Apply(o *internal.DialSettings) {
+	o.TokenRefreshFraction = float64(w)
+}
+
+// WithRequestCompression returns a ClientOption that compresses the bodies of
+// HTTP requests with the given encoding, which must be "gzip". Only bodies
+// of at least 1 KiB whose content type is JSON, XML or text are compressed,
+// so that media that is already compressed, such as images, is sent as is.
+// Resumable upload chunks are never compressed. Compression has no effect
+// on gRPC clients.
+//
+// This is an EXPERIMENTAL API and may be changed or removed in the future.
+func WithRequestCompression(encoding string) ClientOption {
+	return withRequestCompression(encoding)
+}
+
+type withRequestCompression string
+
+func (w withRequestCompression) Apply(o *internal.DialSettings) {
+	o.RequestCompression = string(w)
+}
+
+// RetryHook is a function that is called before an HTTP request is retried.
+// resp and err are the result of the attempt that failed, and delay is how
+// long the client waits before the retry. The body of resp is closed after
+// the hook returns.
+//
+// This is an EXPERIMENTAL API and may be changed or removed in the future.
+type RetryHook = func(ctx context.Context, resp *http.Response, err error, delay time.Duration)
+
+// WithRetryHook returns a ClientOption that calls h before each retry of an
+// HTTP request by the client, for example to record retry delays as metrics.
+// Hooks are called in the order they are given. Retry hooks are not supported
+// with WithHTTPClient, and have no effect on gRPC clients.
+//
+// This is an EXPERIMENTAL API and may be changed or removed in the future.
+func WithRetryHook(h RetryHook) ClientOption {
+	return withRetryHook(h)
+}
+
+type withRetryHook RetryHook
+
+func (w withRetryHook) Apply(o *internal.DialSettings) {
+	o.RetryHooks = append(o.RetryHooks, w)
+}
+
+// WithRetryLogger returns a ClientOption that logs each retry of an HTTP
+// request by the client to l, with the status code or error of the failed
+// attempt and the delay before the retry. A nil l logs to the standard logger.
+// Retry logging is not supported with WithHTTPClient, and has no effect on
+// gRPC clients.
+//
+// This is an EXPERIMENTAL API and may be changed or removed in the future.
+func WithRetryLogger(l *log.Logger) ClientOption {
+	return withRetryLogger{l}
+}
+
+type withRetryLogger struct{ l *log.Logger }
+
+func (w withRetryLogger) Apply(o *internal.DialSettings) {
+	o.LogRetries = true
+	o.RetryLogger = w.l
+}
+
 // ClientCertSource is a function that returns a TLS client certificate to be used
 // when opening TLS connections.
 //
@@ -324,3 +410,45 @@ func (i impersonateServiceAccount) Apply(o *internal.DialSettings) {
 	o.ImpersonationConfig.Delegates = make([]string, len(i.delegates))
 	copy(o.ImpersonationConfig.Delegates, i.delegates)
 }
+
+// WithInstalledAppFlow returns a ClientOption that authenticates as a user of
+// an installed application, like a command-line tool, whose client secret
+// JSON file, as downloaded from the Google Cloud Console, is
+// clientSecretJSON.
+//
+// The first time, the user is asked to authorize the application in a
+// browser, which is redirected to a server on the loopback interface. The
+// refresh token obtained is cached in a file in cacheDir, that only the user
+// can read, for the client and scopes. If cacheDir is "", a directory in the
+// user's cache directory is used. Later clients use the cached token, and
+// refresh it as needed.
+//
+// This is an EXPERIMENTAL API and may be changed or removed in the future.
+func WithInstalledAppFlow(clientSecretJSON []byte, cacheDir string) ClientOption {
+	return withInstalledAppFlow{json: clientSecretJSON, cacheDir: cacheDir}
+}
+
+// WithDeviceCodeFlow is like WithInstalledAppFlow, but the user authorizes
+// the application by visiting a URL on any device and entering a code there.
+// It suits machines without a browser, like remote servers. The client
+// secret must be that of a "TVs and Limited Input devices" client.
+//
+// This is an EXPERIMENTAL API and may be changed or removed in the future.
+func WithDeviceCodeFlow(clientSecretJSON []byte, cacheDir string) ClientOption {
+	return withInstalledAppFlow{json: clientSecretJSON, cacheDir: cacheDir, deviceCode: true}
+}
+
+type withInstalledAppFlow struct {
+	json       []byte
+	cacheDir   string
+	deviceCode bool
+}
+
+func (w withInstalledAppFlow) Apply(o *internal.DialSettings) {
+	o.InstalledAppConfig = &installedapp.Config{
+		ClientSecretJSON: make([]byte, len(w.json)),
+		CacheDir:         w.cacheDir,
+		DeviceCode:       w.deviceCode,
+	}
+	copy(o.InstalledAppConfig.ClientSecretJSON, w.json)
+}
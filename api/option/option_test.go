@@ -14,6 +14,7 @@ import (
 	"github.com/google/go-cmp/cmp/cmpopts"
 	"golang.org/x/oauth2/google"
 	"google.golang.org/api/internal"
+	"google.golang.org/api/internal/installedapp"
 	"google.golang.org/grpc"
 )
 
@@ -73,27 +74,38 @@ func TestApply(t *testing.T) {
 		WithQuotaProject("user-project"),
 		WithRequestReason("Request Reason"),
 		WithTelemetryDisabled(),
+		WithTokenRefreshFraction(0.5),
+		WithRequestCompression("gzip"),
+		WithDeviceCodeFlow([]byte(`{"installed": {}}`), "dir"),
 	}
 	var got internal.DialSettings
 	for _, opt := range opts {
 		opt.Apply(&got)
 	}
 	want := internal.DialSettings{
-		Scopes:            []string{"https://example.com/auth/helloworld", "https://example.com/auth/otherthing"},
-		UserAgent:         "ua",
-		Endpoint:          "https://example.com:443",
-		GRPCConn:          conn,
-		Credentials:       &google.DefaultCredentials{ProjectID: "p"},
-		CredentialsFile:   "service-account.json",
-		CredentialsJSON:   []byte(`{some: "json"}`),
-		APIKey:            "api-key",
-		Audiences:         []string{"https://example.com/"},
-		QuotaProject:      "user-project",
-		RequestReason:     "Request Reason",
-		TelemetryDisabled: true,
+		Scopes:               []string{"https://example.com/auth/helloworld", "https://example.com/auth/otherthing"},
+		UserAgent:            "ua",
+		Endpoint:             "https://example.com:443",
+		GRPCConn:             conn,
+		Credentials:          &google.DefaultCredentials{ProjectID: "p"},
+		CredentialsFile:      "service-account.json",
+		CredentialsJSON:      []byte(`{some: "json"}`),
+		APIKey:               "api-key",
+		Audiences:            []string{"https://example.com/"},
+		QuotaProject:         "user-project",
+		RequestReason:        "Request Reason",
+		TelemetryDisabled:    true,
+		TokenRefreshFraction: 0.5,
+		RequestCompression:   "gzip",
+		InstalledAppConfig: &installedapp.Config{
+			ClientSecretJSON: []byte(`{"installed": {}}`),
+			CacheDir:         "dir",
+			DeviceCode:       true,
+		},
 	}
-	if !cmp.Equal(got, want, cmpopts.IgnoreUnexported(grpc.ClientConn{})) {
-		t.Errorf(cmp.Diff(got, want, cmpopts.IgnoreUnexported(grpc.ClientConn{})))
+	ignore := cmpopts.IgnoreUnexported(grpc.ClientConn{}, installedapp.Config{})
+	if !cmp.Equal(got, want, ignore) {
+		t.Errorf(cmp.Diff(got, want, ignore))
 	}
 }
 
@@ -18,6 +18,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/googleapis/gax-go/v2"
 	"golang.org/x/sync/semaphore"
 )
 
@@ -43,6 +44,9 @@ var (
 	// ErrOversizedItem indicates that an item's size exceeds the maximum bundle size.
 	ErrOversizedItem = errors.New("item size exceeds bundle byte limit")
 
+	// ErrClosed indicates that an item was added after Close was called.
+	ErrClosed = errors.New("bundler is closed")
+
 	// errMixedMethods indicates that mutually exclusive methods has been
 	// called subsequently.
 	errMixedMethods = errors.New("calls to Add and AddWait cannot be mixed")
@@ -81,8 +85,26 @@ type Bundler struct {
 	// The default is 1.
 	HandlerLimit int
 
-	handler       func(interface{}) // called to handle a bundle
-	itemSliceZero reflect.Value     // nil (zero value) for slice of items
+	// The maximum number of times an error-returning handler (see
+	// NewBundlerWithRetry) is called for a bundle. The default is 1, which
+	// means that failed bundles are not retried.
+	HandlerMaxAttempts int
+
+	// The delays between attempts to handle a bundle. The zero value uses the
+	// gax.Backoff defaults.
+	HandlerBackoff gax.Backoff
+
+	// If non-nil, OnHandlerError is called with the bundle and the error
+	// returned by the last attempt of an error-returning handler.
+	OnHandlerError func(bundle interface{}, err error)
+
+	handler       func(interface{})       // called to handle a bundle
+	errHandler    func(interface{}) error // called to handle a bundle if handler is nil
+	itemSliceZero reflect.Value           // nil (zero value) for slice of items
+
+	// stopRetries is closed when Close gives up waiting for handlers.
+	stopRetries     chan struct{}
+	stopRetriesOnce sync.Once
 
 	mu           sync.Mutex          // guards access to fields below
 	flushTimer   *time.Timer         // implements DelayThreshold
@@ -109,6 +131,9 @@ type Bundler struct {
 	// The first call to Add or AddWait, mode will be add or addWait respectively.
 	// If there wasn't call yet then mode is none.
 	mode mode
+	// closed is set by Close. No items can be added once it is set.
+	closed bool
+	stats  Stats
 	// TODO: consider alternative queue implementation for head/tail bundle. see:
 	// https://code-review.googlesource.com/c/google-api-go-client/+/47991/4/support/bundler/bundler.go#74
 }
@@ -141,18 +166,39 @@ func (bu *bundle) add(item interface{}, size int) {
 // Configure the Bundler by setting its thresholds and limits before calling
 // any of its methods.
 func NewBundler(itemExample interface{}, handler func(interface{})) *Bundler {
-	b := &Bundler{
+	b := newBundler(itemExample)
+	b.handler = handler
+	return b
+}
+
+// NewBundlerWithRetry creates a new Bundler whose handler reports whether it
+// succeeded. It is otherwise like NewBundler.
+//
+// If handler returns an error, it is called again with the same bundle after a
+// delay given by HandlerBackoff, up to HandlerMaxAttempts times in total.
+// Bundles are still handled in order: with the default HandlerLimit of 1, the
+// next bundle is not handled until the current one has succeeded or run out
+// of attempts. If the last attempt fails, the error is passed to
+// OnHandlerError.
+func NewBundlerWithRetry(itemExample interface{}, handler func(interface{}) error) *Bundler {
+	b := newBundler(itemExample)
+	b.errHandler = handler
+	return b
+}
+
+func newBundler(itemExample interface{}) *Bundler {
+	return &Bundler{
 		DelayThreshold:       DefaultDelayThreshold,
 		BundleCountThreshold: DefaultBundleCountThreshold,
 		BundleByteThreshold:  DefaultBundleByteThreshold,
 		BufferedByteLimit:    DefaultBufferedByteLimit,
 		HandlerLimit:         1,
+		HandlerMaxAttempts:   1,
 
-		handler:       handler,
 		itemSliceZero: reflect.Zero(reflect.SliceOf(reflect.TypeOf(itemExample))),
 		curFlush:      &sync.WaitGroup{},
+		stopRetries:   make(chan struct{}),
 	}
-	return b
 }
 
 func (b *Bundler) initSemaphores() {
@@ -241,6 +287,9 @@ func (b *Bundler) Add(item interface{}, size int) error {
 	// so calls to Add and AddWait shouldn't be mixed.)
 	b.initSemaphores()
 	if !b.sem.TryAcquire(int64(size)) {
+		b.mu.Lock()
+		b.stats.Overflows++
+		b.mu.Unlock()
 		return ErrOverflow
 	}
 
@@ -254,6 +303,13 @@ func (b *Bundler) Add(item interface{}, size int) error {
 // appending it to the queue) if any of the thresholds or limits are exceeded.
 // curBundle is lazily initialized. It requires that b.mu is locked.
 func (b *Bundler) add(item interface{}, size int) error {
+	if b.closed {
+		b.sem.Release(int64(size))
+		return ErrClosed
+	}
+	b.stats.ItemsAdded++
+	b.stats.BytesAdded += int64(size)
+
 	// If we don't have a curBundle, see if we can add to the queue tail.
 	if b.tail != nil && b.curBundle == nil && b.canFit(b.tail, size) {
 		b.tail.add(item, size)
@@ -321,17 +377,57 @@ func (b *Bundler) next() *bundle {
 // goroutine ends.
 func (b *Bundler) handle(bu *bundle) {
 	for bu != nil {
-		b.handler(bu.items.Interface())
-		bu = b.postHandle(bu)
+		err := b.callHandler(bu.items.Interface())
+		bu = b.postHandle(bu, err)
+	}
+}
+
+// callHandler calls the user-specified handler on items, retrying an
+// error-returning handler as configured. It returns the error from the last
+// attempt.
+func (b *Bundler) callHandler(items interface{}) error {
+	if b.handler != nil {
+		b.handler(items)
+		return nil
+	}
+	bo := b.HandlerBackoff
+	for attempt := 1; ; attempt++ {
+		err := b.errHandler(items)
+		if err == nil {
+			return nil
+		}
+		if attempt >= b.HandlerMaxAttempts {
+			return err
+		}
+		t := time.NewTimer(bo.Pause())
+		select {
+		case <-t.C:
+		case <-b.stopRetries:
+			t.Stop()
+			return err
+		}
+		b.mu.Lock()
+		b.stats.HandlerRetries++
+		b.mu.Unlock()
 	}
 }
 
-func (b *Bundler) postHandle(bu *bundle) *bundle {
+func (b *Bundler) postHandle(bu *bundle, err error) *bundle {
+	if err != nil && b.OnHandlerError != nil {
+		b.OnHandlerError(bu.items.Interface(), err)
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
 
 	b.sem.Release(int64(bu.size))
 	bu.flush.Done()
+	b.stats.BundlesHandled++
+	b.stats.ItemsHandled += int64(bu.items.Len())
+	b.stats.BytesHandled += int64(bu.size)
+	if err != nil {
+		b.stats.HandlerErrors++
+	}
 
 	bu = b.next()
 	if bu == nil {
@@ -363,8 +459,13 @@ func (b *Bundler) AddWait(ctx context.Context, item interface{}, size int) error
 	// until space is available. The semaphore is FIFO, so there will be no
 	// starvation.
 	b.initSemaphores()
-	if err := b.sem.Acquire(ctx, int64(size)); err != nil {
-		return err
+	if !b.sem.TryAcquire(int64(size)) {
+		b.mu.Lock()
+		b.stats.Overflows++
+		b.mu.Unlock()
+		if err := b.sem.Acquire(ctx, int64(size)); err != nil {
+			return err
+		}
 	}
 
 	b.mu.Lock()
@@ -405,3 +506,73 @@ func (b *Bundler) Flush() {
 	// Allow the next flush to finish.
 	close(next)
 }
+
+// Close stops the Bundler from accepting new items, invokes the handler for all
+// remaining items and waits for it to return. Items added after Close is
+// called are rejected with ErrClosed.
+//
+// If ctx is done before all items are handled, handlers that are being retried
+// are not retried again and Close returns ctx.Err() without waiting for the
+// handlers to return.
+func (b *Bundler) Close(ctx context.Context) error {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		b.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		b.stopRetriesOnce.Do(func() { close(b.stopRetries) })
+		return ctx.Err()
+	}
+}
+
+// Stats holds counters describing the activity of a Bundler. All counters
+// start at zero when the Bundler is created.
+type Stats struct {
+	// ItemsAdded and BytesAdded count the items accepted by Add and AddWait,
+	// and their sizes.
+	ItemsAdded int64
+	BytesAdded int64
+
+	// BundlesHandled, ItemsHandled and BytesHandled count the bundles that
+	// have been handled, whether successfully or not, and the items and bytes
+	// in them.
+	BundlesHandled int64
+	ItemsHandled   int64
+	BytesHandled   int64
+
+	// Overflows counts the calls to Add that returned ErrOverflow and the
+	// calls to AddWait that had to wait for buffer space.
+	Overflows int64
+
+	// HandlerRetries counts the times a bundle was handled again after its
+	// handler returned an error. HandlerErrors counts the bundles whose last
+	// attempt failed.
+	HandlerRetries int64
+	HandlerErrors  int64
+}
+
+func (s *Stats) add(o Stats) {
+	s.ItemsAdded += o.ItemsAdded
+	s.BytesAdded += o.BytesAdded
+	s.BundlesHandled += o.BundlesHandled
+	s.ItemsHandled += o.ItemsHandled
+	s.BytesHandled += o.BytesHandled
+	s.Overflows += o.Overflows
+	s.HandlerRetries += o.HandlerRetries
+	s.HandlerErrors += o.HandlerErrors
+}
+
+// Stats returns a snapshot of the Bundler's counters.
+func (b *Bundler) Stats() Stats {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.stats
+}
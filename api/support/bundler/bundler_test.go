@@ -6,6 +6,7 @@ package bundler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"math"
 	"reflect"
@@ -14,6 +15,8 @@ import (
 	"sync"
 	"testing"
 	"time"
+
+	"github.com/googleapis/gax-go/v2"
 )
 
 func TestBundlerCount1(t *testing.T) {
@@ -413,6 +416,132 @@ func TestBundlerTimeBasedFlushDeadlock(t *testing.T) {
 	wg.Wait()
 }
 
+func TestBundlerRetry(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts = map[int]int{}
+		handled  [][]int
+		failed   [][]int
+	)
+	b := NewBundlerWithRetry(int(0), func(bundle interface{}) error {
+		items := bundle.([]int)
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[items[0]]++
+		// Bundle {0} succeeds on the second attempt, {1} never succeeds.
+		if items[0] == 1 || (items[0] == 0 && attempts[0] < 2) {
+			return errors.New("transient")
+		}
+		handled = append(handled, items)
+		return nil
+	})
+	b.BundleCountThreshold = 1
+	b.HandlerMaxAttempts = 3
+	b.HandlerBackoff = gax.Backoff{Initial: time.Millisecond, Max: time.Millisecond}
+	b.OnHandlerError = func(bundle interface{}, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		failed = append(failed, bundle.([]int))
+	}
+	for i := 0; i < 3; i++ {
+		if err := b.Add(i, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Flush()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if want := [][]int{{0}, {2}}; !reflect.DeepEqual(handled, want) {
+		t.Errorf("handled: got %v, want %v", handled, want)
+	}
+	if want := [][]int{{1}}; !reflect.DeepEqual(failed, want) {
+		t.Errorf("failed: got %v, want %v", failed, want)
+	}
+	if want := map[int]int{0: 2, 1: 3, 2: 1}; !reflect.DeepEqual(attempts, want) {
+		t.Errorf("attempts: got %v, want %v", attempts, want)
+	}
+	got := b.Stats()
+	want := Stats{
+		ItemsAdded:     3,
+		BytesAdded:     3,
+		BundlesHandled: 3,
+		ItemsHandled:   3,
+		BytesHandled:   3,
+		HandlerRetries: 3,
+		HandlerErrors:  1,
+	}
+	if got != want {
+		t.Errorf("stats: got %+v, want %+v", got, want)
+	}
+}
+
+func TestBundlerClose(t *testing.T) {
+	handler := &testHandler{}
+	b := NewBundler(int(0), handler.handleImmediate)
+	b.DelayThreshold = time.Hour
+	for i := 0; i < 3; i++ {
+		if err := b.Add(i, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := b.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := handler.bundles(), [][]int{{0, 1, 2}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("bundles: got %v, want %v", got, want)
+	}
+	if err := b.Add(3, 1); err != ErrClosed {
+		t.Errorf("Add after Close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestBundlerCloseDeadline(t *testing.T) {
+	b := NewBundlerWithRetry(int(0), func(interface{}) error { return errors.New("always") })
+	b.HandlerMaxAttempts = 1000
+	b.HandlerBackoff = gax.Backoff{Initial: time.Hour, Max: time.Hour}
+	if err := b.Add(1, 1); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+	// The retry loop is abandoned, so the bundle is released promptly.
+	done := make(chan struct{})
+	go func() {
+		b.Flush()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Flush did not return after Close deadline")
+	}
+	if got := b.Stats().HandlerErrors; got != 1 {
+		t.Errorf("got %d handler errors, want 1", got)
+	}
+}
+
+func TestBundlerOverflowStats(t *testing.T) {
+	b := NewBundler(int(0), func(interface{}) {})
+	b.BufferedByteLimit = 10
+	b.DelayThreshold = time.Hour
+	b.BundleCountThreshold = 100
+	b.BundleByteThreshold = 100
+	if err := b.Add(1, 10); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Add(2, 1); err != ErrOverflow {
+		t.Fatalf("got %v, want ErrOverflow", err)
+	}
+	if got := b.Stats().Overflows; got != 1 {
+		t.Errorf("got %d overflows, want 1", got)
+	}
+	b.Flush()
+}
+
 type testHandler struct {
 	mu sync.Mutex
 	b  [][]int
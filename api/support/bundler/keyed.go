@@ -0,0 +1,188 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bundler
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/sync/semaphore"
+)
+
+// A KeyedBundler groups the items added to it by key, and bundles the items of
+// each key separately. For example, log entries could be keyed by log name or
+// messages by ordering key.
+//
+// The bundles of a key are handled one at a time, in the order their items
+// were added; bundles of different keys are handled concurrently. All keys
+// share a single BufferedByteLimit.
+//
+// The exported fields apply to the bundles of every key, and are only safe to
+// modify prior to the first call to Add or AddWait. They have the same meaning
+// and defaults as the fields of Bundler.
+type KeyedBundler struct {
+	DelayThreshold       time.Duration
+	BundleCountThreshold int
+	BundleByteThreshold  int
+	BundleByteLimit      int
+	BufferedByteLimit    int
+	HandlerMaxAttempts   int
+	HandlerBackoff       gax.Backoff
+
+	// If non-nil, OnHandlerError is called with the key, the bundle and the
+	// error returned by the last attempt to handle the bundle.
+	OnHandlerError func(key string, bundle interface{}, err error)
+
+	itemExample interface{}
+	handler     func(key string, bundle interface{}) error
+
+	semOnce sync.Once
+	sem     *semaphore.Weighted // enforces BufferedByteLimit across all keys
+
+	mu       sync.Mutex // guards access to fields below
+	bundlers map[string]*Bundler
+	closed   bool
+}
+
+// NewKeyedBundler creates a new KeyedBundler.
+//
+// itemExample is a value of the type that will be bundled, as for NewBundler.
+//
+// handler is called with the key and the items of each bundle. If it returns an
+// error, it is retried as described for NewBundlerWithRetry.
+//
+// A KeyedBundler keeps some state for every key it has seen, so the number of
+// distinct keys should be bounded.
+func NewKeyedBundler(itemExample interface{}, handler func(key string, bundle interface{}) error) *KeyedBundler {
+	return &KeyedBundler{
+		DelayThreshold:       DefaultDelayThreshold,
+		BundleCountThreshold: DefaultBundleCountThreshold,
+		BundleByteThreshold:  DefaultBundleByteThreshold,
+		BufferedByteLimit:    DefaultBufferedByteLimit,
+		HandlerMaxAttempts:   1,
+
+		itemExample: itemExample,
+		handler:     handler,
+		bundlers:    make(map[string]*Bundler),
+	}
+}
+
+// bundler returns the Bundler for key, creating it if needed.
+func (kb *KeyedBundler) bundler(key string) (*Bundler, error) {
+	kb.semOnce.Do(func() {
+		kb.sem = semaphore.NewWeighted(int64(kb.BufferedByteLimit))
+	})
+
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	if kb.closed {
+		return nil, ErrClosed
+	}
+	if b, ok := kb.bundlers[key]; ok {
+		return b, nil
+	}
+	b := NewBundlerWithRetry(kb.itemExample, func(bundle interface{}) error {
+		return kb.handler(key, bundle)
+	})
+	b.DelayThreshold = kb.DelayThreshold
+	b.BundleCountThreshold = kb.BundleCountThreshold
+	b.BundleByteThreshold = kb.BundleByteThreshold
+	b.BundleByteLimit = kb.BundleByteLimit
+	b.BufferedByteLimit = kb.BufferedByteLimit
+	b.HandlerMaxAttempts = kb.HandlerMaxAttempts
+	b.HandlerBackoff = kb.HandlerBackoff
+	// Keep the bundles of a key in order.
+	b.HandlerLimit = 1
+	if kb.OnHandlerError != nil {
+		b.OnHandlerError = func(bundle interface{}, err error) {
+			kb.OnHandlerError(key, bundle, err)
+		}
+	}
+	// Share the semaphore so that BufferedByteLimit applies to all keys.
+	b.semOnce.Do(func() { b.sem = kb.sem })
+	kb.bundlers[key] = b
+	return b, nil
+}
+
+// Add adds item to the current bundle of key. It behaves like Bundler.Add.
+func (kb *KeyedBundler) Add(key string, item interface{}, size int) error {
+	b, err := kb.bundler(key)
+	if err != nil {
+		return err
+	}
+	return b.Add(item, size)
+}
+
+// AddWait adds item to the current bundle of key. It behaves like
+// Bundler.AddWait.
+//
+// Calls to Add and AddWait should not be mixed on the same KeyedBundler.
+func (kb *KeyedBundler) AddWait(ctx context.Context, key string, item interface{}, size int) error {
+	b, err := kb.bundler(key)
+	if err != nil {
+		return err
+	}
+	return b.AddWait(ctx, item, size)
+}
+
+// all returns the Bundlers of all keys.
+func (kb *KeyedBundler) all() []*Bundler {
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	bs := make([]*Bundler, 0, len(kb.bundlers))
+	for _, b := range kb.bundlers {
+		bs = append(bs, b)
+	}
+	return bs
+}
+
+// Flush invokes the handler for all remaining items of every key and waits for
+// it to return.
+func (kb *KeyedBundler) Flush() {
+	var wg sync.WaitGroup
+	for _, b := range kb.all() {
+		wg.Add(1)
+		go func(b *Bundler) {
+			defer wg.Done()
+			b.Flush()
+		}(b)
+	}
+	wg.Wait()
+}
+
+// Close stops the KeyedBundler from accepting new items, invokes the handler
+// for all remaining items of every key and waits for it to return. It
+// behaves like Bundler.Close.
+func (kb *KeyedBundler) Close(ctx context.Context) error {
+	kb.mu.Lock()
+	kb.closed = true
+	kb.mu.Unlock()
+
+	bs := kb.all()
+	errs := make(chan error, len(bs))
+	for _, b := range bs {
+		go func(b *Bundler) {
+			errs <- b.Close(ctx)
+		}(b)
+	}
+	var firstErr error
+	for range bs {
+		if err := <-errs; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// Stats returns the sum of the counters of every key.
+func (kb *KeyedBundler) Stats() Stats {
+	var s Stats
+	for _, b := range kb.all() {
+		s.add(b.Stats())
+	}
+	return s
+}
@@ -0,0 +1,109 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bundler
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestKeyedBundler(t *testing.T) {
+	var (
+		mu  sync.Mutex
+		got = map[string][][]int{}
+	)
+	kb := NewKeyedBundler(int(0), func(key string, bundle interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got[key] = append(got[key], bundle.([]int))
+		return nil
+	})
+	kb.BundleCountThreshold = 2
+	kb.DelayThreshold = time.Hour
+	for i := 0; i < 5; i++ {
+		if err := kb.Add("a", i, 1); err != nil {
+			t.Fatal(err)
+		}
+		if err := kb.Add("b", 10+i, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := kb.Close(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string][][]int{
+		"a": {{0, 1}, {2, 3}, {4}},
+		"b": {{10, 11}, {12, 13}, {14}},
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+	if s := kb.Stats(); s.ItemsHandled != 10 || s.BundlesHandled != 6 {
+		t.Errorf("got stats %+v, want 10 items in 6 bundles", s)
+	}
+	if err := kb.Add("c", 1, 1); err != ErrClosed {
+		t.Errorf("Add after Close: got %v, want ErrClosed", err)
+	}
+}
+
+func TestKeyedBundlerSharedBufferedByteLimit(t *testing.T) {
+	kb := NewKeyedBundler(int(0), func(string, interface{}) error { return nil })
+	kb.BufferedByteLimit = 10
+	kb.DelayThreshold = time.Hour
+	kb.BundleCountThreshold = 100
+	kb.BundleByteThreshold = 100
+	if err := kb.Add("a", 1, 6); err != nil {
+		t.Fatal(err)
+	}
+	if err := kb.Add("b", 2, 6); err != ErrOverflow {
+		t.Errorf("got %v, want ErrOverflow", err)
+	}
+	if got := kb.Stats().Overflows; got != 1 {
+		t.Errorf("got %d overflows, want 1", got)
+	}
+	kb.Flush()
+	if err := kb.Add("b", 2, 6); err != nil {
+		t.Errorf("after Flush: got %v, want nil", err)
+	}
+	kb.Flush()
+}
+
+func TestKeyedBundlerOrderedRetry(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		order []int
+		fails = 2
+	)
+	kb := NewKeyedBundler(int(0), func(key string, bundle interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		items := bundle.([]int)
+		if items[0] == 0 && fails > 0 {
+			fails--
+			return context.DeadlineExceeded
+		}
+		order = append(order, items...)
+		return nil
+	})
+	kb.BundleCountThreshold = 1
+	kb.HandlerMaxAttempts = 3
+	kb.HandlerBackoff.Initial = time.Millisecond
+	for i := 0; i < 3; i++ {
+		if err := kb.Add("k", i, 1); err != nil {
+			t.Fatal(err)
+		}
+	}
+	kb.Flush()
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []int{0, 1, 2}; !reflect.DeepEqual(order, want) {
+		t.Errorf("got %v, want %v", order, want)
+	}
+}
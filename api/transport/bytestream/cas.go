@@ -0,0 +1,468 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bytestream
+
+// This file contains helpers for blobs in a content-addressable store, named
+// as described by the Remote Execution API:
+// https://github.com/bazelbuild/remote-apis/blob/master/build/bazel/remote/execution/v2/remote_execution.proto
+
+import (
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	// DefaultChunkSize is the default BlobOptions.ChunkSize.
+	DefaultChunkSize = 4 * 1024 * 1024
+	// DefaultConcurrency is the default BlobOptions.Concurrency.
+	DefaultConcurrency = 4
+)
+
+// Digest identifies a blob by the SHA-256 hash and the size of its
+// uncompressed content.
+type Digest struct {
+	Hash string // lowercase hex
+	Size int64
+}
+
+func (d Digest) String() string {
+	return fmt.Sprintf("%s/%d", d.Hash, d.Size)
+}
+
+// BlobName is a parsed resource name of a blob. Blobs are read with names of
+// the forms
+//
+//	{instance_name}/blobs/{hash}/{size}
+//	{instance_name}/compressed-blobs/{compressor}/{hash}/{size}
+//
+// and written with names of the forms
+//
+//	{instance_name}/uploads/{uuid}/blobs/{hash}/{size}[/{metadata}]
+//	{instance_name}/uploads/{uuid}/compressed-blobs/{compressor}/{hash}/{size}[/{metadata}]
+//
+// where the instance name may be empty.
+type BlobName struct {
+	InstanceName string
+	// UploadID is the uuid of an upload, or empty if the blob is read.
+	UploadID string
+	// Compressor is the compressor of a compressed-blobs name, or empty.
+	Compressor string
+	Digest     Digest
+	// Metadata is the optional trailing part of an upload name.
+	Metadata string
+}
+
+// ParseBlobName parses a blob resource name.
+func ParseBlobName(name string) (*BlobName, error) {
+	segs := strings.Split(name, "/")
+	i := 0
+	for i < len(segs) && segs[i] != "blobs" && segs[i] != "compressed-blobs" && segs[i] != "uploads" {
+		i++
+	}
+	b := &BlobName{InstanceName: strings.Join(segs[:i], "/")}
+	next := func() string {
+		if i >= len(segs) {
+			return ""
+		}
+		i++
+		return segs[i-1]
+	}
+	kind := next()
+	if kind == "uploads" {
+		if b.UploadID = next(); b.UploadID == "" {
+			return nil, fmt.Errorf("bytestream: missing upload id in blob name %q", name)
+		}
+		kind = next()
+	}
+	switch kind {
+	case "blobs":
+	case "compressed-blobs":
+		if b.Compressor = next(); b.Compressor == "" {
+			return nil, fmt.Errorf("bytestream: missing compressor in blob name %q", name)
+		}
+	default:
+		return nil, fmt.Errorf("bytestream: %q is not a blob name", name)
+	}
+	b.Digest.Hash = next()
+	if len(b.Digest.Hash) != sha256.Size*2 || strings.ToLower(b.Digest.Hash) != b.Digest.Hash {
+		return nil, fmt.Errorf("bytestream: invalid SHA-256 hash %q in blob name %q", b.Digest.Hash, name)
+	}
+	if _, err := hex.DecodeString(b.Digest.Hash); err != nil {
+		return nil, fmt.Errorf("bytestream: invalid SHA-256 hash %q in blob name %q", b.Digest.Hash, name)
+	}
+	size, err := strconv.ParseInt(next(), 10, 64)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("bytestream: invalid size in blob name %q", name)
+	}
+	b.Digest.Size = size
+	if i < len(segs) {
+		if b.UploadID == "" {
+			return nil, fmt.Errorf("bytestream: unexpected %q after the digest in blob name %q", strings.Join(segs[i:], "/"), name)
+		}
+		b.Metadata = strings.Join(segs[i:], "/")
+	}
+	return b, nil
+}
+
+// String returns the resource name.
+func (b *BlobName) String() string {
+	var segs []string
+	if b.InstanceName != "" {
+		segs = append(segs, b.InstanceName)
+	}
+	if b.UploadID != "" {
+		segs = append(segs, "uploads", b.UploadID)
+	}
+	if b.Compressor != "" {
+		segs = append(segs, "compressed-blobs", b.Compressor)
+	} else {
+		segs = append(segs, "blobs")
+	}
+	segs = append(segs, b.Digest.Hash, strconv.FormatInt(b.Digest.Size, 10))
+	if b.UploadID != "" && b.Metadata != "" {
+		segs = append(segs, b.Metadata)
+	}
+	return strings.Join(segs, "/")
+}
+
+// A DigestError is returned when the data of a blob doesn't match the digest
+// in its resource name.
+type DigestError struct {
+	ResourceName string
+	Want, Got    Digest
+}
+
+func (e *DigestError) Error() string {
+	return fmt.Sprintf("bytestream: data of %q has digest %v, want %v", e.ResourceName, e.Got, e.Want)
+}
+
+// A Compressor compresses and decompresses the data of compressed-blobs
+// resources. The "deflate" and "zstd" compressors are built in.
+type Compressor interface {
+	NewWriter(w io.Writer) (io.WriteCloser, error)
+	NewReader(r io.Reader) (io.ReadCloser, error)
+}
+
+type deflateCompressor struct{}
+
+func (deflateCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return flate.NewWriter(w, flate.DefaultCompression)
+}
+
+func (deflateCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	return flate.NewReader(r), nil
+}
+
+type zstdCompressor struct{}
+
+func (zstdCompressor) NewWriter(w io.Writer) (io.WriteCloser, error) {
+	return zstd.NewWriter(w)
+}
+
+func (zstdCompressor) NewReader(r io.Reader) (io.ReadCloser, error) {
+	d, err := zstd.NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return d.IOReadCloser(), nil
+}
+
+// BlobOptions configures UploadBlob and DownloadBlob.
+type BlobOptions struct {
+	// ChunkSize is the number of bytes in each sub-range of a blob. The
+	// default is DefaultChunkSize.
+	ChunkSize int64
+
+	// Concurrency is the maximum number of sub-ranges that are transferred
+	// at once. The default is DefaultConcurrency.
+	Concurrency int
+
+	// Compressors maps the compressor names of compressed-blobs resource
+	// names to their implementations. "deflate" and "zstd" are supported
+	// without being listed here; other compressors must be provided.
+	Compressors map[string]Compressor
+}
+
+func (o *BlobOptions) chunkSize() int64 {
+	if o != nil && o.ChunkSize > 0 {
+		return o.ChunkSize
+	}
+	return DefaultChunkSize
+}
+
+func (o *BlobOptions) concurrency() int {
+	if o != nil && o.Concurrency > 0 {
+		return o.Concurrency
+	}
+	return DefaultConcurrency
+}
+
+func (o *BlobOptions) compressor(name string) (Compressor, error) {
+	if o != nil {
+		if c, ok := o.Compressors[name]; ok {
+			return c, nil
+		}
+	}
+	switch name {
+	case "deflate":
+		return deflateCompressor{}, nil
+	case "zstd":
+		return zstdCompressor{}, nil
+	}
+	return nil, fmt.Errorf("bytestream: unsupported compressor %q", name)
+}
+
+// DownloadBlob reads the blob with the given resource name into w and checks
+// it against the digest in the name. If it doesn't match, DownloadBlob returns
+// a *DigestError, and w holds the data that was read.
+//
+// Uncompressed blobs are read in sub-ranges of opts.ChunkSize bytes over up to
+// opts.Concurrency streams at once. Compressed blobs are read over a single
+// stream. opts may be nil.
+func (c *Client) DownloadBlob(ctx context.Context, resourceName string, w io.WriterAt, opts *BlobOptions) error {
+	b, err := ParseBlobName(resourceName)
+	if err != nil {
+		return err
+	}
+	if b.UploadID != "" {
+		return fmt.Errorf("bytestream: cannot download the upload %q", resourceName)
+	}
+	h := sha256.New()
+	var n int64
+	if b.Compressor != "" {
+		n, err = c.downloadCompressed(ctx, resourceName, b, w, h, opts)
+	} else {
+		n = b.Digest.Size
+		err = forEachChunk(ctx, b.Digest.Size, opts,
+			func(ctx context.Context, off, length int64) ([]byte, error) {
+				return c.downloadChunk(ctx, resourceName, w, off, length)
+			},
+			func(p []byte) error {
+				h.Write(p)
+				return nil
+			})
+	}
+	if err != nil {
+		return err
+	}
+	return checkDigest(resourceName, b.Digest, h, n)
+}
+
+// downloadChunk reads length bytes at off into w and returns them.
+func (c *Client) downloadChunk(ctx context.Context, resourceName string, w io.WriterAt, off, length int64) ([]byte, error) {
+	r, err := c.newReader(ctx, resourceName, off, length)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	p := make([]byte, length)
+	if _, err := io.ReadFull(r, p); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			err = fmt.Errorf("bytestream: %q ended before offset %d", resourceName, off+length)
+		}
+		return nil, err
+	}
+	if _, err := w.WriteAt(p, off); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+// downloadCompressed reads and decompresses a compressed blob into w and h,
+// and returns the size of the decompressed data.
+func (c *Client) downloadCompressed(ctx context.Context, resourceName string, b *BlobName, w io.WriterAt, h hash.Hash, opts *BlobOptions) (int64, error) {
+	comp, err := opts.compressor(b.Compressor)
+	if err != nil {
+		return 0, err
+	}
+	r, err := c.NewReader(ctx, resourceName)
+	if err != nil {
+		return 0, err
+	}
+	defer r.Close()
+	dr, err := comp.NewReader(r)
+	if err != nil {
+		return 0, err
+	}
+	defer dr.Close()
+	// Read one byte more than expected, so that too much data is noticed.
+	return io.Copy(io.MultiWriter(&offsetWriter{w: w}, h), io.LimitReader(dr, b.Digest.Size+1))
+}
+
+// UploadBlob writes the blob with the given upload resource name from the
+// first Digest.Size bytes of r. The data is checked against the digest in the
+// name before the write is finished; if it doesn't match, the write is
+// abandoned and UploadBlob returns a *DigestError.
+//
+// ByteStream writes must be sequential, so the blob is written over a single
+// stream, while up to opts.Concurrency sub-ranges of opts.ChunkSize bytes are
+// read ahead from r. Writes of compressed blobs are not retried. opts may be
+// nil.
+//
+// The empty blob is always present, so UploadBlob doesn't write it.
+func (c *Client) UploadBlob(ctx context.Context, resourceName string, r io.ReaderAt, opts *BlobOptions) error {
+	b, err := ParseBlobName(resourceName)
+	if err != nil {
+		return err
+	}
+	if b.UploadID == "" {
+		return fmt.Errorf("bytestream: %q is not an upload resource name", resourceName)
+	}
+	var comp Compressor
+	if b.Compressor != "" {
+		if comp, err = opts.compressor(b.Compressor); err != nil {
+			return err
+		}
+	}
+	if b.Digest.Size == 0 {
+		// The empty blob is always present and need not be written.
+		return checkDigest(resourceName, b.Digest, sha256.New(), 0)
+	}
+
+	// Cancelling ctx abandons the write without finishing it.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	bw, err := c.NewWriter(ctx, resourceName)
+	if err != nil {
+		return err
+	}
+	var dst io.Writer = bw
+	var cw io.WriteCloser
+	if comp != nil {
+		// The compressed stream can't be resumed at an arbitrary offset.
+		bw.retrier = nil
+		if cw, err = comp.NewWriter(bw); err != nil {
+			return err
+		}
+		dst = cw
+	}
+
+	h := sha256.New()
+	err = forEachChunk(ctx, b.Digest.Size, opts,
+		func(ctx context.Context, off, length int64) ([]byte, error) {
+			p := make([]byte, length)
+			if n, err := r.ReadAt(p, off); n < len(p) {
+				if err == nil || err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, err
+			}
+			return p, nil
+		},
+		func(p []byte) error {
+			h.Write(p)
+			_, err := dst.Write(p)
+			return err
+		})
+	if err != nil {
+		return err
+	}
+	if err := checkDigest(resourceName, b.Digest, h, b.Digest.Size); err != nil {
+		return err
+	}
+	if cw == nil {
+		return bw.Close()
+	}
+	if err := cw.Close(); err != nil {
+		return err
+	}
+	return bw.close(b.Digest.Size)
+}
+
+// forEachChunk splits size bytes into chunks. It calls fetch for up to
+// opts.Concurrency chunks at once, and consume with the result of each fetch
+// in order.
+func forEachChunk(ctx context.Context, size int64, opts *BlobOptions, fetch func(ctx context.Context, off, length int64) ([]byte, error), consume func([]byte) error) error {
+	chunkSize := opts.chunkSize()
+	type chunk struct {
+		done chan struct{}
+		data []byte
+		err  error
+	}
+	chunks := make([]chunk, (size+chunkSize-1)/chunkSize)
+	for i := range chunks {
+		chunks[i].done = make(chan struct{})
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	// Let all fetches return before returning.
+	defer wg.Wait()
+	defer cancel()
+
+	// A chunk holds a slot in sem from the start of its fetch until it has
+	// been consumed, which bounds the memory in use.
+	sem := make(chan struct{}, opts.concurrency())
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := range chunks {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			off := int64(i) * chunkSize
+			length := chunkSize
+			if size-off < length {
+				length = size - off
+			}
+			wg.Add(1)
+			go func(c *chunk) {
+				defer wg.Done()
+				c.data, c.err = fetch(ctx, off, length)
+				close(c.done)
+			}(&chunks[i])
+		}
+	}()
+
+	for i := range chunks {
+		c := &chunks[i]
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		if c.err != nil {
+			return c.err
+		}
+		if err := consume(c.data); err != nil {
+			return err
+		}
+		c.data = nil
+		<-sem
+	}
+	return nil
+}
+
+func checkDigest(resourceName string, want Digest, h hash.Hash, size int64) error {
+	got := Digest{Hash: hex.EncodeToString(h.Sum(nil)), Size: size}
+	if got != want {
+		return &DigestError{ResourceName: resourceName, Want: want, Got: got}
+	}
+	return nil
+}
+
+// offsetWriter writes to an io.WriterAt sequentially.
+type offsetWriter struct {
+	w   io.WriterAt
+	off int64
+}
+
+func (o *offsetWriter) Write(p []byte) (int, error) {
+	n, err := o.w.WriteAt(p, o.off)
+	o.off += int64(n)
+	return n, err
+}
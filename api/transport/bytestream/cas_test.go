@@ -0,0 +1,302 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bytestream
+
+import (
+	"bytes"
+	"compress/flate"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+func digestOf(data string) Digest {
+	h := sha256.Sum256([]byte(data))
+	return Digest{Hash: hex.EncodeToString(h[:]), Size: int64(len(data))}
+}
+
+func TestParseBlobName(t *testing.T) {
+	d := digestOf("hello")
+	for _, tc := range []struct {
+		name    string
+		want    *BlobName
+		wantErr bool
+	}{
+		{name: "blobs/" + d.String(), want: &BlobName{Digest: d}},
+		{name: "a/b/blobs/" + d.String(), want: &BlobName{InstanceName: "a/b", Digest: d}},
+		{name: "inst/compressed-blobs/zstd/" + d.String(), want: &BlobName{InstanceName: "inst", Compressor: "zstd", Digest: d}},
+		{name: "inst/uploads/u1/blobs/" + d.String(), want: &BlobName{InstanceName: "inst", UploadID: "u1", Digest: d}},
+		{name: "uploads/u1/compressed-blobs/deflate/" + d.String() + "/meta/data", want: &BlobName{UploadID: "u1", Compressor: "deflate", Digest: d, Metadata: "meta/data"}},
+		{name: "inst/blobs/" + d.String() + "/extra", wantErr: true},
+		{name: "inst/" + d.String(), wantErr: true},
+		{name: "blobs/" + strings.ToUpper(d.Hash) + "/5", wantErr: true},
+		{name: "blobs/abc/5", wantErr: true},
+		{name: "blobs/" + d.Hash + "/-1", wantErr: true},
+		{name: "blobs/" + d.Hash, wantErr: true},
+		{name: "compressed-blobs/" + d.String(), wantErr: true},
+		{name: "uploads/blobs/" + d.String(), wantErr: true},
+	} {
+		got, err := ParseBlobName(tc.name)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("ParseBlobName(%q): got err %v, want error %t", tc.name, err, tc.wantErr)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if *got != *tc.want {
+			t.Errorf("ParseBlobName(%q) = %+v, want %+v", tc.name, got, tc.want)
+		}
+		if got.String() != tc.name {
+			t.Errorf("ParseBlobName(%q).String() = %q", tc.name, got.String())
+		}
+	}
+}
+
+// blobReadHandler serves blobs by resource name.
+type blobReadHandler struct {
+	blobs map[string][]byte
+}
+
+func (h *blobReadHandler) GetReader(ctx context.Context, name string) (io.ReaderAt, error) {
+	b, ok := h.blobs[name]
+	if !ok {
+		return nil, fmt.Errorf("no blob %q", name)
+	}
+	return bytes.NewReader(b), nil
+}
+
+func (h *blobReadHandler) Close(ctx context.Context, name string) error { return nil }
+
+// writerAtBuffer is an in-memory io.WriterAt.
+type writerAtBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (w *writerAtBuffer) WriteAt(p []byte, off int64) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if end := int(off) + len(p); end > len(w.buf) {
+		w.buf = append(w.buf, make([]byte, end-len(w.buf))...)
+	}
+	return copy(w.buf[off:], p), nil
+}
+
+func deflate(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	fw, err := flate.NewWriter(&buf, flate.BestSpeed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(fw, data)
+	if err := fw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func zstdCompress(t *testing.T, data string) []byte {
+	var buf bytes.Buffer
+	zw, err := zstd.NewWriter(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	io.WriteString(zw, data)
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// decompress returns the data of a compressed-blobs upload.
+func decompress(t *testing.T, name string, data []byte) []byte {
+	var r io.Reader = bytes.NewReader(data)
+	switch {
+	case strings.Contains(name, "/compressed-blobs/deflate/"):
+		r = flate.NewReader(r)
+	case strings.Contains(name, "/compressed-blobs/zstd/"):
+		zr, err := zstd.NewReader(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		r = zr
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("%s: %v", name, err)
+	}
+	return got
+}
+
+func TestDownloadBlob(t *testing.T) {
+	data := strings.Repeat("0123456789", 10)
+	d := digestOf(data)
+	name := "inst/blobs/" + d.String()
+	badName := "inst/blobs/" + digestOf("other").Hash + "/100"
+	compressedName := "inst/compressed-blobs/deflate/" + d.String()
+	zstdName := "inst/compressed-blobs/zstd/" + d.String()
+	rh := &blobReadHandler{blobs: map[string][]byte{
+		name:           []byte(data),
+		badName:        []byte(data),
+		compressedName: deflate(t, data),
+		zstdName:       zstdCompress(t, data),
+	}}
+	for _, tc := range []struct {
+		desc        string
+		name        string
+		opts        *BlobOptions
+		wantStreams int
+		wantErr     bool
+	}{
+		{desc: "one chunk", name: name, wantStreams: 1},
+		{desc: "chunks", name: name, opts: &BlobOptions{ChunkSize: 7, Concurrency: 3}, wantStreams: 15},
+		{desc: "chunks one at a time", name: name, opts: &BlobOptions{ChunkSize: 10, Concurrency: 1}, wantStreams: 10},
+		{desc: "compressed", name: compressedName, opts: &BlobOptions{ChunkSize: 7}, wantStreams: 1},
+		{desc: "zstd", name: zstdName, wantStreams: 1},
+		{desc: "digest mismatch", name: badName, opts: &BlobOptions{ChunkSize: 7}, wantStreams: 15, wantErr: true},
+		{desc: "missing blob", name: "inst/blobs/" + digestOf("missing").String(), wantErr: true},
+		{desc: "unknown compressor", name: "inst/compressed-blobs/brotli/" + d.String(), wantErr: true},
+		{desc: "upload name", name: "inst/uploads/u/blobs/" + d.String(), wantErr: true},
+	} {
+		fi := &faultInjector{}
+		client, cleanup := newFaultySetup(t, fi, rh, nil)
+		var w writerAtBuffer
+		err := client.DownloadBlob(context.Background(), tc.name, &w, tc.opts)
+		cleanup()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got err %v, want error %t", tc.desc, err, tc.wantErr)
+			continue
+		}
+		if tc.wantStreams != 0 && fi.streams != tc.wantStreams {
+			t.Errorf("%s: got %d streams, want %d", tc.desc, fi.streams, tc.wantStreams)
+		}
+		if err != nil {
+			continue
+		}
+		if got := string(w.buf); got != data {
+			t.Errorf("%s: got %q, want %q", tc.desc, got, data)
+		}
+	}
+}
+
+func TestDownloadBlob_DigestError(t *testing.T) {
+	name := "blobs/" + digestOf("want").String()
+	rh := &blobReadHandler{blobs: map[string][]byte{name: []byte("got!")}}
+	client, cleanup := newFaultySetup(t, &faultInjector{}, rh, nil)
+	defer cleanup()
+	err := client.DownloadBlob(context.Background(), name, &writerAtBuffer{}, nil)
+	de, ok := err.(*DigestError)
+	if !ok {
+		t.Fatalf("got %v, want a *DigestError", err)
+	}
+	if want := digestOf("got!"); de.Got != want {
+		t.Errorf("got digest %v, want %v", de.Got, want)
+	}
+}
+
+func TestDownloadBlob_Retry(t *testing.T) {
+	data := strings.Repeat("0123456789", 10)
+	name := "blobs/" + digestOf(data).String()
+	rh := &chunkedReadHandler{data: data}
+	fi := &faultInjector{after: 1, faults: 2}
+	client, cleanup := newFaultySetup(t, fi, rh, nil)
+	defer cleanup()
+	client.Retry = testRetryPolicy
+	var w writerAtBuffer
+	if err := client.DownloadBlob(context.Background(), name, &w, &BlobOptions{ChunkSize: 30, Concurrency: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(w.buf); got != data {
+		t.Errorf("got %q, want %q", got, data)
+	}
+}
+
+func TestUploadBlob(t *testing.T) {
+	data := strings.Repeat("0123456789", 10)
+	d := digestOf(data)
+	for _, tc := range []struct {
+		desc    string
+		name    string
+		data    string
+		opts    *BlobOptions
+		wantErr bool
+	}{
+		{desc: "one chunk", name: "inst/uploads/u/blobs/" + d.String(), data: data},
+		{desc: "chunks", name: "inst/uploads/u/blobs/" + d.String(), data: data, opts: &BlobOptions{ChunkSize: 7, Concurrency: 3}},
+		{desc: "compressed", name: "inst/uploads/u/compressed-blobs/deflate/" + d.String(), data: data, opts: &BlobOptions{ChunkSize: 7}},
+		{desc: "zstd", name: "inst/uploads/u/compressed-blobs/zstd/" + d.String(), data: data, opts: &BlobOptions{ChunkSize: 7}},
+		{desc: "empty", name: "uploads/u/blobs/" + digestOf("").String()},
+		{desc: "digest mismatch", name: "inst/uploads/u/blobs/" + digestOf("other").Hash + "/100", data: data, opts: &BlobOptions{ChunkSize: 7}, wantErr: true},
+		{desc: "short data", name: "inst/uploads/u/blobs/" + d.String(), data: data[:50], wantErr: true},
+		{desc: "unknown compressor", name: "inst/uploads/u/compressed-blobs/brotli/" + d.String(), data: data, wantErr: true},
+		{desc: "not an upload", name: "inst/blobs/" + d.String(), data: data, wantErr: true},
+	} {
+		wh := &resumableWriteHandler{data: map[string][]byte{}, done: map[string][]byte{}}
+		client, cleanup := newFaultySetup(t, &faultInjector{}, nil, wh)
+		err := client.UploadBlob(context.Background(), tc.name, strings.NewReader(tc.data), tc.opts)
+		cleanup()
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got err %v, want error %t", tc.desc, err, tc.wantErr)
+			continue
+		}
+		got, done := wh.done[tc.name]
+		if tc.wantErr || tc.data == "" {
+			if done {
+				t.Errorf("%s: upload was finished", tc.desc)
+			}
+			continue
+		}
+		if got = decompress(t, tc.name, got); string(got) != tc.data {
+			t.Errorf("%s: server got %q, want %q", tc.desc, got, tc.data)
+		}
+	}
+}
+
+func TestUploadBlob_Retry(t *testing.T) {
+	data := strings.Repeat("0123456789", 10)
+	name := "uploads/u/blobs/" + digestOf(data).String()
+	wh := &resumableWriteHandler{data: map[string][]byte{}, done: map[string][]byte{}}
+	fi := &faultInjector{after: 2, faults: 2}
+	client, cleanup := newFaultySetup(t, fi, nil, wh)
+	defer cleanup()
+	client.Retry = testRetryPolicy
+	if err := client.UploadBlob(context.Background(), name, strings.NewReader(data), &BlobOptions{ChunkSize: 10}); err != nil {
+		t.Fatal(err)
+	}
+	if got := string(wh.done[name]); got != data {
+		t.Errorf("server got %q, want %q", got, data)
+	}
+}
+
+func TestCheckCommittedCompressed(t *testing.T) {
+	// 40 bytes were written, the compressed form of a 100-byte blob.
+	for _, tc := range []struct {
+		size, uncompressedSize int64
+		wantErr                bool
+	}{
+		{size: 40},
+		{size: 30, wantErr: true},
+		{size: -1, wantErr: true},
+		{size: 40, uncompressedSize: 100},
+		{size: 100, uncompressedSize: 100},
+		{size: -1, uncompressedSize: 100},
+		{size: 30, uncompressedSize: 100, wantErr: true},
+	} {
+		w := &Writer{offset: 40}
+		if err := w.checkCommitted(tc.size, tc.uncompressedSize); (err != nil) != tc.wantErr {
+			t.Errorf("checkCommitted(%d, %d): got err %v, want error %t", tc.size, tc.uncompressedSize, err, tc.wantErr)
+		}
+	}
+}
@@ -13,10 +13,14 @@ package bytestream
 import (
 	"context"
 	"fmt"
+	"io"
 	"math/rand"
 	"time"
 
+	"github.com/googleapis/gax-go/v2"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	pb "google.golang.org/genproto/googleapis/bytestream"
 )
@@ -27,10 +31,86 @@ const (
 	backoffBase = 10 * time.Millisecond
 	backoffMax  = 1 * time.Second
 	maxTries    = 5
+
+	// DefaultResumeBufferSize is the default RetryPolicy.ResumeBufferSize.
+	DefaultResumeBufferSize = 16 * 1024 * 1024
 )
 
+// RetryPolicy controls how Readers and Writers recover from failed streams.
+//
+// A Reader reopens its stream at the offset of the next byte it has not yet
+// received. A Writer opens a new stream, asks the server how many bytes it has
+// committed with QueryWriteStatus, and continues writing from there.
+type RetryPolicy struct {
+	// MaxRetries is the maximum number of times a stream is reopened over the
+	// lifetime of a Reader or Writer.
+	MaxRetries int
+
+	// Backoff controls the delay before a stream is reopened. The zero value
+	// uses the gax.Backoff defaults.
+	Backoff gax.Backoff
+
+	// ShouldRetry reports whether a stream that failed with err should be
+	// reopened. If nil, streams are reopened after errors with codes
+	// Unavailable, Aborted and Internal.
+	ShouldRetry func(err error) bool
+
+	// ResumeBufferSize is the number of bytes, sent but possibly not yet
+	// committed by the server, that a Writer keeps in memory in order to
+	// resume. If the server has committed less than the Writer can resend,
+	// the write fails. The default is DefaultResumeBufferSize.
+	ResumeBufferSize int
+}
+
+func (p *RetryPolicy) shouldRetry(err error) bool {
+	if p.ShouldRetry != nil {
+		return p.ShouldRetry(err)
+	}
+	switch status.Code(err) {
+	case codes.Unavailable, codes.Aborted, codes.Internal:
+		return true
+	}
+	return false
+}
+
+func (p *RetryPolicy) resumeBufferSize() int {
+	if p.ResumeBufferSize > 0 {
+		return p.ResumeBufferSize
+	}
+	return DefaultResumeBufferSize
+}
+
+// retrier tracks the retries of a single Reader or Writer.
+type retrier struct {
+	policy  *RetryPolicy
+	backoff gax.Backoff
+	retries int
+}
+
+func newRetrier(p *RetryPolicy) *retrier {
+	if p == nil {
+		return nil
+	}
+	return &retrier{policy: p, backoff: p.Backoff}
+}
+
+// retry reports whether the stream that failed with err should be reopened.
+// If so, it waits for the backoff delay first.
+func (r *retrier) retry(ctx context.Context, err error) bool {
+	if r == nil || r.retries >= r.policy.MaxRetries || ctx.Err() != nil || !r.policy.shouldRetry(err) {
+		return false
+	}
+	r.retries++
+	return gax.Sleep(ctx, r.backoff.Pause()) == nil
+}
+
 // Client is the go wrapper around a ByteStreamClient and provides an interface to it.
 type Client struct {
+	// Retry, if non-nil, lets the Readers and Writers created by the Client
+	// recover from transient stream failures. Set it before creating Readers
+	// and Writers.
+	Retry *RetryPolicy
+
 	client  pb.ByteStreamClient
 	options []grpc.CallOption
 }
@@ -51,6 +131,9 @@ type Reader struct {
 	resourceName string
 	err          error
 	buf          []byte
+	offset       int64 // offset of the next byte to be received
+	limit        int64 // offset at which the read ends, or 0 for the end of the resource
+	retrier      *retrier
 }
 
 // ResourceName gets the resource name this Reader is reading.
@@ -69,11 +152,26 @@ func (r *Reader) Read(p []byte) (int, error) {
 	for tries := 0; len(r.buf) == 0 && tries < maxTries; tries++ {
 		// No data in buffer.
 		resp, err := r.readClient.Recv()
+		if err != nil && err != io.EOF && r.limit > 0 && r.offset >= r.limit {
+			// Everything requested has been received.
+			err = io.EOF
+		}
+		if err != nil && err != io.EOF && r.retrier.retry(r.ctx, err) {
+			// Reopen the stream where the broken one left off.
+			readClient, rerr := r.c.client.Read(r.ctx, r.request(), r.c.options...)
+			if rerr == nil {
+				r.readClient = readClient
+				tries--
+				continue
+			}
+			err = rerr
+		}
 		if err != nil {
 			r.err = err
 			return 0, err
 		}
 		r.buf = resp.Data
+		r.offset += int64(len(resp.Data))
 		if len(r.buf) != 0 {
 			break
 		}
@@ -103,6 +201,18 @@ func (r *Reader) Read(p []byte) (int, error) {
 	return n, nil
 }
 
+// request returns the ReadRequest for the rest of the read.
+func (r *Reader) request() *pb.ReadRequest {
+	req := &pb.ReadRequest{
+		ResourceName: r.resourceName,
+		ReadOffset:   r.offset,
+	}
+	if r.limit > 0 {
+		req.ReadLimit = r.limit - r.offset
+	}
+	return req
+}
+
 // Close implements io.Closer.
 func (r *Reader) Close() error {
 	if r.readClient == nil {
@@ -120,30 +230,47 @@ func (c *Client) NewReader(ctx context.Context, resourceName string) (*Reader, e
 
 // NewReaderAt creates a new Reader to read a resource from the given offset.
 func (c *Client) NewReaderAt(ctx context.Context, resourceName string, offset int64) (*Reader, error) {
-	// readClient is set up for Read(). ReadAt() will copy needed fields into its reentrantReader.
-	readClient, err := c.client.Read(ctx, &pb.ReadRequest{
-		ResourceName: resourceName,
-		ReadOffset:   offset,
-	}, c.options...)
-	if err != nil {
-		return nil, err
-	}
+	return c.newReader(ctx, resourceName, offset, 0)
+}
 
-	return &Reader{
+// newReader creates a Reader for at most limit bytes of a resource, starting
+// at offset. A limit of 0 reads to the end of the resource.
+func (c *Client) newReader(ctx context.Context, resourceName string, offset, limit int64) (*Reader, error) {
+	r := &Reader{
 		ctx:          ctx,
 		c:            c,
 		resourceName: resourceName,
-		readClient:   readClient,
-	}, nil
+		offset:       offset,
+		retrier:      newRetrier(c.Retry),
+	}
+	if limit > 0 {
+		r.limit = offset + limit
+	}
+	// readClient is set up for Read(). ReadAt() will copy needed fields into its reentrantReader.
+	readClient, err := c.client.Read(ctx, r.request(), c.options...)
+	if err != nil {
+		return nil, err
+	}
+	r.readClient = readClient
+	return r, nil
 }
 
 // Writer writes to a byte stream.
 type Writer struct {
 	ctx          context.Context
+	c            *Client
 	writeClient  pb.ByteStream_WriteClient
 	resourceName string
 	offset       int64
 	err          error
+	retrier      *retrier
+
+	// sentName is whether the resource name has been sent on writeClient.
+	sentName bool
+	// resendBuf holds the bytes at the end of the stream that may not have
+	// been committed by the server, so a write can be resumed. Only used when
+	// retrier is non-nil.
+	resendBuf []byte
 }
 
 // ResourceName gets the resource name this Writer is writing.
@@ -163,47 +290,169 @@ func (w *Writer) Write(p []byte) (int, error) {
 		if bufSize > MaxBufSize {
 			bufSize = MaxBufSize
 		}
-		r := pb.WriteRequest{
-			WriteOffset: w.offset,
-			FinishWrite: false,
-			Data:        p[n : n+bufSize],
-		}
-		// Bytestream only requires the resourceName to be sent in the first WriteRequest.
-		if w.offset == 0 {
-			r.ResourceName = w.resourceName
-		}
-		err := w.writeClient.Send(&r)
-		if err != nil {
-			w.err = err
-			return n, err
+		data := p[n : n+bufSize]
+		if err := w.send(data, false); err != nil {
+			if err = w.resume(err); err != nil {
+				w.err = err
+				return n, err
+			}
+			// The resumed stream has caught up to w.offset; send data again.
+			continue
 		}
 		w.offset += int64(bufSize)
+		w.keepForResend(data)
 		n += bufSize
 	}
 	return n, nil
 }
 
-// Close implements io.Closer. It is the caller's responsibility to call Close() when writing is done.
-func (w *Writer) Close() error {
-	err := w.writeClient.Send(&pb.WriteRequest{
-		ResourceName: w.resourceName,
-		WriteOffset:  w.offset,
-		FinishWrite:  true,
-		Data:         nil,
-	})
+// send sends a single WriteRequest at w.offset.
+func (w *Writer) send(data []byte, finish bool) error {
+	r := pb.WriteRequest{
+		WriteOffset: w.offset,
+		FinishWrite: finish,
+		Data:        data,
+	}
+	// Bytestream only requires the resourceName to be sent in the first
+	// WriteRequest of a stream.
+	if !w.sentName || finish {
+		r.ResourceName = w.resourceName
+	}
+	if err := w.writeClient.Send(&r); err != nil {
+		return err
+	}
+	w.sentName = true
+	return nil
+}
+
+// keepForResend appends data to the resend buffer, dropping the oldest bytes
+// beyond the policy's ResumeBufferSize.
+func (w *Writer) keepForResend(data []byte) {
+	if w.retrier == nil {
+		return
+	}
+	w.resendBuf = append(w.resendBuf, data...)
+	if max := w.retrier.policy.resumeBufferSize(); len(w.resendBuf) > max {
+		w.resendBuf = append(w.resendBuf[:0], w.resendBuf[len(w.resendBuf)-max:]...)
+	}
+}
+
+// resume recovers from a failed stream. It opens a new stream, asks the server
+// how many bytes it has committed and resends the bytes after that, so that
+// the new stream is at w.offset. It returns nil if the write can continue.
+func (w *Writer) resume(err error) error {
+	for {
+		if err == io.EOF {
+			// Send reports a broken stream as io.EOF; the actual error is
+			// returned by CloseAndRecv.
+			if _, cerr := w.writeClient.CloseAndRecv(); cerr != nil {
+				err = cerr
+			}
+		}
+		if !w.retrier.retry(w.ctx, err) {
+			return err
+		}
+		if err = w.reopen(); err == nil {
+			return nil
+		}
+	}
+}
+
+// reopen opens a new stream and brings it up to w.offset.
+func (w *Writer) reopen() error {
+	st, err := w.c.client.QueryWriteStatus(w.ctx, &pb.QueryWriteStatusRequest{ResourceName: w.resourceName}, w.c.options...)
+	if status.Code(err) == codes.NotFound {
+		// Nothing has been committed yet.
+		st, err = &pb.QueryWriteStatusResponse{}, nil
+	}
 	if err != nil {
-		w.err = err
-		return fmt.Errorf("Send(WriteRequest< FinishWrite >) failed: %v", err)
+		return err
 	}
-	resp, err := w.writeClient.CloseAndRecv()
+	bufStart := w.offset - int64(len(w.resendBuf))
+	if st.CommittedSize < bufStart || st.CommittedSize > w.offset {
+		return fmt.Errorf("bytestream: cannot resume write of %q: server committed %d bytes, can only resend from %d to %d",
+			w.resourceName, st.CommittedSize, bufStart, w.offset)
+	}
+	wc, err := w.c.client.Write(w.ctx, w.c.options...)
 	if err != nil {
-		w.err = err
-		return fmt.Errorf("CloseAndRecv: %v", err)
+		return err
 	}
-	if resp == nil {
-		err = fmt.Errorf("expected a response on close, got %v", resp)
-	} else if resp.CommittedSize != w.offset {
-		err = fmt.Errorf("server only wrote %d bytes, want %d", resp.CommittedSize, w.offset)
+	w.writeClient = wc
+	w.sentName = false
+	// Everything before st.CommittedSize is known to be committed; the bytes
+	// after it may have to be resent again if the new stream fails too.
+	w.resendBuf = w.resendBuf[st.CommittedSize-bufStart:]
+
+	end := w.offset
+	w.offset = st.CommittedSize
+	for i := 0; i < len(w.resendBuf); {
+		n := len(w.resendBuf) - i
+		if n > MaxBufSize {
+			n = MaxBufSize
+		}
+		if err := w.send(w.resendBuf[i:i+n], false); err != nil {
+			w.offset = end
+			return err
+		}
+		w.offset += int64(n)
+		i += n
+	}
+	return nil
+}
+
+// Close implements io.Closer. It is the caller's responsibility to call Close() when writing is done.
+func (w *Writer) Close() error {
+	return w.close(0)
+}
+
+// close finishes the write. If uncompressedSize is non-zero, the data written
+// is the compressed form of a blob of that size, and servers may report either
+// it or -1 as the committed size of the write.
+func (w *Writer) close(uncompressedSize int64) error {
+	for {
+		resp, err := w.finish()
+		if err == nil {
+			if resp == nil {
+				w.err = fmt.Errorf("expected a response on close, got %v", resp)
+				return w.err
+			}
+			return w.checkCommitted(resp.CommittedSize, uncompressedSize)
+		}
+		if !w.retrier.retry(w.ctx, err) {
+			w.err = fmt.Errorf("Close: %v", err)
+			return w.err
+		}
+		// The write may have completed even though the response was lost.
+		st, qerr := w.c.client.QueryWriteStatus(w.ctx, &pb.QueryWriteStatusRequest{ResourceName: w.resourceName}, w.c.options...)
+		if qerr == nil && st.Complete {
+			return w.checkCommitted(st.CommittedSize, uncompressedSize)
+		}
+		if err := w.reopen(); err != nil && !w.retrier.policy.shouldRetry(err) {
+			w.err = err
+			return err
+		}
+	}
+}
+
+// finish sends the final WriteRequest and returns the server's response. An
+// error from sending is only returned if it isn't io.EOF, which signals that
+// the actual error is returned by CloseAndRecv.
+func (w *Writer) finish() (*pb.WriteResponse, error) {
+	if err := w.send(nil, true); err != nil && err != io.EOF {
+		return nil, err
+	}
+	return w.writeClient.CloseAndRecv()
+}
+
+// checkCommitted sets w.err if the server committed fewer bytes than were
+// written. uncompressedSize is as for close.
+func (w *Writer) checkCommitted(size, uncompressedSize int64) error {
+	var err error
+	if uncompressedSize != 0 && (size == -1 || size == uncompressedSize) {
+		size = w.offset
+	}
+	if size != w.offset {
+		err = fmt.Errorf("server only wrote %d bytes, want %d", size, w.offset)
 	}
 	w.err = err
 	return err
@@ -216,7 +465,8 @@ func (w *Writer) Close() error {
 //
 // It is the caller's responsibility to call Close when writing is done.
 //
-// TODO: There is currently no way to resume a write. Maybe NewWriter should begin with a call to QueryWriteStatus.
+// If the Client has a RetryPolicy, the Writer resumes the write after
+// transient stream failures.
 func (c *Client) NewWriter(ctx context.Context, resourceName string) (*Writer, error) {
 	wc, err := c.client.Write(ctx, c.options...)
 	if err != nil {
@@ -224,7 +474,9 @@ func (c *Client) NewWriter(ctx context.Context, resourceName string) (*Writer, e
 	}
 	return &Writer{
 		ctx:          ctx,
+		c:            c,
 		writeClient:  wc,
 		resourceName: resourceName,
+		retrier:      newRetrier(c.Retry),
 	}, nil
 }
@@ -10,11 +10,14 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"os"
 
 	"google.golang.org/grpc"
 )
 
-func ExampleNewClient(serverPort int, resourceName string) {
+func ExampleNewClient() {
+	serverPort := 8080
+	resourceName := "foo"
 	ctx := context.Background()
 	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", serverPort), grpc.WithInsecure())
 	if err != nil {
@@ -34,7 +37,9 @@ func ExampleNewClient(serverPort int, resourceName string) {
 	log.Printf("read %q", buf.String())
 }
 
-func ExampleNewReader(serverPort int, resourceName string) {
+func ExampleClient_NewReader() {
+	serverPort := 8080
+	resourceName := "foo"
 	ctx := context.Background()
 	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", serverPort), grpc.WithInsecure())
 	if err != nil {
@@ -54,7 +59,9 @@ func ExampleNewReader(serverPort int, resourceName string) {
 	log.Printf("read %q", buf.String())
 }
 
-func ExampleNewWriter(serverPort int, resourceName string) {
+func ExampleClient_NewWriter() {
+	serverPort := 8080
+	resourceName := "foo"
 	ctx := context.Background()
 	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", serverPort), grpc.WithInsecure())
 	if err != nil {
@@ -82,3 +89,27 @@ func ExampleNewWriter(serverPort int, resourceName string) {
 	}
 	log.Printf("Wrote %d bytes", n)
 }
+
+func ExampleClient_DownloadBlob() {
+	serverPort := 8080
+	resourceName := "instance/blobs/b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9/11"
+	ctx := context.Background()
+	conn, err := grpc.Dial(fmt.Sprintf("localhost:%d", serverPort), grpc.WithInsecure())
+	if err != nil {
+		log.Printf("grpc.Dial: %v", err)
+		return
+	}
+	client := NewClient(conn)
+
+	f, err := os.Create("blob")
+	if err != nil {
+		log.Printf("Create: %v", err)
+		return
+	}
+	defer f.Close()
+	// The blob is read in 1 MiB sub-ranges over up to 8 streams at once.
+	opts := &BlobOptions{ChunkSize: 1 << 20, Concurrency: 8}
+	if err := client.DownloadBlob(ctx, resourceName, f, opts); err != nil {
+		log.Printf("DownloadBlob(%q): %v", resourceName, err)
+	}
+}
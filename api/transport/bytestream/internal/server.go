@@ -92,6 +92,8 @@ func NewServer(gsrv *grpc.Server, readHandler ReadHandler, writeHandler WriteHan
 // Write handles the pb.ByteStream_WriteServer and sends a pb.WriteResponse
 // Implements bytestream.proto "rpc Write(stream WriteRequest) returns (WriteResponse)".
 func (rpc *grpcService) Write(stream pb.ByteStream_WriteServer) error {
+	// The resource name is only required in the first WriteRequest of a stream.
+	var name string
 	for {
 		writeReq, err := stream.Recv()
 		if err == io.EOF {
@@ -103,6 +105,10 @@ func (rpc *grpcService) Write(stream pb.ByteStream_WriteServer) error {
 		if rpc.parent.writeHandler == nil {
 			return grpc.Errorf(codes.Unimplemented, "instance of NewServer(writeHandler = nil) rejects all writes")
 		}
+		if writeReq.ResourceName == "" {
+			writeReq.ResourceName = name
+		}
+		name = writeReq.ResourceName
 
 		status, ok := rpc.parent.status[writeReq.ResourceName]
 		if !ok {
@@ -173,7 +179,7 @@ func (rpc *grpcService) QueryWriteStatus(ctx context.Context, request *pb.QueryW
 }
 
 func (rpc *grpcService) readFrom(request *pb.ReadRequest, reader io.ReaderAt, stream pb.ByteStream_ReadServer) error {
-	limit := int(request.ReadLimit)
+	limit := request.ReadLimit
 	if limit < 0 {
 		return grpc.Errorf(codes.InvalidArgument, "Read(): read_limit=%d is invalid", limit)
 	}
@@ -181,25 +187,33 @@ func (rpc *grpcService) readFrom(request *pb.ReadRequest, reader io.ReaderAt, st
 	if offset < 0 {
 		return grpc.Errorf(codes.InvalidArgument, "Read(): offset=%d is invalid", offset)
 	}
+	// Reading past the end of the resource is an error, but reading at the
+	// end returns no data. That can only be told apart if the size is known.
+	if sr, ok := reader.(interface{ Size() int64 }); ok && offset > sr.Size() {
+		return grpc.Errorf(codes.OutOfRange, "Read(): offset=%d is beyond the size %d of %q", offset, sr.Size(), request.ResourceName)
+	}
 
-	var buf []byte
-	if limit > 0 {
-		buf = make([]byte, limit)
-	} else {
-		buf = make([]byte, 1024*1024) // 1M buffer is reasonable.
+	bufSize := int64(1024 * 1024) // 1M buffer is reasonable.
+	if limit > 0 && limit < bufSize {
+		bufSize = limit
 	}
-	bytesSent := 0
+	buf := make([]byte, bufSize)
+	var bytesSent int64
 	for limit == 0 || bytesSent < limit {
-		n, err := reader.ReadAt(buf, offset)
+		p := buf
+		if limit > 0 && limit-bytesSent < int64(len(p)) {
+			p = p[:limit-bytesSent]
+		}
+		n, err := reader.ReadAt(p, offset)
 		if n > 0 {
-			if err := stream.Send(&pb.ReadResponse{Data: buf[:n]}); err != nil {
+			if err := stream.Send(&pb.ReadResponse{Data: p[:n]}); err != nil {
 				return grpc.Errorf(grpc.Code(err), "Send(resourceName=%q offset=%d): %v", request.ResourceName, offset, grpc.ErrorDesc(err))
 			}
 		} else if err == nil {
 			return grpc.Errorf(codes.Internal, "nil error on empty read: io.ReaderAt contract violated")
 		}
 		offset += int64(n)
-		bytesSent += n
+		bytesSent += int64(n)
 		if err == io.EOF {
 			break
 		}
@@ -557,6 +557,34 @@ func TestServerRead(t *testing.T) {
 			},
 			readCount:    1,
 			wantResponse: []string{"0123456789"},
+		}, {
+			name:        "test ReadOffset at the end",
+			readHandler: &TestReadHandler{buf: testData},
+			input: &pb.ReadRequest{
+				ResourceName: testName,
+				ReadOffset:   int64(len(testData)),
+			},
+			readCount:    1,
+			wantResponse: []string{},
+		}, {
+			name:        "fails with ReadOffset past the end",
+			readHandler: &TestReadHandler{buf: testData},
+			input: &pb.ReadRequest{
+				ResourceName: testName,
+				ReadOffset:   int64(len(testData)) + 1,
+			},
+			readCount: 1,
+			wantErr:   true,
+		}, {
+			name:        "test ReadLimit=5 with short reads",
+			readHandler: &ShortReadHandler{buf: testData},
+			input: &pb.ReadRequest{
+				ResourceName: testName,
+				ReadOffset:   1,
+				ReadLimit:    5,
+			},
+			readCount:    1,
+			wantResponse: []string{"123", "45"},
 		}, {
 			name:        "fails with UngettableReadHandler",
 			readHandler: &UngettableReadHandler{},
@@ -785,6 +813,30 @@ func (r *TestReadHandler) Close(ctx context.Context, name string) error {
 	return nil
 }
 
+// ShortReadHandler returns an io.ReaderAt that reads at most 3 bytes at a time.
+type ShortReadHandler struct {
+	buf string
+}
+
+func (r *ShortReadHandler) GetReader(ctx context.Context, name string) (io.ReaderAt, error) {
+	return &shortReader{bytes.NewReader([]byte(r.buf))}, nil
+}
+
+func (r *ShortReadHandler) Close(ctx context.Context, name string) error {
+	return nil
+}
+
+type shortReader struct {
+	r io.ReaderAt
+}
+
+func (r *shortReader) ReadAt(p []byte, offset int64) (int, error) {
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	return r.r.ReadAt(p, offset)
+}
+
 type UngettableReadHandler struct{}
 
 func (r *UngettableReadHandler) GetReader(ctx context.Context, name string) (io.ReaderAt, error) {
@@ -0,0 +1,247 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package bytestream
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/api/transport/bytestream/internal"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// faultInjector breaks server streams after a number of messages have been
+// received (Write) or sent (Read).
+type faultInjector struct {
+	mu      sync.Mutex
+	after   int // messages to let through on a stream before breaking it
+	faults  int // number of streams left to break
+	streams int
+}
+
+func (f *faultInjector) intercept(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	f.mu.Lock()
+	f.streams++
+	fail := f.faults > 0
+	if fail {
+		f.faults--
+	}
+	f.mu.Unlock()
+	if !fail {
+		return handler(srv, ss)
+	}
+	fs := &faultyStream{ServerStream: ss, after: f.after}
+	err := handler(srv, fs)
+	if fs.broken {
+		return status.Error(codes.Unavailable, "injected fault")
+	}
+	return err
+}
+
+type faultyStream struct {
+	grpc.ServerStream
+	after  int
+	n      int
+	broken bool
+}
+
+func (s *faultyStream) RecvMsg(m interface{}) error {
+	if s.n >= s.after {
+		s.broken = true
+		return status.Error(codes.Unavailable, "injected fault")
+	}
+	s.n++
+	return s.ServerStream.RecvMsg(m)
+}
+
+func (s *faultyStream) SendMsg(m interface{}) error {
+	if s.n >= s.after {
+		s.broken = true
+		return status.Error(codes.Unavailable, "injected fault")
+	}
+	s.n++
+	return s.ServerStream.SendMsg(m)
+}
+
+// resumableWriteHandler stores each resource, honoring the write offset.
+type resumableWriteHandler struct {
+	mu   sync.Mutex
+	data map[string][]byte
+	done map[string][]byte
+}
+
+func (h *resumableWriteHandler) GetWriter(ctx context.Context, name string, initOffset int64) (io.Writer, error) {
+	return writerFunc(func(p []byte) (int, error) {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		h.data[name] = append(h.data[name][:initOffset], p...)
+		return len(p), nil
+	}), nil
+}
+
+func (h *resumableWriteHandler) Close(ctx context.Context, name string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.done[name] = h.data[name]
+	return nil
+}
+
+type writerFunc func([]byte) (int, error)
+
+func (f writerFunc) Write(p []byte) (int, error) { return f(p) }
+
+// chunkedReadHandler serves data a few bytes per ReadResponse.
+type chunkedReadHandler struct {
+	data string
+}
+
+func (h *chunkedReadHandler) GetReader(ctx context.Context, name string) (io.ReaderAt, error) {
+	return chunkedReaderAt{strings.NewReader(h.data)}, nil
+}
+
+func (h *chunkedReadHandler) Close(ctx context.Context, name string) error { return nil }
+
+type chunkedReaderAt struct{ r io.ReaderAt }
+
+func (c chunkedReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	return c.r.ReadAt(p, off)
+}
+
+func newFaultySetup(t *testing.T, fi *faultInjector, rh internal.ReadHandler, wh internal.WriteHandler) (*Client, func()) {
+	l, err := newGRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Gsrv = grpc.NewServer(grpc.StreamInterceptor(fi.intercept))
+	if _, err := internal.NewServer(l.Gsrv, rh, wh); err != nil {
+		t.Fatal(err)
+	}
+	l.Start()
+	conn, err := grpc.Dial(l.Addr, grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return NewClient(conn), func() {
+		conn.Close()
+		l.Close()
+	}
+}
+
+var testRetryPolicy = &RetryPolicy{
+	MaxRetries: 3,
+	Backoff:    gax.Backoff{Initial: time.Millisecond, Max: time.Millisecond},
+}
+
+func TestReaderRetry(t *testing.T) {
+	const data = "0123456789abcdefghij"
+	for _, tc := range []struct {
+		name    string
+		policy  *RetryPolicy
+		faults  int
+		wantErr bool
+	}{
+		{name: "no policy", faults: 1, wantErr: true},
+		{name: "one fault", policy: testRetryPolicy, faults: 1},
+		{name: "too many faults", policy: testRetryPolicy, faults: 5, wantErr: true},
+	} {
+		fi := &faultInjector{after: 2, faults: tc.faults}
+		client, cleanup := newFaultySetup(t, fi, &chunkedReadHandler{data: data}, nil)
+		client.Retry = tc.policy
+		r, err := client.NewReaderAt(context.Background(), "name", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: got err %v, want error %t", tc.name, err, tc.wantErr)
+		}
+		if !tc.wantErr && string(got) != data {
+			t.Errorf("%s: got %q, want %q", tc.name, got, data)
+		}
+		cleanup()
+	}
+}
+
+func TestWriterResume(t *testing.T) {
+	const data = "0123456789abcdefghij"
+	for _, tc := range []struct {
+		name    string
+		policy  *RetryPolicy
+		faults  int
+		after   int
+		wantErr bool
+	}{
+		{name: "no policy", faults: 1, after: 2, wantErr: true},
+		{name: "one fault", policy: testRetryPolicy, faults: 1, after: 2},
+		{name: "two faults", policy: testRetryPolicy, faults: 2, after: 2},
+		// Nothing is ever committed, so the retries run out.
+		{name: "too many faults", policy: testRetryPolicy, faults: 10, after: 0, wantErr: true},
+	} {
+		// The QueryWriteStatus calls are unary, so only the Write streams
+		// are broken.
+		fi := &faultInjector{after: tc.after, faults: tc.faults}
+		wh := &resumableWriteHandler{data: map[string][]byte{}, done: map[string][]byte{}}
+		client, cleanup := newFaultySetup(t, fi, nil, wh)
+		client.Retry = tc.policy
+		w, err := client.NewWriter(context.Background(), "name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var werr error
+		for i := 0; i < len(data) && werr == nil; i += 4 {
+			_, werr = w.Write([]byte(data[i : i+4]))
+		}
+		cerr := w.Close()
+		if werr == nil {
+			werr = cerr
+		}
+		if (werr != nil) != tc.wantErr {
+			t.Errorf("%s: got err %v, want error %t", tc.name, werr, tc.wantErr)
+		}
+		if !tc.wantErr {
+			if got := wh.done["name"]; !bytes.Equal(got, []byte(data)) {
+				t.Errorf("%s: server got %q, want %q", tc.name, got, data)
+			}
+		}
+		cleanup()
+	}
+}
+
+func TestWriterResume_BufferTooSmall(t *testing.T) {
+	fi := &faultInjector{after: 1, faults: 1}
+	wh := &resumableWriteHandler{data: map[string][]byte{}, done: map[string][]byte{}}
+	client, cleanup := newFaultySetup(t, fi, nil, wh)
+	defer cleanup()
+	policy := *testRetryPolicy
+	policy.ResumeBufferSize = 1
+	client.Retry = &policy
+	w, err := client.NewWriter(context.Background(), "name")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 5; i++ {
+		if _, err = w.Write([]byte("0123")); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Close()
+	}
+	if err == nil {
+		t.Error("got nil error, want error as the uncommitted bytes can't be resent")
+	}
+}
@@ -133,6 +133,7 @@ func dial(ctx context.Context, insecure bool, o *internal.DialSettings) (*grpc.C
 		if o.QuotaProject == "" {
 			o.QuotaProject = internal.QuotaProjectFromCreds(creds)
 		}
+		ts := internal.AsyncRefreshTokenSource(creds.TokenSource, o.TokenRefreshFraction)
 
 		// Attempt Direct Path only if:
 		// * The endpoint is a host:port (or dns:///host:port).
@@ -172,7 +173,7 @@ func dial(ctx context.Context, insecure bool, o *internal.DialSettings) (*grpc.C
 			}
 			grpcOpts = []grpc.DialOption{
 				grpc.WithPerRPCCredentials(grpcTokenSource{
-					TokenSource:   oauth.TokenSource{creds.TokenSource},
+					TokenSource:   oauth.TokenSource{TokenSource: ts},
 					quotaProject:  o.QuotaProject,
 					requestReason: o.RequestReason,
 				}),
@@ -181,7 +182,7 @@ func dial(ctx context.Context, insecure bool, o *internal.DialSettings) (*grpc.C
 		} else {
 			grpcOpts = []grpc.DialOption{
 				grpc.WithPerRPCCredentials(grpcTokenSource{
-					TokenSource:   oauth.TokenSource{creds.TokenSource},
+					TokenSource:   oauth.TokenSource{TokenSource: ts},
 					quotaProject:  o.QuotaProject,
 					requestReason: o.RequestReason,
 				}),
@@ -0,0 +1,88 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+// minCompressSize is the size of the smallest request body that is compressed.
+// Compressing smaller bodies saves little and costs CPU on both sides.
+const minCompressSize = 1 << 10
+
+// compressTransport gzips the bodies of requests that are worth compressing.
+// Retries keep working: the compressed request gets a GetBody, and a request
+// that is sent again, with the body from its own GetBody, is compressed again.
+type compressTransport struct {
+	base http.RoundTripper
+}
+
+func (t *compressTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !shouldCompress(req) {
+		return t.base.RoundTrip(req)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, err := io.Copy(zw, req.Body)
+	req.Body.Close()
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	b := buf.Bytes()
+	newReq := *req
+	newReq.Header = make(http.Header)
+	for k, vv := range req.Header {
+		newReq.Header[k] = vv
+	}
+	newReq.Header.Set("Content-Encoding", "gzip")
+	newReq.ContentLength = int64(len(b))
+	newReq.GetBody = func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(b)), nil
+	}
+	newReq.Body, _ = newReq.GetBody()
+	return t.base.RoundTrip(&newReq)
+}
+
+// shouldCompress reports whether the body of req is worth compressing: it is
+// big enough, not already encoded, not a chunk of a resumable upload, and of
+// a type that compresses well. Media of other types, like images and
+// archives, is usually compressed already.
+func shouldCompress(req *http.Request) bool {
+	if req.Body == nil || req.Body == http.NoBody || req.ContentLength < minCompressSize {
+		return false
+	}
+	if req.Header.Get("Content-Encoding") != "" || req.Header.Get("Content-Range") != "" {
+		return false
+	}
+	return compressibleType(req.Header.Get("Content-Type"))
+}
+
+// compressibleType reports whether bodies of the content type ct usually
+// compress well.
+func compressibleType(ct string) bool {
+	mt, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	switch {
+	case strings.HasPrefix(mt, "text/"),
+		strings.HasSuffix(mt, "+json"), strings.HasSuffix(mt, "+xml"):
+		return true
+	}
+	switch mt {
+	case "application/json", "application/xml", "application/javascript", "application/x-ndjson":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,99 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"google.golang.org/api/option"
+)
+
+func TestShouldCompress(t *testing.T) {
+	big := strings.Repeat("x", minCompressSize)
+	for _, test := range []struct {
+		body    string
+		headers map[string]string
+		want    bool
+	}{
+		{big, map[string]string{"Content-Type": "application/json"}, true},
+		{big, map[string]string{"Content-Type": "application/json; charset=utf-8"}, true},
+		{big, map[string]string{"Content-Type": "text/csv"}, true},
+		{big, map[string]string{"Content-Type": "application/atom+xml"}, true},
+		{big[1:], map[string]string{"Content-Type": "application/json"}, false},
+		{big, map[string]string{"Content-Type": "image/png"}, false},
+		{big, map[string]string{"Content-Type": "application/gzip"}, false},
+		{big, map[string]string{"Content-Type": "multipart/related; boundary=x"}, false},
+		{big, map[string]string{}, false},
+		{big, map[string]string{"Content-Type": "application/json", "Content-Encoding": "gzip"}, false},
+		{big, map[string]string{"Content-Type": "text/plain", "Content-Range": "bytes 0-1023/*"}, false},
+		{"", map[string]string{"Content-Type": "application/json"}, false},
+	} {
+		req, _ := http.NewRequest("POST", "https://example.com", strings.NewReader(test.body))
+		for k, v := range test.headers {
+			req.Header.Set(k, v)
+		}
+		if got := shouldCompress(req); got != test.want {
+			t.Errorf("shouldCompress(%d bytes, %v) = %t, want %t", len(test.body), test.headers, got, test.want)
+		}
+	}
+}
+
+func TestRequestCompression(t *testing.T) {
+	body := `{"rows": [` + strings.Repeat(`{"json": {"a": 1}},`, 200) + `{}]}`
+	var got []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if enc := r.Header.Get("Content-Encoding"); enc != "gzip" {
+			t.Errorf("got Content-Encoding %q, want gzip", enc)
+		}
+		zr, err := gzip.NewReader(r.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		b, err := ioutil.ReadAll(zr)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		got = append(got, string(b))
+		if len(got) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	trans, err := NewTransport(context.Background(), ts.Client().Transport,
+		option.WithoutAuthentication(), option.WithTelemetryDisabled(), option.WithRequestCompression("gzip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: trans}
+	req, _ := http.NewRequest("POST", ts.URL, bytes.NewBufferString(body))
+	req.Header.Set("Content-Type", "application/json")
+	// Send the request twice, the second time with the body from GetBody, as
+	// gensupport does when it retries.
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			if req.Body, err = req.GetBody(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+	}
+	if len(got) != 2 || got[0] != body || got[1] != body {
+		t.Errorf("server got bodies %q, want %q twice", got, body)
+	}
+}
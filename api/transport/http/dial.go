@@ -69,6 +69,9 @@ func newTransport(ctx context.Context, base http.RoundTripper, settings *interna
 		requestReason: settings.RequestReason,
 	}
 	var trans http.RoundTripper = paramTransport
+	if settings.RequestCompression != "" {
+		trans = &compressTransport{base: trans}
+	}
 	trans = addOCTransport(trans, settings)
 	switch {
 	case settings.NoAuth:
@@ -93,8 +96,15 @@ func newTransport(ctx context.Context, base http.RoundTripper, settings *interna
 		}
 		trans = &oauth2.Transport{
 			Base:   trans,
-			Source: ts,
+			Source: internal.AsyncRefreshTokenSource(ts, settings.TokenRefreshFraction),
+		}
+	}
+	if len(settings.RetryHooks) > 0 || settings.LogRetries {
+		hooks := settings.RetryHooks
+		if settings.LogRetries {
+			hooks = append(hooks[:len(hooks):len(hooks)], logRetry(settings.RetryLogger))
 		}
+		trans = &retryHookTransport{base: trans, hooks: hooks}
 	}
 	return trans, nil
 }
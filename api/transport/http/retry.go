@@ -0,0 +1,49 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"time"
+)
+
+// retryHookTransport calls hooks when generated clients retry requests sent
+// through it. gensupport finds it by its ObserveRetry method.
+type retryHookTransport struct {
+	base  http.RoundTripper
+	hooks []func(ctx context.Context, resp *http.Response, err error, delay time.Duration)
+}
+
+func (t *retryHookTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	return t.base.RoundTrip(req)
+}
+
+// ObserveRetry calls the hooks of t in order.
+func (t *retryHookTransport) ObserveRetry(ctx context.Context, resp *http.Response, err error, delay time.Duration) {
+	for _, h := range t.hooks {
+		h(ctx, resp, err, delay)
+	}
+}
+
+// logRetry returns a retry hook that logs to l, or to the standard logger if l
+// is nil. The query of the URL is left out, since it may hold an API key.
+func logRetry(l *log.Logger) func(context.Context, *http.Response, error, time.Duration) {
+	printf := log.Printf
+	if l != nil {
+		printf = l.Printf
+	}
+	return func(ctx context.Context, resp *http.Response, err error, delay time.Duration) {
+		switch {
+		case err != nil:
+			printf("retrying request in %v after error: %v", delay, err)
+		case resp.Request != nil:
+			printf("retrying %s %s://%s%s in %v after status %d", resp.Request.Method, resp.Request.URL.Scheme, resp.Request.URL.Host, resp.Request.URL.Path, delay, resp.StatusCode)
+		default:
+			printf("retrying request in %v after status %d", delay, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2020 Google LLC.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package http
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"google.golang.org/api/internal/gensupport"
+	"google.golang.org/api/option"
+)
+
+func TestRetryHooks(t *testing.T) {
+	var calls int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		if calls == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer ts.Close()
+
+	var statuses []int
+	var logs bytes.Buffer
+	trans, err := NewTransport(context.Background(), ts.Client().Transport,
+		option.WithoutAuthentication(), option.WithTelemetryDisabled(),
+		option.WithRetryHook(func(ctx context.Context, resp *http.Response, err error, delay time.Duration) {
+			statuses = append(statuses, resp.StatusCode)
+		}),
+		option.WithRetryLogger(log.New(&logs, "", 0)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req, _ := http.NewRequest("POST", ts.URL+"/path?key=secret", strings.NewReader("{}"))
+	resp, err := gensupport.SendRequestWithRetry(context.Background(), &http.Client{Transport: trans}, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if len(statuses) != 1 || statuses[0] != http.StatusServiceUnavailable {
+		t.Errorf("retry hook got statuses %v, want [503]", statuses)
+	}
+	got := logs.String()
+	if want := "retrying POST " + ts.URL + "/path in "; !strings.HasPrefix(got, want) || !strings.Contains(got, "after status 503") {
+		t.Errorf("got log %q, want prefix %q and the status", got, want)
+	}
+	if strings.Contains(got, "secret") {
+		t.Errorf("log %q contains the query", got)
+	}
+}
+
+func TestRetryHooksWithHTTPClient(t *testing.T) {
+	_, _, err := NewClient(context.Background(), option.WithHTTPClient(http.DefaultClient),
+		option.WithRetryLogger(nil))
+	if err == nil {
+		t.Error("got nil error, want error for WithRetryLogger with WithHTTPClient")
+	}
+}
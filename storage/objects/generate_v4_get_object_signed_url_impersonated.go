@@ -0,0 +1,71 @@
+// Copyright 2020 Google LLC
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     https://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package objects
+
+// [START storage_generate_signed_url_v4_impersonated]
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"time"
+
+	"cloud.google.com/go/storage"
+	iamcredentials "google.golang.org/api/iamcredentials/v1"
+)
+
+// generateV4GetObjectSignedURLImpersonated generates object signed URL with
+// GET method, signed by an impersonated service account. No private key is
+// needed: the caller's default credentials must have the
+// roles/iam.serviceAccountTokenCreator role on serviceAccountEmail.
+func generateV4GetObjectSignedURLImpersonated(w io.Writer, bucket, object, serviceAccountEmail string) (string, error) {
+	// bucket := "bucket-name"
+	// object := "object-name"
+	// serviceAccountEmail := "signer@my-project.iam.gserviceaccount.com"
+	ctx := context.Background()
+	iamService, err := iamcredentials.NewService(ctx)
+	if err != nil {
+		return "", fmt.Errorf("iamcredentials.NewService: %v", err)
+	}
+	name := fmt.Sprintf("projects/-/serviceAccounts/%s", serviceAccountEmail)
+	opts := &storage.SignedURLOptions{
+		Scheme:         storage.SigningSchemeV4,
+		Method:         "GET",
+		GoogleAccessID: serviceAccountEmail,
+		SignBytes: func(b []byte) ([]byte, error) {
+			req := &iamcredentials.SignBlobRequest{
+				Payload: base64.StdEncoding.EncodeToString(b),
+			}
+			resp, err := iamService.Projects.ServiceAccounts.SignBlob(name, req).Context(ctx).Do()
+			if err != nil {
+				return nil, err
+			}
+			return base64.StdEncoding.DecodeString(resp.SignedBlob)
+		},
+		Expires: time.Now().Add(15 * time.Minute),
+	}
+	u, err := storage.SignedURL(bucket, object, opts)
+	if err != nil {
+		return "", fmt.Errorf("storage.SignedURL: %v", err)
+	}
+
+	fmt.Fprintln(w, "Generated GET signed URL:")
+	fmt.Fprintf(w, "%q\n", u)
+	fmt.Fprintln(w, "You can use this URL with any user agent, for example:")
+	fmt.Fprintf(w, "curl %q\n", u)
+	return u, nil
+}
+
+// [END storage_generate_signed_url_v4_impersonated]
@@ -19,6 +19,7 @@ import (
 	"context"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"strings"
 	"testing"
@@ -252,3 +253,50 @@ func TestKMSObjects(t *testing.T) {
 		}
 	})
 }
+
+func TestV4SignedURLImpersonated(t *testing.T) {
+	tc := testutil.SystemTest(t)
+	ctx := context.Background()
+
+	signer := os.Getenv("GOLANG_SAMPLES_SIGNER_SERVICE_ACCOUNT")
+	if signer == "" {
+		t.Skip("GOLANG_SAMPLES_SIGNER_SERVICE_ACCOUNT must be set")
+	}
+
+	bucket := tc.ProjectID + "-samples-object-bucket-3"
+	object := "foo.txt"
+
+	testutil.CleanBucket(ctx, t, tc.ProjectID, bucket)
+
+	if err := uploadFile(ioutil.Discard, bucket, object); err != nil {
+		t.Fatalf("uploadFile(%q): %v", object, err)
+	}
+	var buf bytes.Buffer
+	u, err := generateV4GetObjectSignedURLImpersonated(&buf, bucket, object, signer)
+	if err != nil {
+		t.Fatalf("generateV4GetObjectSignedURLImpersonated: %v", err)
+	}
+	if got, want := buf.String(), "Generated GET signed URL:"; !strings.Contains(got, want) {
+		t.Errorf("got %q, want to contain %q", got, want)
+	}
+
+	resp, err := http.Get(u)
+	if err != nil {
+		t.Fatalf("http.Get(%q): %v", u, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("http.Get(%q): got status %d, want %d", u, resp.StatusCode, http.StatusOK)
+	}
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll: %v", err)
+	}
+	if got, want := string(data), "Hello\nworld"; got != want {
+		t.Errorf("contents = %q; want %q", got, want)
+	}
+
+	if err := deleteFile(ioutil.Discard, bucket, object); err != nil {
+		t.Errorf("deleteFile: %v", err)
+	}
+}